@@ -0,0 +1,482 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenRefreshBefore = time.Minute
+	defaultTokenFetchTimeout  = 30 * time.Second
+)
+
+// ErrAuthentication is returned when credentials for a request cannot be obtained
+var ErrAuthentication = errors.New("could not authenticate the request")
+
+// Authenticator adds credentials to requests
+type Authenticator interface {
+	// Authenticate adds credentials to req, which the client has already copied
+	Authenticate(req *http.Request) error
+}
+
+// Refresher is implemented by authenticators whose credentials can be renewed. When the service answers 401
+// Unauthorized, Refresh is called with the request that was rejected, and the request is sent once more with new
+// credentials if it returns true.
+type Refresher interface {
+	Refresh(req *http.Request) bool
+}
+
+// WithAuth adds credentials from a to every request. Headers or query parameters set by the caller are not replaced.
+func WithAuth(a Authenticator) Option {
+	return func(c *client) {
+		c.auth = a
+	}
+}
+
+// credentialHeaders are the headers identifying who a request is made for
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// callerCredentials reports whether h carries credentials the caller passed to a call, rather than ones sent with
+// every call through WithAuth or WithDefaultHeaders. Responses to such calls belong to that caller alone.
+func (c *client) callerCredentials(h http.Header) bool {
+	names := credentialHeaders
+	if a, ok := c.auth.(headerAuth); ok {
+		names = append([]string{a.name}, names...)
+	}
+
+	for _, name := range names {
+		if v := h.Get(name); v != "" && v != c.defaultHeaders.Get(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type callHostKey struct{}
+
+// withCallHost returns a context noting host as the one the call made with it is sent to
+func withCallHost(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, callHostKey{}, host)
+}
+
+// redirectedElsewhere reports whether req follows a redirect to a host other than the one its call was sent to. As
+// net/http strips credentials from such requests, the client must not add them back.
+func redirectedElsewhere(req *http.Request) bool {
+	if req.Response == nil {
+		return false
+	}
+	host, ok := req.Context().Value(callHostKey{}).(string)
+	if !ok {
+		// Without a note of the host, the first request of the chain tells where the call was sent
+		r := req
+		for r.Response != nil && r.Response.Request != nil {
+			r = r.Response.Request
+		}
+		host = r.URL.Host
+	}
+
+	return !strings.EqualFold(req.URL.Host, host)
+}
+
+type withheldCredentialsKey struct{}
+
+// withholdCredentials returns a context whose requests go out without the credentials, signature or propagated
+// identity the client would otherwise add
+func withholdCredentials(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withheldCredentialsKey{}, true)
+}
+
+// credentialsWithheld reports whether requests made with ctx must go out without credentials
+func credentialsWithheld(ctx context.Context) bool {
+	withheld, _ := ctx.Value(withheldCredentialsKey{}).(bool)
+	return withheld
+}
+
+// withoutCredentials returns a copy of h without any credential headers, as net/http does on redirects to another host
+func (c *client) withoutCredentials(h http.Header) http.Header {
+	h = cloneHeader(h)
+	for _, name := range credentialHeaders {
+		h.Del(name)
+	}
+	if a, ok := c.auth.(headerAuth); ok {
+		h.Del(a.name)
+	}
+
+	return h
+}
+
+// BearerToken authenticates with a static bearer token
+func BearerToken(token string) Authenticator {
+	return headerAuth{name: "Authorization", value: "Bearer " + token}
+}
+
+// APIKeyHeader authenticates by sending key in the header name
+func APIKeyHeader(name string, key string) Authenticator {
+	return headerAuth{name: name, value: key}
+}
+
+type headerAuth struct {
+	name  string
+	value string
+}
+
+func (a headerAuth) Authenticate(req *http.Request) error {
+	if req.Header.Get(a.name) == "" {
+		req.Header.Set(a.name, a.value)
+	}
+
+	return nil
+}
+
+// APIKeyQuery authenticates by sending key in the query parameter param
+func APIKeyQuery(param string, key string) Authenticator {
+	return queryAuth{param: param, key: key}
+}
+
+type queryAuth struct {
+	param string
+	key   string
+}
+
+func (a queryAuth) Authenticate(req *http.Request) error {
+	query := req.URL.Query()
+	if query.Get(a.param) == "" {
+		query.Set(a.param, a.key)
+		u := *req.URL
+		u.RawQuery = query.Encode()
+		req.URL = &u
+	}
+
+	return nil
+}
+
+// Token is an access token
+type Token struct {
+	AccessToken string
+	// TokenType is the scheme the token is sent with; defaults to Bearer
+	TokenType string
+	// Expiry is when the token expires; the zero time means it does not
+	Expiry time.Time
+}
+
+// TokenSource provides access tokens
+type TokenSource interface {
+	Token(ctx context.Context) (Token, error)
+}
+
+// TokenAuth authenticates with tokens from ts, sent in the Authorization header. If ts has an Invalidate(accessToken
+// string) method, as ClientCredentials does, a token rejected with 401 Unauthorized is invalidated and the request is
+// sent once more with a fresh one. Requests carrying an Authorization header of the caller's own are sent as they are,
+// and a 401 answering them is returned without invalidating anything.
+func TokenAuth(ts TokenSource) Authenticator {
+	return tokenAuth{source: ts}
+}
+
+type tokenAuth struct {
+	source TokenSource
+}
+
+func (a tokenAuth) Authenticate(req *http.Request) error {
+	if req.Header.Get("Authorization") != "" {
+		return nil
+	}
+
+	tok, err := a.source.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization(tok))
+
+	return nil
+}
+
+func (a tokenAuth) Refresh(req *http.Request) bool {
+	inv, ok := a.source.(interface{ Invalidate(accessToken string) })
+	if !ok {
+		return false
+	}
+	// A token the caller sent is not one of ours to invalidate
+	if supplied, _ := req.Context().Value(callerAuthorizationKey{}).(bool); supplied {
+		return false
+	}
+
+	scheme, token := splitAuthorization(req.Header.Get("Authorization"))
+	if token == "" || scheme == "" {
+		return false
+	}
+	inv.Invalidate(token)
+
+	return true
+}
+
+// authorization formats tok as the value of an Authorization header
+func authorization(tok Token) string {
+	scheme := tok.TokenType
+	if scheme == "" || strings.EqualFold(scheme, "bearer") {
+		scheme = "Bearer"
+	}
+
+	return scheme + " " + tok.AccessToken
+}
+
+func splitAuthorization(v string) (string, string) {
+	i := strings.IndexByte(v, ' ')
+	if i < 0 {
+		return "", ""
+	}
+
+	return v[:i], strings.TrimSpace(v[i+1:])
+}
+
+// ClientCredentialsConfig describes an OAuth2 client credentials grant
+type ClientCredentialsConfig struct {
+	// TokenURL is the token endpoint of the authorization server
+	TokenURL string
+	// ClientID and ClientSecret identify the client. They are sent with HTTP Basic authentication unless
+	// SecretInBody is set, which sends them in the form instead.
+	ClientID     string
+	ClientSecret string
+	SecretInBody bool
+	// Scopes are the scopes asked for
+	Scopes []string
+	// EndpointParams are added to the form sent to the token endpoint, such as an audience
+	EndpointParams url.Values
+	// RefreshBefore is how long before a token expires a new one is fetched; defaults to 1 minute
+	RefreshBefore time.Duration
+	// Timeout bounds each request to the token endpoint; defaults to 30s
+	Timeout time.Duration
+	// HTTPClient makes the requests to the token endpoint; defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// ClientCredentials is a TokenSource for the OAuth2 client credentials grant. It caches its token and fetches a new
+// one in the background once the token is within RefreshBefore of expiring. Concurrent callers needing a token share a
+// single request to the token endpoint.
+type ClientCredentials struct {
+	cfg ClientCredentialsConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	token    *Token
+	inflight *tokenFetch
+}
+
+type tokenFetch struct {
+	done  chan struct{}
+	token Token
+	err   error
+}
+
+// NewClientCredentials creates a ClientCredentials token source for cfg
+func NewClientCredentials(cfg ClientCredentialsConfig) *ClientCredentials {
+	if cfg.RefreshBefore <= 0 {
+		cfg.RefreshBefore = defaultTokenRefreshBefore
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTokenFetchTimeout
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+
+	return &ClientCredentials{cfg: cfg, now: time.Now}
+}
+
+// Token returns the cached token, fetching a new one when there is none or it has expired
+func (cc *ClientCredentials) Token(ctx context.Context) (Token, error) {
+	cc.mu.Lock()
+	now := cc.now()
+	if tok := cc.token; tok != nil && (tok.Expiry.IsZero() || now.Before(tok.Expiry)) {
+		if !tok.Expiry.IsZero() && !now.Before(tok.Expiry.Add(-cc.cfg.RefreshBefore)) {
+			cc.startFetch()
+		}
+		cc.mu.Unlock()
+		return *tok, nil
+	}
+	f := cc.startFetch()
+	cc.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.token, f.err
+	case <-ctx.Done():
+		return Token{}, ctx.Err()
+	}
+}
+
+// Invalidate discards the cached token if it is accessToken, so the next call to Token fetches a new one
+func (cc *ClientCredentials) Invalidate(accessToken string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.token != nil && cc.token.AccessToken == accessToken {
+		cc.token = nil
+	}
+}
+
+// startFetch returns the fetch in flight, starting one if there is none. The caller must hold cc.mu.
+func (cc *ClientCredentials) startFetch() *tokenFetch {
+	if cc.inflight != nil {
+		return cc.inflight
+	}
+
+	f := &tokenFetch{done: make(chan struct{})}
+	cc.inflight = f
+	go func() {
+		// The fetch is shared by every waiting caller, so it does not follow any one caller's context
+		ctx, cancel := context.WithTimeout(context.Background(), cc.cfg.Timeout)
+		defer cancel()
+		f.token, f.err = cc.fetch(ctx)
+
+		cc.mu.Lock()
+		if f.err == nil {
+			tok := f.token
+			cc.token = &tok
+		}
+		cc.inflight = nil
+		cc.mu.Unlock()
+		close(f.done)
+	}()
+
+	return f
+}
+
+// fetch asks the token endpoint for a new token
+func (cc *ClientCredentials) fetch(ctx context.Context) (Token, error) {
+	form := url.Values{}
+	for k, v := range cc.cfg.EndpointParams {
+		form[k] = append([]string(nil), v...)
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(cc.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(cc.cfg.Scopes, " "))
+	}
+	if cc.cfg.SecretInBody {
+		form.Set("client_id", cc.cfg.ClientID)
+		form.Set("client_secret", cc.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cc.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, fmt.Errorf("%w: %v", ErrAuthentication, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !cc.cfg.SecretInBody {
+		req.SetBasicAuth(url.QueryEscape(cc.cfg.ClientID), url.QueryEscape(cc.cfg.ClientSecret))
+	}
+
+	start := cc.now()
+	resp, err := cc.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("%w: token request failed: %v", ErrAuthentication, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Token{}, fmt.Errorf("%w: reading token response: %v", ErrAuthentication, err)
+	}
+
+	var tr struct {
+		AccessToken      string          `json:"access_token"`
+		TokenType        string          `json:"token_type"`
+		ExpiresIn        json.RawMessage `json:"expires_in"`
+		Error            string          `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+	jsonErr := json.Unmarshal(body, &tr)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		if jsonErr == nil && tr.Error != "" {
+			return Token{}, fmt.Errorf("%w: token endpoint answered %d: %s %s", ErrAuthentication, resp.StatusCode, tr.Error, tr.ErrorDescription)
+		}
+		return Token{}, fmt.Errorf("%w: token endpoint answered %d", ErrAuthentication, resp.StatusCode)
+	}
+	if jsonErr != nil {
+		return Token{}, fmt.Errorf("%w: decoding token response: %v", ErrAuthentication, jsonErr)
+	}
+	if tr.AccessToken == "" {
+		return Token{}, fmt.Errorf("%w: token response has no access_token", ErrAuthentication)
+	}
+
+	tok := Token{AccessToken: tr.AccessToken, TokenType: tr.TokenType}
+	// Some servers send expires_in as a string
+	if secs, err := strconv.ParseInt(strings.Trim(string(tr.ExpiresIn), `"`), 10, 64); err == nil && secs > 0 {
+		tok.Expiry = start.Add(time.Duration(secs) * time.Second)
+	}
+
+	return tok, nil
+}
+
+// authTransport adds credentials to requests, renewing them once when the service rejects them
+type authTransport struct {
+	next http.RoundTripper
+	auth Authenticator
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if credentialsWithheld(req.Context()) || redirectedElsewhere(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	r, err := t.authenticate(req)
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	refresher, ok := t.auth.(Refresher)
+	if !ok || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) || !refresher.Refresh(r) {
+		return resp, nil
+	}
+
+	retry, err := t.authenticate(req)
+	if err == nil && req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+	}
+	if err != nil {
+		// Keep the 401 rather than hide it behind a failure to renew the credentials
+		return resp, nil
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	return t.next.RoundTrip(retry)
+}
+
+type callerAuthorizationKey struct{}
+
+// authenticate returns a copy of req carrying credentials. Whether req already had an Authorization header is noted in
+// the context of the copy, so authenticators can tell it apart from the ones they set.
+func (t *authTransport) authenticate(req *http.Request) (*http.Request, error) {
+	ctx := req.Context()
+	if req.Header.Get("Authorization") != "" {
+		ctx = context.WithValue(ctx, callerAuthorizationKey{}, true)
+	}
+
+	r := req.Clone(ctx)
+	if err := t.auth.Authenticate(r); err != nil {
+		if req.Context().Err() == nil && !errors.Is(err, ErrAuthentication) {
+			err = fmt.Errorf("%w: %v", ErrAuthentication, err)
+		}
+		return nil, err
+	}
+
+	return r, nil
+}
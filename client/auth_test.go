@@ -0,0 +1,363 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_WithAuth(t *testing.T) {
+	tests := map[string]struct {
+		auth     Authenticator
+		headers  http.Header
+		validate func(t *testing.T, r *http.Request)
+	}{
+		"base path- bearer token": {
+			auth: BearerToken("abc"),
+			validate: func(t *testing.T, r *http.Request) {
+				require.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+			},
+		},
+		"base path- API key header": {
+			auth: APIKeyHeader("X-Api-Key", "abc"),
+			validate: func(t *testing.T, r *http.Request) {
+				require.Equal(t, "abc", r.Header.Get("X-Api-Key"))
+			},
+		},
+		"base path- API key query": {
+			auth: APIKeyQuery("api_key", "abc"),
+			validate: func(t *testing.T, r *http.Request) {
+				require.Equal(t, "abc", r.URL.Query().Get("api_key"))
+				require.Equal(t, "1", r.URL.Query().Get("page"))
+			},
+		},
+		"base path- caller's credentials kept": {
+			auth:    BearerToken("abc"),
+			headers: http.Header{"Authorization": []string{"Bearer mine"}},
+			validate: func(t *testing.T, r *http.Request) {
+				require.Equal(t, "Bearer mine", r.Header.Get("Authorization"))
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			received := make(chan *http.Request, 1)
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Clone(context.Background())
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithAuth(tc.auth))
+
+			_, _, err := bc.MakeRequest(context.Background(), http.MethodGet, "/1", url.Values{"page": []string{"1"}}, tc.headers, nil)
+			require.Nil(t, err)
+			tc.validate(t, <-received)
+		})
+	}
+}
+
+// tokenServer is a stand-in OAuth2 token endpoint issuing token-1, token-2, and so on
+type tokenServer struct {
+	*httptest.Server
+	issued    int32
+	expiresIn int
+	fail      bool
+	delay     time.Duration
+	requests  chan url.Values
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	ts := &tokenServer{expiresIn: expiresIn, requests: make(chan url.Values, 100)}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		form := r.PostForm
+		if id, secret, ok := r.BasicAuth(); ok {
+			form.Set("basic", id+":"+secret)
+		}
+		ts.requests <- form
+		time.Sleep(ts.delay)
+
+		w.Header().Set("Content-Type", "application/json")
+		if ts.fail {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = fmt.Fprint(w, `{"error":"invalid_client","error_description":"unknown client"}`)
+			return
+		}
+		n := atomic.AddInt32(&ts.issued, 1)
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":"%d"}`, n, ts.expiresIn)
+	}))
+
+	return ts
+}
+
+func TestUnit_ClientCredentials(t *testing.T) {
+	tests := map[string]struct {
+		cfg      ClientCredentialsConfig
+		validate func(t *testing.T, ts *tokenServer, cc *ClientCredentials, now *time.Time)
+	}{
+		"base path- token fetched once and cached": {
+			cfg: ClientCredentialsConfig{ClientID: "id", ClientSecret: "s&cret", Scopes: []string{"read", "write"}},
+			validate: func(t *testing.T, ts *tokenServer, cc *ClientCredentials, now *time.Time) {
+				for i := 0; i < 3; i++ {
+					tok, err := cc.Token(context.Background())
+					require.NoError(t, err)
+					require.Equal(t, "token-1", tok.AccessToken)
+				}
+				form := <-ts.requests
+				require.Equal(t, "client_credentials", form.Get("grant_type"))
+				require.Equal(t, "read write", form.Get("scope"))
+				require.Equal(t, "id:s%26cret", form.Get("basic"))
+				require.Empty(t, form.Get("client_secret"))
+				require.Equal(t, int32(1), atomic.LoadInt32(&ts.issued))
+			},
+		},
+		"base path- secret sent in the body": {
+			cfg: ClientCredentialsConfig{ClientID: "id", ClientSecret: "secret", SecretInBody: true, EndpointParams: url.Values{"audience": []string{"api"}}},
+			validate: func(t *testing.T, ts *tokenServer, cc *ClientCredentials, now *time.Time) {
+				_, err := cc.Token(context.Background())
+				require.NoError(t, err)
+				form := <-ts.requests
+				require.Equal(t, "id", form.Get("client_id"))
+				require.Equal(t, "secret", form.Get("client_secret"))
+				require.Equal(t, "api", form.Get("audience"))
+				require.Empty(t, form.Get("basic"))
+			},
+		},
+		"base path- concurrent callers share one fetch": {
+			validate: func(t *testing.T, ts *tokenServer, cc *ClientCredentials, now *time.Time) {
+				ts.delay = 50 * time.Millisecond
+				var wg sync.WaitGroup
+				for i := 0; i < 10; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						tok, err := cc.Token(context.Background())
+						require.NoError(t, err)
+						require.Equal(t, "token-1", tok.AccessToken)
+					}()
+				}
+				wg.Wait()
+				require.Equal(t, int32(1), atomic.LoadInt32(&ts.issued))
+			},
+		},
+		"base path- refreshed before expiry": {
+			cfg: ClientCredentialsConfig{RefreshBefore: 10 * time.Minute},
+			validate: func(t *testing.T, ts *tokenServer, cc *ClientCredentials, now *time.Time) {
+				tok, err := cc.Token(context.Background())
+				require.NoError(t, err)
+				require.Equal(t, "token-1", tok.AccessToken)
+				require.Equal(t, now.Add(time.Hour), tok.Expiry)
+
+				// Inside the refresh window the current token is still handed out while a new one is fetched
+				cc.mu.Lock()
+				*now = now.Add(55 * time.Minute)
+				cc.mu.Unlock()
+				tok, err = cc.Token(context.Background())
+				require.NoError(t, err)
+				require.Equal(t, "token-1", tok.AccessToken)
+				require.Eventually(t, func() bool {
+					tok, err := cc.Token(context.Background())
+					return err == nil && tok.AccessToken == "token-2"
+				}, time.Second, 5*time.Millisecond)
+			},
+		},
+		"base path- expired token replaced": {
+			validate: func(t *testing.T, ts *tokenServer, cc *ClientCredentials, now *time.Time) {
+				_, err := cc.Token(context.Background())
+				require.NoError(t, err)
+
+				cc.mu.Lock()
+				*now = now.Add(2 * time.Hour)
+				cc.mu.Unlock()
+				tok, err := cc.Token(context.Background())
+				require.NoError(t, err)
+				require.Equal(t, "token-2", tok.AccessToken)
+			},
+		},
+		"exceptional path- token endpoint error": {
+			validate: func(t *testing.T, ts *tokenServer, cc *ClientCredentials, now *time.Time) {
+				ts.fail = true
+				_, err := cc.Token(context.Background())
+				require.ErrorIs(t, err, ErrAuthentication)
+				require.Contains(t, err.Error(), "invalid_client")
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ts := newTokenServer(t, 3600)
+			defer ts.Close()
+
+			now := time.Unix(1000, 0)
+			cfg := tc.cfg
+			cfg.TokenURL = ts.URL
+			cc := NewClientCredentials(cfg)
+			cc.now = func() time.Time { return now }
+
+			tc.validate(t, ts, cc, &now)
+		})
+	}
+}
+
+func TestUnit_TokenAuth(t *testing.T) {
+	tests := map[string]struct {
+		failTokens bool
+		headers    http.Header
+		validate   func(t *testing.T, ts *tokenServer, calls int32, status int, err error)
+	}{
+		"base path- rejected token replaced once": {
+			validate: func(t *testing.T, ts *tokenServer, calls int32, status int, err error) {
+				require.Nil(t, err)
+				require.Equal(t, http.StatusOK, status)
+				require.Equal(t, int32(2), calls)
+				require.Equal(t, int32(2), atomic.LoadInt32(&ts.issued))
+			},
+		},
+		"exceptional path- caller's rejected token not refreshed": {
+			headers: http.Header{"Authorization": []string{"Bearer mine"}},
+			validate: func(t *testing.T, ts *tokenServer, calls int32, status int, err error) {
+				require.Nil(t, err)
+				require.Equal(t, http.StatusUnauthorized, status)
+				require.Equal(t, int32(1), calls)
+				require.Equal(t, int32(0), atomic.LoadInt32(&ts.issued))
+			},
+		},
+		"exceptional path- token unavailable": {
+			failTokens: true,
+			validate: func(t *testing.T, ts *tokenServer, calls int32, status int, err error) {
+				require.NotNil(t, err)
+				require.Equal(t, int32(0), calls)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ts := newTokenServer(t, 3600)
+			defer ts.Close()
+			ts.fail = tc.failTokens
+
+			var calls int32
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				// Only the second token issued is accepted
+				if r.Header.Get("Authorization") != "Bearer token-2" {
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil,
+				WithAuth(TokenAuth(NewClientCredentials(ClientCredentialsConfig{TokenURL: ts.URL}))))
+
+			status, _, gErr := bc.MakeRequest(context.Background(), http.MethodGet, "/1", nil, tc.headers, nil)
+			var err error
+			if gErr != nil {
+				require.Equal(t, ErrorAuthentication, gErr.Code())
+				err = gErr
+			}
+			tc.validate(t, ts, atomic.LoadInt32(&calls), status, err)
+		})
+	}
+}
+
+func TestUnit_WithAuthRedirect(t *testing.T) {
+	tests := map[string]struct {
+		auth     func(ts *tokenServer) Authenticator
+		sameHost bool
+		validate func(t *testing.T, r *http.Request)
+	}{
+		"base path- bearer token kept on the same host": {
+			auth:     func(ts *tokenServer) Authenticator { return BearerToken("abc") },
+			sameHost: true,
+			validate: func(t *testing.T, r *http.Request) {
+				require.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+			},
+		},
+		"exceptional path- bearer token not sent to another host": {
+			auth: func(ts *tokenServer) Authenticator { return BearerToken("abc") },
+			validate: func(t *testing.T, r *http.Request) {
+				require.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+		"exceptional path- API key header not sent to another host": {
+			auth: func(ts *tokenServer) Authenticator { return APIKeyHeader("X-Api-Key", "abc") },
+			validate: func(t *testing.T, r *http.Request) {
+				require.Empty(t, r.Header.Get("X-Api-Key"))
+			},
+		},
+		"exceptional path- API key query not sent to another host": {
+			auth: func(ts *tokenServer) Authenticator { return APIKeyQuery("api_key", "abc") },
+			validate: func(t *testing.T, r *http.Request) {
+				require.Empty(t, r.URL.Query().Get("api_key"))
+			},
+		},
+		"exceptional path- token neither sent to nor refreshed for another host": {
+			auth: func(ts *tokenServer) Authenticator {
+				return TokenAuth(NewClientCredentials(ClientCredentialsConfig{TokenURL: ts.URL}))
+			},
+			validate: func(t *testing.T, r *http.Request) {
+				require.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ts := newTokenServer(t, 3600)
+			defer ts.Close()
+
+			received := make(chan *http.Request, 2)
+			// The other host rejects whatever it gets, which would prompt a token refresh
+			otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Clone(context.Background())
+				w.WriteHeader(http.StatusUnauthorized)
+			}))
+			defer otherServer.Close()
+
+			var testServer *httptest.Server
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/moved" {
+					received <- r.Clone(context.Background())
+					return
+				}
+				target := otherServer.URL
+				if tc.sameHost {
+					target = testServer.URL
+				}
+				http.Redirect(w, r, target+"/moved", http.StatusFound)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithAuth(tc.auth(ts)))
+
+			_, _, err := bc.MakeRequest(context.Background(), http.MethodGet, "/1", nil, nil, nil)
+			require.Nil(t, err)
+			tc.validate(t, <-received)
+			require.Empty(t, received)
+			require.LessOrEqual(t, atomic.LoadInt32(&ts.issued), int32(1))
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultReplayBufferSize is the largest body of unknown type the client buffers in memory so it can be resent
+const DefaultReplayBufferSize = 1 << 20
+
+// ErrBodyNotReplayable is returned when a request body would need to be sent again but cannot be
+var ErrBodyNotReplayable = errors.New("request body cannot be replayed")
+
+// ReplayableBody is a request body that can be produced again on demand. Pass one as the body of Do or MakeRequest
+// to stream a body, such as a file, that must survive retries, hedged attempts, and redirects.
+type ReplayableBody struct {
+	open func() (io.ReadCloser, error)
+	size int64
+
+	rc io.ReadCloser
+}
+
+// NewReplayableBody creates a ReplayableBody calling open each time the body needs to be sent. size is the length
+// of the body, or -1 if it is not known.
+func NewReplayableBody(open func() (io.ReadCloser, error), size int64) *ReplayableBody {
+	return &ReplayableBody{open: open, size: size}
+}
+
+// Read reads from the first copy of the body, allowing a ReplayableBody to be used as a plain io.Reader
+func (b *ReplayableBody) Read(p []byte) (int, error) {
+	if b.rc == nil {
+		rc, err := b.open()
+		if err != nil {
+			return 0, err
+		}
+		b.rc = rc
+	}
+
+	return b.rc.Read(p)
+}
+
+// WithReplayBufferSize sets the largest body of unknown type buffered so it can be resent; defaults to
+// DefaultReplayBufferSize. A negative size never buffers, so such bodies can only be sent once.
+func WithReplayBufferSize(size int64) Option {
+	return func(c *client) {
+		c.replayBufferSize = size
+	}
+}
+
+// setRequestBody attaches body to req so the request can be sent more than once. Bodies that are in memory, seekable,
+// or a ReplayableBody are resent as is; other bodies are buffered if they are no larger than bufferSize. A
+// StreamingBody and the body of any other request are streamed once and left without GetBody, so the request is never
+// retried, hedged, or redirected with a 307 or 308.
+func setRequestBody(req *http.Request, body io.Reader, bufferSize int64) error {
+	switch b := body.(type) {
+	case nil:
+		return nil
+	case *ReplayableBody:
+		if b.size == 0 {
+			setBytesBody(req, nil)
+			return nil
+		}
+		rc, err := b.open()
+		if err != nil {
+			return err
+		}
+		req.Body = rc
+		req.GetBody = b.open
+		if b.size > 0 {
+			req.ContentLength = b.size
+		}
+		return nil
+	case *bytes.Buffer:
+		setBytesBody(req, b.Bytes())
+		return nil
+	case *jsonBody:
+		setBytesBody(req, b.Bytes())
+		return nil
+	case *bytes.Reader:
+		snapshot := *b
+		req.ContentLength = int64(b.Len())
+		req.Body = ioutil.NopCloser(b)
+		req.GetBody = func() (io.ReadCloser, error) {
+			r := snapshot
+			return ioutil.NopCloser(&r), nil
+		}
+		return nil
+	case *strings.Reader:
+		snapshot := *b
+		req.ContentLength = int64(b.Len())
+		req.Body = ioutil.NopCloser(b)
+		req.GetBody = func() (io.ReadCloser, error) {
+			r := snapshot
+			return ioutil.NopCloser(&r), nil
+		}
+		return nil
+	case io.ReadSeeker:
+		return setSeekerBody(req, b)
+	case *StreamingBody:
+		req.Body = b
+		return nil
+	}
+
+	var buffered []byte
+	if bufferSize >= 0 {
+		by, err := ioutil.ReadAll(io.LimitReader(body, bufferSize+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(by)) <= bufferSize {
+			if c, ok := body.(io.Closer); ok {
+				_ = c.Close()
+			}
+			setBytesBody(req, by)
+			return nil
+		}
+		buffered = by
+	}
+
+	rc := readCloser{Reader: io.MultiReader(bytes.NewReader(buffered), body)}
+	if c, ok := body.(io.Closer); ok {
+		rc.Closer = c
+	}
+	req.Body = rc
+
+	return nil
+}
+
+func setBytesBody(req *http.Request, by []byte) {
+	req.ContentLength = int64(len(by))
+	if len(by) == 0 {
+		req.Body = http.NoBody
+		req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(by))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(by)), nil
+	}
+}
+
+// setSeekerBody replays a seekable body from its current offset. Bodies that also support io.ReaderAt can be read by
+// several attempts at once; others are rewound for each attempt, ending the reads of the attempt before. The body is
+// not closed by the attempts; closeSeekerBody closes it once the call is done.
+func setSeekerBody(req *http.Request, rs io.ReadSeeker) error {
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	req.ContentLength = end - start
+
+	if ra, ok := rs.(io.ReaderAt); ok {
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(io.NewSectionReader(ra, start, end-start)), nil
+		}
+		req.Body, _ = req.GetBody()
+		return nil
+	}
+
+	sb := &seekingBody{rs: rs, start: start}
+	req.GetBody = sb.open
+	req.Body, err = sb.open()
+	return err
+}
+
+// closeSeekerBody closes body, passed to a call, if it is a seekable body whose attempts left it open
+func closeSeekerBody(body io.Reader) {
+	if _, ok := body.(io.ReadSeeker); !ok {
+		return
+	}
+	if c, ok := body.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// seekingBody rewinds a seekable body for each attempt. Only the reader opened last may read it; the transport may
+// still be done with the attempt before when the next one starts.
+type seekingBody struct {
+	rs    io.ReadSeeker
+	start int64
+
+	mu      sync.Mutex
+	current *seekingReader
+}
+
+func (s *seekingBody) open() (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.rs.Seek(s.start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	s.current = &seekingReader{body: s}
+
+	return s.current, nil
+}
+
+type seekingReader struct {
+	body *seekingBody
+}
+
+func (r *seekingReader) Read(p []byte) (int, error) {
+	r.body.mu.Lock()
+	defer r.body.mu.Unlock()
+
+	if r.body.current != r {
+		return 0, ErrBodyNotReplayable
+	}
+
+	return r.body.rs.Read(p)
+}
+
+func (r *seekingReader) Close() error {
+	return nil
+}
+
+// concurrentBody reports whether copies of the body of req can be sent by several attempts at once
+func concurrentBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+
+	_, sequential := req.Body.(*seekingReader)
+	return !sequential
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (rc readCloser) Close() error {
+	if rc.Closer == nil {
+		return nil
+	}
+
+	return rc.Closer.Close()
+}
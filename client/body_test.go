@@ -0,0 +1,288 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// onlySeeker hides every method of the wrapped reader except Read and Seek
+type onlySeeker struct {
+	rs io.ReadSeeker
+}
+
+func (s *onlySeeker) Read(p []byte) (int, error)                   { return s.rs.Read(p) }
+func (s *onlySeeker) Seek(offset int64, whence int) (int64, error) { return s.rs.Seek(offset, whence) }
+
+// onlyReader hides every method of the wrapped reader except Read
+type onlyReader struct {
+	r io.Reader
+}
+
+func (r *onlyReader) Read(p []byte) (int, error) { return r.r.Read(p) }
+
+func TestUnit_setRequestBody(t *testing.T) {
+	readAll := func(t *testing.T, rc io.ReadCloser) string {
+		by, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		return string(by)
+	}
+
+	tests := map[string]struct {
+		body       func() io.Reader
+		bufferSize int64
+		validate   func(t *testing.T, req *http.Request, err error)
+	}{
+		"base path- nil body": {
+			body: func() io.Reader { return nil },
+			validate: func(t *testing.T, req *http.Request, err error) {
+				require.NoError(t, err)
+				require.Nil(t, req.Body)
+				require.Nil(t, req.GetBody)
+			},
+		},
+		"base path- bytes buffer": {
+			body: func() io.Reader { return bytes.NewBufferString("hello") },
+			validate: func(t *testing.T, req *http.Request, err error) {
+				require.NoError(t, err)
+				require.Equal(t, int64(5), req.ContentLength)
+				require.Equal(t, "hello", readAll(t, req.Body))
+				again, err := req.GetBody()
+				require.NoError(t, err)
+				require.Equal(t, "hello", readAll(t, again))
+			},
+		},
+		"base path- strings reader": {
+			body: func() io.Reader { return strings.NewReader("hello") },
+			validate: func(t *testing.T, req *http.Request, err error) {
+				require.NoError(t, err)
+				require.Equal(t, int64(5), req.ContentLength)
+				require.Equal(t, "hello", readAll(t, req.Body))
+				again, err := req.GetBody()
+				require.NoError(t, err)
+				require.Equal(t, "hello", readAll(t, again))
+			},
+		},
+		"base path- seeker replayed from its starting offset": {
+			body: func() io.Reader {
+				s := &onlySeeker{rs: strings.NewReader("skip:hello")}
+				_, _ = s.Seek(5, io.SeekStart)
+				return s
+			},
+			validate: func(t *testing.T, req *http.Request, err error) {
+				require.NoError(t, err)
+				require.Equal(t, int64(5), req.ContentLength)
+
+				p := make([]byte, 2)
+				_, err = req.Body.Read(p)
+				require.NoError(t, err)
+				again, err := req.GetBody()
+				require.NoError(t, err)
+				_, err = req.Body.Read(p)
+				require.True(t, errors.Is(err, ErrBodyNotReplayable), "body rewound for another attempt")
+				require.Equal(t, "hello", readAll(t, again))
+
+				again, err = req.GetBody()
+				require.NoError(t, err)
+				require.Equal(t, "hello", readAll(t, again))
+			},
+		},
+		"base path- small unknown body buffered": {
+			body:       func() io.Reader { return &onlyReader{r: strings.NewReader("hello")} },
+			bufferSize: 10,
+			validate: func(t *testing.T, req *http.Request, err error) {
+				require.NoError(t, err)
+				require.Equal(t, int64(5), req.ContentLength)
+				require.Equal(t, "hello", readAll(t, req.Body))
+				again, err := req.GetBody()
+				require.NoError(t, err)
+				require.Equal(t, "hello", readAll(t, again))
+			},
+		},
+		"base path- replayable body factory": {
+			body: func() io.Reader {
+				return NewReplayableBody(func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(strings.NewReader("hello")), nil
+				}, 5)
+			},
+			validate: func(t *testing.T, req *http.Request, err error) {
+				require.NoError(t, err)
+				require.Equal(t, int64(5), req.ContentLength)
+				require.Equal(t, "hello", readAll(t, req.Body))
+				again, err := req.GetBody()
+				require.NoError(t, err)
+				require.Equal(t, "hello", readAll(t, again))
+			},
+		},
+		"exceptional path- large unknown body streamed once": {
+			body:       func() io.Reader { return &onlyReader{r: strings.NewReader("hello world")} },
+			bufferSize: 4,
+			validate: func(t *testing.T, req *http.Request, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "hello world", readAll(t, req.Body))
+				require.Nil(t, req.GetBody)
+			},
+		},
+		"exceptional path- body factory fails": {
+			body: func() io.Reader {
+				return NewReplayableBody(func() (io.ReadCloser, error) {
+					return nil, errors.New("cannot open")
+				}, -1)
+			},
+			validate: func(t *testing.T, req *http.Request, err error) {
+				require.EqualError(t, err, "cannot open")
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "http://example.com", nil)
+			require.NoError(t, err)
+			tc.validate(t, req, setRequestBody(req, tc.body(), tc.bufferSize))
+		})
+	}
+}
+
+func TestUnit_WithReplayBufferSize(t *testing.T) {
+	tests := map[string]struct {
+		bufferSize int64
+		opts       []Option
+		slug       string
+		validate   func(t *testing.T, status int, body []byte, calls int32, err error)
+	}{
+		"base path- buffered body follows a 307 redirect": {
+			bufferSize: DefaultReplayBufferSize,
+			slug:       "redirect",
+			validate: func(t *testing.T, status int, body []byte, calls int32, err error) {
+				require.Nil(t, err)
+				require.Equal(t, http.StatusOK, status)
+				require.Equal(t, "payload", string(body))
+			},
+		},
+		"exceptional path- streamed body cannot follow a 307 redirect": {
+			bufferSize: -1,
+			slug:       "redirect",
+			validate: func(t *testing.T, status int, body []byte, calls int32, err error) {
+				require.Nil(t, err)
+				require.Equal(t, http.StatusTemporaryRedirect, status)
+			},
+		},
+		"exceptional path- streamed body cannot be retried": {
+			bufferSize: -1,
+			opts:       []Option{WithRetries(RetryPolicy{BaseDelay: time.Millisecond})},
+			slug:       "unavailable",
+			validate: func(t *testing.T, status int, body []byte, calls int32, err error) {
+				require.Nil(t, err)
+				require.Equal(t, http.StatusServiceUnavailable, status)
+				require.Equal(t, int32(1), calls)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				by, _ := ioutil.ReadAll(r.Body)
+				switch r.URL.Path {
+				case "/redirect":
+					http.Redirect(w, r, "/target", http.StatusTemporaryRedirect)
+				case "/unavailable":
+					atomic.AddInt32(&calls, 1)
+					w.WriteHeader(http.StatusServiceUnavailable)
+				default:
+					_, _ = w.Write(by)
+				}
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			opts := append([]Option{WithReplayBufferSize(tc.bufferSize)}, tc.opts...)
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, opts...)
+
+			status, body, err := bc.MakeRequest(context.Background(), "PUT", tc.slug, nil, nil, &onlyReader{r: strings.NewReader("payload")})
+			var e error
+			if err != nil {
+				e = fmt.Errorf("%s", err.Error())
+			}
+			tc.validate(t, status, body, atomic.LoadInt32(&calls), e)
+		})
+	}
+}
+
+func TestUnit_SeekableBodyRetried(t *testing.T) {
+	tests := map[string]struct {
+		body     func(t *testing.T) io.Reader
+		validate func(t *testing.T, body io.Reader)
+	}{
+		"base path- file closed once the call is done": {
+			body: func(t *testing.T) io.Reader {
+				path := filepath.Join(t.TempDir(), "payload")
+				require.NoError(t, ioutil.WriteFile(path, []byte("payload"), 0600))
+				f, err := os.Open(path)
+				require.NoError(t, err)
+				return f
+			},
+			validate: func(t *testing.T, body io.Reader) {
+				_, err := body.(*os.File).Read(make([]byte, 1))
+				require.True(t, errors.Is(err, os.ErrClosed))
+			},
+		},
+		"base path- seeker without ReaderAt rewound for each attempt": {
+			body: func(t *testing.T) io.Reader {
+				return &onlySeeker{rs: strings.NewReader("payload")}
+			},
+			validate: func(t *testing.T, body io.Reader) {},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) < 3 {
+					// Answer before reading the body, so the transport may still be sending it
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				by, _ := ioutil.ReadAll(r.Body)
+				_, _ = w.Write(by)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil,
+				WithRetries(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+
+			body := tc.body(t)
+			status, ret, err := bc.MakeRequest(context.Background(), "PUT", "1", nil, nil, body)
+			require.Nil(t, err)
+			require.Equal(t, http.StatusOK, status)
+			require.Equal(t, "payload", string(ret))
+			require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+			tc.validate(t, body)
+		})
+	}
+}
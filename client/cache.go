@@ -0,0 +1,442 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	heuristicFreshnessRatio = 10
+	maxHeuristicFreshness   = 24 * time.Hour
+	cacheRevalidateTimeout  = 30 * time.Second
+)
+
+// cacheableStatuses are the statuses a response may be stored with; RFC 9111 lets these be cached heuristically
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// CacheStats counts how requests were served by an HTTPCache
+type CacheStats struct {
+	// Hits are requests served from the cache without contacting the service
+	Hits uint64
+	// Misses are requests answered by a full response from the service
+	Misses uint64
+	// Revalidations are requests where the service confirmed a stored response was still valid
+	Revalidations uint64
+	// StaleHits are the Hits served from a stale response under stale-while-revalidate or stale-if-error
+	StaleHits uint64
+}
+
+// HTTPCache is a private HTTP cache following RFC 9111. It stores responses to GET and HEAD requests, serves them
+// while fresh according to Cache-Control, Expires, and Age, revalidates them with If-None-Match and
+// If-Modified-Since once stale, honors Vary, stale-while-revalidate, and stale-if-error, and invalidates entries
+// when an unsafe request to the same URL succeeds.
+type HTTPCache struct {
+	storage CacheStorage
+	now     func() time.Time
+
+	hits          uint64
+	misses        uint64
+	revalidations uint64
+	staleHits     uint64
+
+	mu           sync.Mutex
+	revalidating map[string]bool
+}
+
+// NewHTTPCache creates an HTTPCache keeping responses in storage; an in-memory LRU storage is used when storage is nil
+func NewHTTPCache(storage CacheStorage) *HTTPCache {
+	if storage == nil {
+		storage = NewMemoryCacheStorage(0)
+	}
+
+	return &HTTPCache{storage: storage, now: time.Now, revalidating: map[string]bool{}}
+}
+
+// Stats returns the counts of how requests have been served so far
+func (c *HTTPCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:          atomic.LoadUint64(&c.hits),
+		Misses:        atomic.LoadUint64(&c.misses),
+		Revalidations: atomic.LoadUint64(&c.revalidations),
+		StaleHits:     atomic.LoadUint64(&c.staleHits),
+	}
+}
+
+// WithCache serves responses from cache when the service allows it. Calls passing credentials of their own, in the
+// Authorization, Proxy-Authorization, or Cookie header or the header of an APIKeyHeader authenticator, skip the cache,
+// so one caller's responses are never handed to another.
+func WithCache(cache *HTTPCache) Option {
+	return func(c *client) {
+		c.cache = cache
+	}
+}
+
+// cacheTransport answers requests from an HTTPCache where it can
+type cacheTransport struct {
+	next  http.RoundTripper
+	cache *HTTPCache
+	// bypass, when set, reports requests that must not be served from or stored in the cache
+	bypass func(req *http.Request) bool
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.bypass != nil && t.bypass(req) {
+		return t.next.RoundTrip(req)
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil && resp.StatusCode < http.StatusBadRequest && !isSafeMethod(req.Method) {
+			t.cache.storage.Delete(cacheKey(http.MethodGet, req))
+			t.cache.storage.Delete(cacheKey(http.MethodHead, req))
+		}
+		return resp, err
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if reqCC.has("no-store") || isStream(req.Context()) {
+		return t.next.RoundTrip(req)
+	}
+
+	key := cacheKey(req.Method, req)
+	if o := callOptionsFrom(req.Context()); o != nil && o.bypassCache {
+		return t.fetch(req, key)
+	}
+
+	entry, ok := t.cache.storage.Get(key)
+	if !ok || !entry.varyMatches(req) {
+		return t.fetch(req, key)
+	}
+
+	now := t.cache.now()
+	age := entry.age(now)
+	lifetime := entry.freshnessLifetime()
+	resCC := parseCacheControl(entry.Header)
+
+	fresh := age < lifetime && !resCC.has("no-cache") && !reqCC.has("no-cache")
+	if maxAge, ok := reqCC.duration("max-age"); ok && age > maxAge {
+		fresh = false
+	}
+	if fresh {
+		atomic.AddUint64(&t.cache.hits, 1)
+		return entry.response(req, age), nil
+	}
+
+	staleness := age - lifetime
+	mayServeStale := !resCC.has("no-cache") && !resCC.has("must-revalidate") && !reqCC.has("no-cache")
+	if swr, ok := resCC.duration("stale-while-revalidate"); ok && mayServeStale && staleness < swr {
+		atomic.AddUint64(&t.cache.hits, 1)
+		atomic.AddUint64(&t.cache.staleHits, 1)
+		t.revalidateInBackground(req, key, entry)
+		return entry.response(req, age), nil
+	}
+
+	resp, err := t.revalidate(req, key, entry)
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		sie, ok := resCC.duration("stale-if-error")
+		if reqSIE, reqOK := reqCC.duration("stale-if-error"); reqOK {
+			sie, ok = reqSIE, true
+		}
+		if ok && mayServeStale && staleness < sie {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			atomic.AddUint64(&t.cache.hits, 1)
+			atomic.AddUint64(&t.cache.staleHits, 1)
+			return entry.response(req, age), nil
+		}
+	}
+
+	return resp, err
+}
+
+// fetch makes an unconditional request, storing the response if it is cacheable
+func (t *cacheTransport) fetch(req *http.Request, key string) (*http.Response, error) {
+	requestTime := t.cache.now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&t.cache.misses, 1)
+
+	return t.store(req, key, resp, requestTime)
+}
+
+// revalidate makes a conditional request for a stored response, refreshing and serving the stored response if the
+// service reports it has not changed
+func (t *cacheTransport) revalidate(req *http.Request, key string, entry *CacheEntry) (*http.Response, error) {
+	cond := req.Clone(req.Context())
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		cond.Header.Set("If-None-Match", etag)
+	}
+	if lm := entry.Header.Get("Last-Modified"); lm != "" {
+		cond.Header.Set("If-Modified-Since", lm)
+	}
+
+	requestTime := t.cache.now()
+	resp, err := t.next.RoundTrip(cond)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusNotModified {
+		atomic.AddUint64(&t.cache.misses, 1)
+		return t.store(req, key, resp, requestTime)
+	}
+
+	_, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	atomic.AddUint64(&t.cache.revalidations, 1)
+
+	refreshed := *entry
+	refreshed.Header = entry.Header.Clone()
+	for k, v := range resp.Header {
+		if k != "Content-Length" {
+			refreshed.Header[k] = v
+		}
+	}
+	refreshed.RequestTime = requestTime
+	refreshed.ResponseTime = t.cache.now()
+	t.cache.storage.Set(key, &refreshed)
+
+	return refreshed.response(req, 0), nil
+}
+
+// revalidateInBackground refreshes a stale entry without holding up the caller, at most once per key at a time
+func (t *cacheTransport) revalidateInBackground(req *http.Request, key string, entry *CacheEntry) {
+	t.cache.mu.Lock()
+	if t.cache.revalidating[key] {
+		t.cache.mu.Unlock()
+		return
+	}
+	t.cache.revalidating[key] = true
+	t.cache.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(detachedContext{parent: req.Context()}, cacheRevalidateTimeout)
+	bgReq := req.Clone(ctx)
+	go func() {
+		defer func() {
+			cancel()
+			t.cache.mu.Lock()
+			delete(t.cache.revalidating, key)
+			t.cache.mu.Unlock()
+		}()
+
+		resp, err := t.revalidate(bgReq, key, entry)
+		if err == nil {
+			_, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	}()
+}
+
+// store saves resp if it may be cached, returning a response the caller can still read
+func (t *cacheTransport) store(req *http.Request, key string, resp *http.Response, requestTime time.Time) (*http.Response, error) {
+	if !isStorable(req, resp) {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	entry := &CacheEntry{
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: t.cache.now(),
+	}
+	for _, name := range headerList(resp.Header, "Vary") {
+		if entry.VaryHeaders == nil {
+			entry.VaryHeaders = http.Header{}
+		}
+		name = http.CanonicalHeaderKey(name)
+		entry.VaryHeaders[name] = req.Header.Values(name)
+	}
+	t.cache.storage.Set(key, entry)
+
+	return resp, nil
+}
+
+// isStorable reports whether RFC 9111 allows a private cache to store resp and whether doing so is worthwhile
+func isStorable(req *http.Request, resp *http.Response) bool {
+	if !cacheableStatuses[resp.StatusCode] {
+		return false
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	resCC := parseCacheControl(resp.Header)
+	if reqCC.has("no-store") || resCC.has("no-store") {
+		return false
+	}
+	for _, v := range headerList(resp.Header, "Vary") {
+		if v == "*" {
+			return false
+		}
+	}
+
+	_, hasMaxAge := resCC.duration("max-age")
+	return hasMaxAge || resp.Header.Get("Expires") != "" || resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != ""
+}
+
+// varyMatches reports whether req selects the same variant as the one stored
+func (e *CacheEntry) varyMatches(req *http.Request) bool {
+	for name, values := range e.VaryHeaders {
+		if strings.Join(req.Header.Values(name), ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// age computes the current age of the stored response as described by RFC 9111 section 4.2.3
+func (e *CacheEntry) age(now time.Time) time.Duration {
+	apparentAge := time.Duration(0)
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil && e.ResponseTime.After(date) {
+		apparentAge = e.ResponseTime.Sub(date)
+	}
+
+	ageValue := time.Duration(0)
+	if secs, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && secs > 0 {
+		ageValue = time.Duration(secs) * time.Second
+	}
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+
+	initialAge := apparentAge
+	if correctedAge > initialAge {
+		initialAge = correctedAge
+	}
+
+	return initialAge + now.Sub(e.ResponseTime)
+}
+
+// freshnessLifetime computes how long the stored response is fresh for as described by RFC 9111 section 4.2.1
+func (e *CacheEntry) freshnessLifetime() time.Duration {
+	if maxAge, ok := parseCacheControl(e.Header).duration("max-age"); ok {
+		return maxAge
+	}
+
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+
+	if expiresHeader := e.Header.Get("Expires"); expiresHeader != "" {
+		// An invalid Expires, such as 0, means the response is already stale
+		expires, err := http.ParseTime(expiresHeader)
+		if err != nil || expires.Before(date) {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && date.After(lm) {
+		heuristic := date.Sub(lm) / heuristicFreshnessRatio
+		if heuristic > maxHeuristicFreshness {
+			heuristic = maxHeuristicFreshness
+		}
+		return heuristic
+	}
+
+	return 0
+}
+
+// response builds a response for req from the stored entry
+func (e *CacheEntry) response(req *http.Request, age time.Duration) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+func cacheKey(method string, req *http.Request) string {
+	return method + " " + req.URL.String()
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions ||
+		method == http.MethodTrace
+}
+
+// cacheControl holds parsed Cache-Control directives
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, directive := range headerList(h, "Cache-Control") {
+		name, value := directive, ""
+		if i := strings.Index(directive, "="); i >= 0 {
+			name, value = directive[:i], strings.Trim(directive[i+1:], `"`)
+		}
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+func (cc cacheControl) duration(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+
+	return time.Duration(secs) * time.Second, true
+}
+
+// headerList splits the comma separated values of a header into their trimmed elements
+func headerList(h http.Header, name string) []string {
+	var list []string
+	for _, v := range h.Values(name) {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
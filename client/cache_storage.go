@@ -0,0 +1,167 @@
+package client
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultMemoryCacheEntries = 1000
+
+// CacheEntry is a response held by a CacheStorage
+type CacheEntry struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// RequestTime is when the request that produced the response was sent
+	RequestTime time.Time `json:"request_time"`
+	// ResponseTime is when the response was received
+	ResponseTime time.Time `json:"response_time"`
+	// VaryHeaders holds the values the request had for each header named in the response's Vary header
+	VaryHeaders http.Header `json:"vary_headers,omitempty"`
+}
+
+// CacheStorage stores responses for an HTTPCache. Storage is best effort: implementations report a failed read as
+// a miss and may drop entries at any time.
+type CacheStorage interface {
+	// Get returns the entry stored under key
+	Get(key string) (*CacheEntry, bool)
+	// Set stores entry under key, replacing any existing entry
+	Set(key string, entry *CacheEntry)
+	// Delete removes the entry stored under key
+	Delete(key string)
+}
+
+// memoryCacheStorage is a CacheStorage holding a bounded number of entries, evicting the least recently used
+type memoryCacheStorage struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewMemoryCacheStorage creates an in-memory CacheStorage evicting the least recently used entry once it holds
+// maxEntries entries; maxEntries defaults to 1000
+func NewMemoryCacheStorage(maxEntries int) CacheStorage {
+	if maxEntries <= 0 {
+		maxEntries = defaultMemoryCacheEntries
+	}
+
+	return &memoryCacheStorage{maxEntries: maxEntries, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (s *memoryCacheStorage) Get(key string) (*CacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+
+	return el.Value.(*memoryCacheItem).entry, true
+}
+
+func (s *memoryCacheStorage) Set(key string, entry *CacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*memoryCacheItem).entry = entry
+		s.order.MoveToFront(el)
+		return
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryCacheItem{key: key, entry: entry})
+	for s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+func (s *memoryCacheStorage) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		s.order.Remove(el)
+		delete(s.entries, key)
+	}
+}
+
+// diskCacheStorage is a CacheStorage keeping each entry as a JSON file in a directory
+type diskCacheStorage struct {
+	dir string
+}
+
+// NewDiskCacheStorage creates a CacheStorage keeping entries as files in dir, creating the directory if needed
+func NewDiskCacheStorage(dir string) (CacheStorage, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &diskCacheStorage{dir: dir}, nil
+}
+
+func (s *diskCacheStorage) Get(key string) (*CacheEntry, bool) {
+	by, err := ioutil.ReadFile(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	entry := &CacheEntry{}
+	if err := json.Unmarshal(by, entry); err != nil {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+func (s *diskCacheStorage) Set(key string, entry *CacheEntry) {
+	by, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	// Write to a temporary file first so readers never see a partially written entry
+	tmp, err := ioutil.TempFile(s.dir, "entry-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(by)
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+func (s *diskCacheStorage) Delete(key string) {
+	_ = os.Remove(s.path(key))
+}
+
+func (s *diskCacheStorage) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
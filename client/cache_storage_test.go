@@ -0,0 +1,109 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_MemoryCacheStorage(t *testing.T) {
+	tests := map[string]struct {
+		maxEntries int
+		validate   func(t *testing.T, s CacheStorage)
+	}{
+		"base path- set, get, delete": {
+			validate: func(t *testing.T, s CacheStorage) {
+				s.Set("a", &CacheEntry{StatusCode: http.StatusOK})
+				e, ok := s.Get("a")
+				require.True(t, ok)
+				require.Equal(t, http.StatusOK, e.StatusCode)
+
+				s.Delete("a")
+				_, ok = s.Get("a")
+				require.False(t, ok)
+			},
+		},
+		"base path- least recently used entry evicted": {
+			maxEntries: 2,
+			validate: func(t *testing.T, s CacheStorage) {
+				s.Set("a", &CacheEntry{})
+				s.Set("b", &CacheEntry{})
+				_, _ = s.Get("a")
+				s.Set("c", &CacheEntry{})
+
+				_, ok := s.Get("b")
+				require.False(t, ok)
+				_, ok = s.Get("a")
+				require.True(t, ok)
+				_, ok = s.Get("c")
+				require.True(t, ok)
+			},
+		},
+		"base path- replacing an entry": {
+			maxEntries: 1,
+			validate: func(t *testing.T, s CacheStorage) {
+				s.Set("a", &CacheEntry{StatusCode: http.StatusOK})
+				s.Set("a", &CacheEntry{StatusCode: http.StatusNotFound})
+				e, ok := s.Get("a")
+				require.True(t, ok)
+				require.Equal(t, http.StatusNotFound, e.StatusCode)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.validate(t, NewMemoryCacheStorage(tc.maxEntries))
+		})
+	}
+}
+
+func TestUnit_DiskCacheStorage(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		validate func(t *testing.T, s CacheStorage)
+	}{
+		"base path- entries survive a round trip to disk": {
+			validate: func(t *testing.T, s CacheStorage) {
+				entry := &CacheEntry{
+					StatusCode:   http.StatusOK,
+					Header:       http.Header{"Etag": []string{`"v1"`}},
+					Body:         []byte(`{"foo":"bar"}`),
+					RequestTime:  now,
+					ResponseTime: now.Add(time.Second),
+					VaryHeaders:  http.Header{"Accept-Language": []string{"en"}},
+				}
+				s.Set("GET http://example.com/1", entry)
+
+				e, ok := s.Get("GET http://example.com/1")
+				require.True(t, ok)
+				require.Equal(t, entry, e)
+			},
+		},
+		"base path- deleted entries are gone": {
+			validate: func(t *testing.T, s CacheStorage) {
+				s.Set("a", &CacheEntry{})
+				s.Delete("a")
+				_, ok := s.Get("a")
+				require.False(t, ok)
+			},
+		},
+		"exceptional path- missing entry": {
+			validate: func(t *testing.T, s CacheStorage) {
+				_, ok := s.Get("missing")
+				require.False(t, ok)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewDiskCacheStorage(t.TempDir())
+			require.NoError(t, err)
+			tc.validate(t, s)
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_WithCache(t *testing.T) {
+	tests := map[string]struct {
+		requestHandler func(calls *int32) http.HandlerFunc
+		validate       func(t *testing.T, bc BaseClient, cache *HTTPCache, clock *time.Time, calls *int32)
+	}{
+		"base path- fresh response served from cache": {
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					n := atomic.AddInt32(calls, 1)
+					w.Header().Set("Cache-Control", "max-age=60")
+					_, _ = fmt.Fprintf(w, `{"call":"%d"}`, n)
+				}
+			},
+			validate: func(t *testing.T, bc BaseClient, cache *HTTPCache, clock *time.Time, calls *int32) {
+				for i := 0; i < 3; i++ {
+					resp := map[string]string{}
+					require.NoError(t, bc.Do(context.Background(), "GET", "1", nil, nil, nil, &resp))
+					require.Equal(t, "1", resp["call"])
+				}
+				require.Equal(t, int32(1), atomic.LoadInt32(calls))
+				require.Equal(t, CacheStats{Hits: 2, Misses: 1}, cache.Stats())
+			},
+		},
+		"base path- responses to callers' own credentials not shared": {
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(calls, 1)
+					w.Header().Set("Cache-Control", "max-age=60")
+					_, _ = fmt.Fprintf(w, `{"user":"%s"}`, r.Header.Get("Authorization"))
+				}
+			},
+			validate: func(t *testing.T, bc BaseClient, cache *HTTPCache, clock *time.Time, calls *int32) {
+				for _, user := range []string{"Bearer alice", "Bearer bob", "Bearer alice"} {
+					resp := map[string]string{}
+					headers := http.Header{"Authorization": []string{user}}
+					require.NoError(t, bc.Do(context.Background(), "GET", "1", nil, headers, nil, &resp))
+					require.Equal(t, user, resp["user"])
+				}
+				require.Equal(t, int32(3), atomic.LoadInt32(calls))
+				require.Equal(t, CacheStats{}, cache.Stats())
+			},
+		},
+		"base path- stale response revalidated with ETag": {
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(calls, 1)
+					// Without a Date header the age is measured against the test's clock
+					w.Header()["Date"] = nil
+					w.Header().Set("Cache-Control", "max-age=10")
+					w.Header().Set("ETag", `"v1"`)
+					if r.Header.Get("If-None-Match") == `"v1"` {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+					_, _ = fmt.Fprintf(w, `{"foo":"bar"}`)
+				}
+			},
+			validate: func(t *testing.T, bc BaseClient, cache *HTTPCache, clock *time.Time, calls *int32) {
+				_, _, err := bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+				require.NoError(t, err)
+
+				*clock = clock.Add(time.Minute)
+				status, body, err := bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, status)
+				require.Equal(t, `{"foo":"bar"}`, string(body))
+				require.Equal(t, int32(2), atomic.LoadInt32(calls))
+				require.Equal(t, CacheStats{Misses: 1, Revalidations: 1}, cache.Stats())
+
+				// The revalidation refreshed the entry
+				_, _, err = bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+				require.NoError(t, err)
+				require.Equal(t, int32(2), atomic.LoadInt32(calls))
+			},
+		},
+		"base path- Vary selects the variant": {
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(calls, 1)
+					w.Header().Set("Cache-Control", "max-age=60")
+					w.Header().Set("Vary", "Accept-Language")
+					_, _ = fmt.Fprintf(w, `{"lang":"%s"}`, r.Header.Get("Accept-Language"))
+				}
+			},
+			validate: func(t *testing.T, bc BaseClient, cache *HTTPCache, clock *time.Time, calls *int32) {
+				en := http.Header{"Accept-Language": []string{"en"}}
+				fr := http.Header{"Accept-Language": []string{"fr"}}
+
+				_, body, err := bc.MakeRequest(context.Background(), "GET", "1", nil, en, nil)
+				require.NoError(t, err)
+				require.Equal(t, `{"lang":"en"}`, string(body))
+				_, body, err = bc.MakeRequest(context.Background(), "GET", "1", nil, fr, nil)
+				require.NoError(t, err)
+				require.Equal(t, `{"lang":"fr"}`, string(body))
+				_, body, err = bc.MakeRequest(context.Background(), "GET", "1", nil, fr, nil)
+				require.NoError(t, err)
+				require.Equal(t, `{"lang":"fr"}`, string(body))
+				require.Equal(t, int32(2), atomic.LoadInt32(calls))
+			},
+		},
+		"base path- Expires header sets freshness": {
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(calls, 1)
+					now := time.Now()
+					w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+					w.Header().Set("Expires", now.Add(time.Hour).UTC().Format(http.TimeFormat))
+					_, _ = fmt.Fprintf(w, `{}`)
+				}
+			},
+			validate: func(t *testing.T, bc BaseClient, cache *HTTPCache, clock *time.Time, calls *int32) {
+				for i := 0; i < 2; i++ {
+					_, _, err := bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+					require.NoError(t, err)
+				}
+				require.Equal(t, int32(1), atomic.LoadInt32(calls))
+			},
+		},
+		"base path- stale-while-revalidate serves stale and refreshes": {
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					n := atomic.AddInt32(calls, 1)
+					w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=120")
+					_, _ = fmt.Fprintf(w, `{"call":"%d"}`, n)
+				}
+			},
+			validate: func(t *testing.T, bc BaseClient, cache *HTTPCache, clock *time.Time, calls *int32) {
+				_, _, err := bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+				require.NoError(t, err)
+
+				*clock = clock.Add(time.Minute)
+				_, body, err := bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+				require.NoError(t, err)
+				require.Equal(t, `{"call":"1"}`, string(body))
+				require.Eventually(t, func() bool { return atomic.LoadInt32(calls) == 2 }, time.Second, 5*time.Millisecond)
+				require.Equal(t, uint64(1), cache.Stats().StaleHits)
+			},
+		},
+		"base path- no-store responses are not cached": {
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(calls, 1)
+					w.Header().Set("Cache-Control", "no-store, max-age=60")
+					_, _ = fmt.Fprintf(w, `{}`)
+				}
+			},
+			validate: func(t *testing.T, bc BaseClient, cache *HTTPCache, clock *time.Time, calls *int32) {
+				for i := 0; i < 2; i++ {
+					_, _, err := bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+					require.NoError(t, err)
+				}
+				require.Equal(t, int32(2), atomic.LoadInt32(calls))
+			},
+		},
+		"base path- unsafe request invalidates the entry": {
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if r.Method == http.MethodGet {
+						atomic.AddInt32(calls, 1)
+					}
+					w.Header().Set("Cache-Control", "max-age=60")
+					_, _ = fmt.Fprintf(w, `{}`)
+				}
+			},
+			validate: func(t *testing.T, bc BaseClient, cache *HTTPCache, clock *time.Time, calls *int32) {
+				_, _, err := bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+				require.NoError(t, err)
+				_, _, err = bc.MakeRequest(context.Background(), "DELETE", "1", nil, nil, nil)
+				require.NoError(t, err)
+				_, _, err = bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+				require.NoError(t, err)
+				require.Equal(t, int32(2), atomic.LoadInt32(calls))
+			},
+		},
+		"exceptional path- stale-if-error serves stale on server errors": {
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if atomic.AddInt32(calls, 1) > 1 {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						_, _ = fmt.Fprintf(w, `{"code":"UNAVAILABLE"}`)
+						return
+					}
+					w.Header().Set("Cache-Control", "max-age=10, stale-if-error=300")
+					_, _ = fmt.Fprintf(w, `{"foo":"bar"}`)
+				}
+			},
+			validate: func(t *testing.T, bc BaseClient, cache *HTTPCache, clock *time.Time, calls *int32) {
+				_, _, err := bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+				require.NoError(t, err)
+
+				*clock = clock.Add(time.Minute)
+				resp := map[string]string{}
+				require.NoError(t, bc.Do(context.Background(), "GET", "1", nil, nil, nil, &resp))
+				require.Equal(t, "bar", resp["foo"])
+
+				*clock = clock.Add(time.Hour)
+				dErr := bc.Do(context.Background(), "GET", "1", nil, nil, nil, &resp)
+				require.Error(t, dErr)
+				require.Equal(t, "UNAVAILABLE", dErr.Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			testServer := httptest.NewServer(tc.requestHandler(&calls))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+
+			clock := time.Now()
+			cache := NewHTTPCache(nil)
+			cache.now = func() time.Time { return clock }
+
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithCache(cache))
+			tc.validate(t, bc, cache, &clock, &calls)
+		})
+	}
+}
+
+func TestUnit_CacheEntry_freshnessLifetime(t *testing.T) {
+	date := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		header   http.Header
+		expected time.Duration
+	}{
+		"base path- max-age wins over Expires": {
+			header: http.Header{
+				"Cache-Control": []string{"public, max-age=30"},
+				"Date":          []string{date.Format(http.TimeFormat)},
+				"Expires":       []string{date.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			expected: 30 * time.Second,
+		},
+		"base path- Expires relative to Date": {
+			header: http.Header{
+				"Date":    []string{date.Format(http.TimeFormat)},
+				"Expires": []string{date.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			expected: time.Hour,
+		},
+		"base path- heuristic from Last-Modified": {
+			header: http.Header{
+				"Date":          []string{date.Format(http.TimeFormat)},
+				"Last-Modified": []string{date.Add(-10 * time.Hour).Format(http.TimeFormat)},
+			},
+			expected: time.Hour,
+		},
+		"exceptional path- invalid Expires is already stale": {
+			header: http.Header{
+				"Date":    []string{date.Format(http.TimeFormat)},
+				"Expires": []string{"0"},
+			},
+			expected: 0,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := &CacheEntry{Header: tc.header, ResponseTime: date}
+			require.Equal(t, tc.expected, e.freshnessLifetime())
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+type callOptionsKey struct{}
+
+// CallOption tunes a single call made through Do or MakeRequest
+type CallOption func(o *callOptions)
+
+type callOptions struct {
+	retries     *RetryPolicy
+	timeouts    Timeouts
+	bypassCache bool
+	baseURL     *url.URL
+	elsewhere   bool
+	foreign     bool
+	headers     http.Header
+	fallback    *Fallback
+	progress    *ProgressConfig
+}
+
+// WithCallOptions returns a context whose calls through Do or MakeRequest use opts, on top of any options already
+// attached to ctx. Options set here take precedence over the client's configuration.
+func WithCallOptions(ctx context.Context, opts ...CallOption) context.Context {
+	o := callOptions{}
+	if prev := callOptionsFrom(ctx); prev != nil {
+		o = *prev
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return context.WithValue(ctx, callOptionsKey{}, &o)
+}
+
+// CallRetries replaces the client's retry policy for the call. A MaxAttempts of 1 disables retries.
+func CallRetries(p RetryPolicy) CallOption {
+	return func(o *callOptions) {
+		o.retries = &p
+	}
+}
+
+// CallTimeouts overrides the timeouts of the client and route for the call
+func CallTimeouts(t Timeouts) CallOption {
+	return func(o *callOptions) {
+		o.timeouts = o.timeouts.merge(t)
+	}
+}
+
+// CallBypassCache fetches the response from the service even if the cache holds a usable one. The response still
+// refreshes the cache.
+func CallBypassCache() CallOption {
+	return func(o *callOptions) {
+		o.bypassCache = true
+	}
+}
+
+// CallBaseURL sends the call to u instead of the URL found by the client's ServiceFinder. The inbound credential of
+// WithPropagation is not forwarded to it.
+func CallBaseURL(u url.URL) CallOption {
+	return func(o *callOptions) {
+		o.baseURL = &u
+		o.elsewhere = true
+	}
+}
+
+// callLocation sends the call to u, a location the service answered with. Credentials are only sent along when u is
+// on the host the call answered with it was sent to, as reported by sameHost.
+func callLocation(u url.URL, sameHost bool) CallOption {
+	return func(o *callOptions) {
+		o.baseURL = &u
+		o.foreign = !sameHost
+	}
+}
+
+// CallHeaders adds h to the headers of the call, replacing any header of the same name
+func CallHeaders(h http.Header) CallOption {
+	return func(o *callOptions) {
+		headers := cloneHeader(o.headers)
+		for k, v := range h {
+			headers[http.CanonicalHeaderKey(k)] = v
+		}
+		o.headers = headers
+	}
+}
+
+// CallFallback replaces the fallback configured for the route of a call made through Do
+func CallFallback(fb Fallback) CallOption {
+	return func(o *callOptions) {
+		o.fallback = &fb
+	}
+}
+
+// callOptionsFrom returns the call options attached to ctx, if any
+func callOptionsFrom(ctx context.Context) *callOptions {
+	if ctx == nil {
+		return nil
+	}
+
+	o, _ := ctx.Value(callOptionsKey{}).(*callOptions)
+	return o
+}
+
+// applyHeaders returns headers with the call's headers added, leaving the caller's headers untouched
+func (o *callOptions) applyHeaders(headers http.Header) http.Header {
+	if o == nil || len(o.headers) == 0 {
+		return headers
+	}
+
+	h := cloneHeader(headers)
+	for k, v := range o.headers {
+		h[k] = v
+	}
+
+	return h
+}
+
+// baseURL returns the URL a call made with ctx is sent to
+func (c *client) baseURL(ctx context.Context) (url.URL, error) {
+	if o := callOptionsFrom(ctx); o != nil && o.baseURL != nil {
+		return *o.baseURL, nil
+	}
+
+	return c.finder(c.serviceName, c.useTLS)
+}
+
+// coalescable reports whether a call using o may share a request with calls not using them
+func (o *callOptions) coalescable() bool {
+	return o == nil || (o.retries == nil && o.timeouts == Timeouts{} && !o.bypassCache && o.baseURL == nil && o.progress == nil)
+}
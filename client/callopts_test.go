@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+func TestUnit_WithCallOptions(t *testing.T) {
+	tests := map[string]struct {
+		opts      []Option
+		finderErr error
+		validate  func(t *testing.T, bc BaseClient, serverURL url.URL, calls *int32)
+	}{
+		"base path- call opts into retries": {
+			validate: func(t *testing.T, bc BaseClient, serverURL url.URL, calls *int32) {
+				ctx := WithCallOptions(context.Background(), CallRetries(RetryPolicy{BaseDelay: time.Millisecond}))
+				status, _, err := bc.MakeRequest(ctx, "GET", "/flaky", nil, nil, nil)
+				require.Nil(t, err)
+				require.Equal(t, http.StatusOK, status)
+				require.Equal(t, int32(2), atomic.LoadInt32(calls))
+			},
+		},
+		"base path- call opts out of retries": {
+			opts: []Option{WithRetries(RetryPolicy{BaseDelay: time.Millisecond})},
+			validate: func(t *testing.T, bc BaseClient, serverURL url.URL, calls *int32) {
+				ctx := WithCallOptions(context.Background(), CallRetries(RetryPolicy{MaxAttempts: 1}))
+				status, _, err := bc.MakeRequest(ctx, "GET", "/flaky", nil, nil, nil)
+				require.Nil(t, err)
+				require.Equal(t, http.StatusServiceUnavailable, status)
+				require.Equal(t, int32(1), atomic.LoadInt32(calls))
+			},
+		},
+		"base path- call sent to its own base URL": {
+			finderErr: errors.New("not registered"),
+			validate: func(t *testing.T, bc BaseClient, serverURL url.URL, calls *int32) {
+				_, _, err := bc.MakeRequest(context.Background(), "GET", "/users/1", nil, nil, nil)
+				require.NotNil(t, err)
+				require.Equal(t, ErrorCantFind, err.Code())
+
+				ctx := WithCallOptions(context.Background(), CallBaseURL(serverURL))
+				status, _, err := bc.MakeRequest(ctx, "GET", "/users/1", nil, nil, nil)
+				require.Nil(t, err)
+				require.Equal(t, http.StatusOK, status)
+			},
+		},
+		"base path- call headers added without touching the caller's": {
+			validate: func(t *testing.T, bc BaseClient, serverURL url.URL, calls *int32) {
+				ctx := WithCallOptions(context.Background(), CallHeaders(http.Header{"x-tenant": []string{"a"}}))
+				ctx = WithCallOptions(ctx, CallHeaders(http.Header{"X-Region": []string{"eu"}}))
+				headers := http.Header{"X-Tenant": []string{"mine"}, "Accept": []string{"application/json"}}
+
+				_, body, err := bc.MakeRequest(ctx, "GET", "/echo", nil, headers, nil)
+				require.Nil(t, err)
+				require.Equal(t, "a eu application/json", string(body))
+				require.Equal(t, "mine", headers.Get("X-Tenant"))
+			},
+		},
+		"base path- call bypasses the cache": {
+			opts: []Option{WithCache(NewHTTPCache(nil))},
+			validate: func(t *testing.T, bc BaseClient, serverURL url.URL, calls *int32) {
+				for i := 0; i < 2; i++ {
+					_, _, err := bc.MakeRequest(context.Background(), "GET", "/users/1", nil, nil, nil)
+					require.Nil(t, err)
+				}
+				require.Equal(t, int32(1), atomic.LoadInt32(calls))
+
+				ctx := WithCallOptions(context.Background(), CallBypassCache())
+				_, _, err := bc.MakeRequest(ctx, "GET", "/users/1", nil, nil, nil)
+				require.Nil(t, err)
+				require.Equal(t, int32(2), atomic.LoadInt32(calls))
+			},
+		},
+		"base path- call falls back": {
+			validate: func(t *testing.T, bc BaseClient, serverURL url.URL, calls *int32) {
+				ctx := WithCallOptions(context.Background(), CallFallback(Fallback{
+					Func: func(ctx context.Context, cause glitch.DataError, response interface{}) glitch.DataError {
+						response.(map[string]string)["name"] = "fallback"
+						return nil
+					},
+				}))
+				resp := map[string]string{}
+				require.NoError(t, bc.Do(ctx, "GET", "/broken", nil, nil, nil, resp))
+				require.Equal(t, "fallback", resp["name"])
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				switch r.URL.Path {
+				case "/flaky":
+					if n == 1 {
+						w.WriteHeader(http.StatusServiceUnavailable)
+					}
+				case "/echo":
+					_, _ = fmt.Fprintf(w, "%s %s %s", r.Header.Get("X-Tenant"), r.Header.Get("X-Region"), r.Header.Get("Accept"))
+				case "/broken":
+					w.WriteHeader(http.StatusInternalServerError)
+					_, _ = fmt.Fprintf(w, `{"code":"BROKEN"}`)
+				default:
+					w.Header().Set("Cache-Control", "max-age=60")
+					_, _ = fmt.Fprintf(w, `{"name":"user"}`)
+				}
+			}))
+			defer testServer.Close()
+
+			serverURL, err := url.Parse(testServer.URL)
+			require.NoError(t, err)
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				return *serverURL, tc.finderErr
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, tc.opts...)
+			tc.validate(t, bc, *serverURL, &calls)
+		})
+	}
+}
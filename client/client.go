@@ -3,7 +3,7 @@ package client
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -22,6 +22,17 @@ const (
 	ErrorDecodingError     = "ERROR_DECODING_ERROR"
 	ErrorDecodingResponse  = "ERROR_DECODING_RESPONSE"
 	ErrorMarshallingObject = "ERROR_MARSHALLING_OBJECT"
+	ErrorConcurrencyLimit  = "CONCURRENCY_LIMIT_EXCEEDED"
+	ErrorBodyNotReplayable = "BODY_NOT_REPLAYABLE"
+	ErrorDeadlineBudget    = "DEADLINE_BUDGET_EXCEEDED"
+	ErrorConnectTimeout    = "CONNECT_TIMEOUT"
+	ErrorTLSTimeout        = "TLS_HANDSHAKE_TIMEOUT"
+	ErrorHeaderTimeout     = "RESPONSE_HEADER_TIMEOUT"
+	ErrorTotalTimeout      = "REQUEST_TIMEOUT"
+	ErrorUnexpectedStatus  = "UNEXPECTED_STATUS"
+	ErrorAuthentication    = "AUTHENTICATION_FAILED"
+	ErrorSigning           = "SIGNING_FAILED"
+	ErrorInvalidSignature  = "INVALID_SIGNATURE"
 )
 
 // ServiceFinder can find a service's base URL
@@ -40,81 +51,305 @@ type client struct {
 	useTLS      bool
 	serviceName string
 	client      *http.Client
+	limiter     Limiter
+	hedging     *HedgePolicy
+	coalescer   *coalescer
+	cache       *HTTPCache
+	retries     *RetryPolicy
+	idempotency *IdempotencyConfig
+	deadlines   *DeadlineConfig
+	compression *CompressionConfig
+	auth        Authenticator
+	propagation *PropagationConfig
+	requestIDs  *RequestIDConfig
+
+	defaultHeaders  http.Header
+	headerProviders []HeaderProvider
+
+	signing      *SigningConfig
+	verification *VerificationConfig
+	tls          *TLSManager
+
+	replayBufferSize int64
+
+	timeouts      Timeouts
+	routeTimeouts []routeTimeouts
+
+	fallbacks     []routeFallback
+	lastKnownGood CacheStorage
 }
 
-// NewBaseClient creates a new BaseClient
-func NewBaseClient(finder ServiceFinder, serviceName string, useTLS bool, timeout time.Duration, rt http.RoundTripper) BaseClient {
+// Option configures optional behavior of a BaseClient
+type Option func(c *client)
+
+// NewBaseClient creates a new BaseClient. It also implements Streamer, for calls whose responses are read as they
+// arrive.
+func NewBaseClient(finder ServiceFinder, serviceName string, useTLS bool, timeout time.Duration, rt http.RoundTripper, opts ...Option) BaseClient {
 	if rt == nil {
 		rt = http.DefaultTransport
 	}
-	c := &http.Client{
-		Timeout:   timeout,
-		Transport: rt,
+
+	bc := &client{
+		finder:           finder,
+		serviceName:      serviceName,
+		useTLS:           useTLS,
+		replayBufferSize: DefaultReplayBufferSize,
+		timeouts:         Timeouts{Total: timeout},
+	}
+	for _, opt := range opts {
+		opt(bc)
+	}
+	bc.lastKnownGood = NewMemoryCacheStorage(0)
+
+	if bc.tls != nil {
+		rt = bc.tls.transport(rt, serviceName)
+	}
+	bc.client = &http.Client{
+		Transport: bc.wrapTransport(rt),
+	}
+
+	return bc
+}
+
+// wrapTransport layers the optional behaviors configured on the client around rt
+func (c *client) wrapTransport(rt http.RoundTripper) http.RoundTripper {
+	rt = &phaseTimeoutTransport{next: rt}
+	// Signatures cover the message as it goes over the wire, so they sit below compression and credentials
+	if c.verification != nil {
+		rt = &verifyingTransport{next: rt, cfg: *c.verification, now: time.Now}
+	}
+	if c.signing != nil {
+		rt = &signingTransport{next: rt, cfg: *c.signing, now: time.Now}
+	}
+	if c.compression != nil {
+		rt = newCompressionTransport(rt, *c.compression)
+	}
+	if c.auth != nil {
+		rt = &authTransport{next: rt, auth: c.auth}
+	}
+	if c.propagation != nil {
+		rt = &propagationTransport{next: rt, cfg: *c.propagation, audience: c.serviceName}
+	}
+	if c.deadlines != nil {
+		rt = &deadlineTransport{next: rt, cfg: *c.deadlines, now: time.Now}
+	}
+	if c.limiter != nil {
+		rt = &limitedTransport{next: rt, limiter: c.limiter}
+	}
+	if c.hedging != nil {
+		rt = newHedgingTransport(rt, *c.hedging, func() (url.URL, error) {
+			return c.finder(c.serviceName, c.useTLS)
+		})
+	}
+	// Retries are always in place so single calls can opt into them
+	retries := RetryPolicy{MaxAttempts: 1}
+	if c.retries != nil {
+		retries = *c.retries
+	}
+	rt = newRetryTransport(rt, retries, c.idempotencyKeyHeader())
+	if c.cache != nil {
+		// Responses to calls made on behalf of someone else, or with credentials of the caller's own, are theirs alone
+		rt = &cacheTransport{next: rt, cache: c.cache, bypass: func(req *http.Request) bool {
+			return c.propagates(req.Context()) || c.callerCredentials(req.Header)
+		}}
 	}
 
-	return &client{finder: finder, serviceName: serviceName, useTLS: useTLS, client: c}
+	return rt
 }
 
 // Do parses the request body into the response provider if in the 2xx range; otherwise, parses it into a glitch.DataError
+// for statuses of 400 and above. 304 Not Modified is a success that leaves response untouched. Redirects net/http
+// follows never reach Do; the 3xx responses it does not follow, such as 300 Multiple Choices, a redirect without a
+// Location, or the last one once redirects are exhausted, fail with UNEXPECTED_STATUS along with 1xx statuses, even
+// when response is a StatusResponses with an entry for them.
 func (c *client) Do(ctx context.Context, method string, slug string, query url.Values, headers http.Header, body io.Reader, response interface{}) glitch.DataError {
-	status, ret, err := c.MakeRequest(ctx, method, slug, query, headers, body)
-	if err != nil {
+	ctx = c.withRequestMetadata(ctx)
+	return c.requestIDError(ctx, c.doWithFallback(ctx, method, slug, query, headers, body, response))
+}
+
+// doWithFallback makes the request for Do, answering from the fallback configured for slug when it fails
+func (c *client) doWithFallback(ctx context.Context, method string, slug string, query url.Values, headers http.Header, body io.Reader, response interface{}) glitch.DataError {
+	fb := c.fallbackFor(ctx, slug)
+	status, ret, err := c.do(ctx, method, slug, query, headers, body, response)
+	if fb == nil {
 		return err
 	}
 
-	if status >= 400 || status < 200 {
-		prob := glitch.HTTPProblem{}
-		err := json.Unmarshal(ret, &prob)
-		if err != nil {
-			return glitch.NewDataError(err, ErrorDecodingError, "Could not decode error response")
-		}
-		return glitch.FromHTTPProblem(prob, fmt.Sprintf("Error from %s to %s - %s", method, c.serviceName, slug))
+	key := c.lastKnownGoodKey(ctx, fb, method, slug, query, headers)
+	if err == nil {
+		c.remember(key, status, ret)
+		return nil
+	}
+	if !fb.applies(err) {
+		return err
 	}
 
-	if response != nil {
-		err := json.Unmarshal(ret, response)
-		if err != nil {
-			return glitch.NewDataError(err, ErrorDecodingResponse, "Could not decode response")
-		}
+	return c.fallback(ctx, fb, key, err, response)
+}
+
+// do makes the request for Do, returning the status and body of a successful response
+func (c *client) do(ctx context.Context, method string, slug string, query url.Values, headers http.Header, body io.Reader, response interface{}) (int, []byte, glitch.DataError) {
+	// Responses are decoded as JSON, so that is what is asked for
+	status, ret, err := c.MakeRequest(contextWithAccept(ctx, JSONMimeType), method, slug, query, headers, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := statusError(status, ret, fmt.Sprintf("Error from %s to %s - %s", method, c.serviceName, slug)); err != nil {
+		return 0, nil, err
+	}
+	if err := decodeResponse(status, ret, response); err != nil {
+		return 0, nil, err
 	}
 
-	return nil
+	return status, ret, nil
 }
 
 // MakeRequest does the request and returns the status, body, and any error.
 // This should be used only if the API doesn't return errors in the glitch.DataError format.
 func (c *client) MakeRequest(ctx context.Context, method string, slug string, query url.Values, headers http.Header, body io.Reader) (int, []byte, glitch.DataError) {
-	u, err := c.finder(c.serviceName, c.useTLS)
-	if err != nil {
-		return 0, nil, glitch.NewDataError(err, ErrorCantFind, "Error finding service")
+	ctx = c.withRequestMetadata(ctx)
+	o := callOptionsFrom(ctx)
+	headers = c.requestHeaders(ctx, o.applyHeaders(headers))
+
+	// Responses to calls made on behalf of someone else, or with credentials of the caller's own, are theirs alone
+	coalesce := c.coalescer != nil && c.coalescer.canCoalesce(method, body) && o.coalescable() && !c.propagates(ctx) &&
+		!c.callerCredentials(headers)
+	var key string
+	if coalesce {
+		// Every call has a request ID of its own, so the key is taken before it is added
+		key = c.coalescer.key(method, slug, query, headers)
+	}
+	headers, gErr := c.requestIDHeader(ctx, headers)
+	if gErr != nil {
+		return 0, nil, gErr
 	}
-	u.Path = slug
-	u.RawQuery = query.Encode()
 
-	req, err := http.NewRequest(method, u.String(), body)
-	if err != nil {
-		return 0, nil, glitch.NewDataError(err, ErrorRequestCreation, "Error creating request object")
+	if coalesce {
+		status, ret, err := c.coalescer.do(ctx, key, func(ctx context.Context) (int, *ResponseMetadata, []byte, glitch.DataError) {
+			// The shared request records its own metadata, which is handed to every caller waiting on it
+			ctx, md := ContextWithResponseMetadata(ctx)
+			status, ret, err := c.makeRequest(ctx, method, slug, query, headers, body)
+			return status, md, ret, err
+		})
+		return status, ret, c.requestIDError(ctx, err)
 	}
 
-	req.Header = headers
+	status, ret, err := c.makeRequest(ctx, method, slug, query, headers, body)
+	return status, ret, c.requestIDError(ctx, err)
+}
 
-	if ctx != nil {
-		req = req.WithContext(ctx)
+func (c *client) makeRequest(ctx context.Context, method string, slug string, query url.Values, headers http.Header, body io.Reader) (int, []byte, glitch.DataError) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	defer closeSeekerBody(body)
+	ct, cancel := c.withTimeouts(ctx, slug)
+	defer cancel()
+
+	req, gErr := c.newRequest(ct.ctx, method, slug, query, headers, body)
+	if gErr != nil {
+		return 0, nil, gErr
 	}
 
 	resp, err := c.client.Do(req)
+	c.recordRequestID(ctx, resp)
 	if err != nil {
-		return 0, nil, glitch.NewDataError(err, ErrorRequestError, "Could not make the request")
+		return 0, nil, requestError(ct.err(err))
+	}
+	if o := callOptionsFrom(ctx); o != nil {
+		trackDownload(resp, o.progress)
 	}
 	defer func() {
 		io.Copy(ioutil.Discard, resp.Body)
 		resp.Body.Close()
 	}()
 
+	recordResponse(ctx, resp)
+
 	ret, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
+		if err := ct.err(err); errors.Is(err, ErrTotalTimeout) || errors.Is(err, ErrInvalidSignature) {
+			return 0, nil, requestError(err)
+		}
 		return 0, nil, glitch.NewDataError(err, ErrorDecodingResponse, "Could not read response body")
 	}
 
 	return resp.StatusCode, ret, nil
 }
+
+// newRequest builds the request for a call made with ctx
+func (c *client) newRequest(ctx context.Context, method string, slug string, query url.Values, headers http.Header, body io.Reader) (*http.Request, glitch.DataError) {
+	u, err := c.baseURL(ctx)
+	if err != nil {
+		return nil, glitch.NewDataError(err, ErrorCantFind, "Error finding service")
+	}
+	u.Path = slug
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, glitch.NewDataError(err, ErrorRequestCreation, "Error creating request object")
+	}
+	if err := setRequestBody(req, body, c.replayBufferSize); err != nil {
+		return nil, glitch.NewDataError(err, ErrorRequestCreation, "Error reading request body")
+	}
+	if o := callOptionsFrom(ctx); o != nil {
+		trackUpload(req, o.progress)
+	}
+
+	req.Header = headers
+	if ct, ok := body.(ContentTyper); ok && headers.Get("Content-Type") == "" {
+		req.Header = cloneHeader(headers)
+		req.Header.Set("Content-Type", ct.ContentType())
+	}
+	if c.idempotency != nil {
+		key, ok, err := c.idempotency.idempotencyKey(ctx, method, headers)
+		if err != nil {
+			return nil, glitch.NewDataError(err, ErrorRequestCreation, "Error creating idempotency key")
+		}
+		if ok {
+			req.Header = cloneHeader(req.Header)
+			req.Header.Set(c.idempotency.Header, key)
+		}
+	}
+	// Redirects to other hosts are told apart by the host the call was sent to
+	ctx = withCallHost(ctx, u.Host)
+	if o := callOptionsFrom(ctx); o != nil && o.foreign {
+		ctx = withholdCredentials(ctx)
+		req.Header = c.withoutCredentials(req.Header)
+	} else if o != nil && o.elsewhere {
+		ctx = sentElsewhere(ctx)
+	}
+
+	return req.WithContext(ctx), nil
+}
+
+// requestError converts an error from the HTTP client into a glitch.DataError, preserving the more specific codes
+// raised by the client's own transports
+func requestError(err error) glitch.DataError {
+	switch {
+	case errors.Is(err, ErrLimitExceeded):
+		return glitch.NewDataError(err, ErrorConcurrencyLimit, "Too many requests in flight to the service")
+	case errors.Is(err, ErrBodyNotReplayable):
+		return glitch.NewDataError(err, ErrorBodyNotReplayable, "The request body could not be sent again")
+	case errors.Is(err, ErrDeadlineBudgetExceeded):
+		return glitch.NewDataError(err, ErrorDeadlineBudget, "Too little time remains before the deadline to make the request")
+	case errors.Is(err, ErrConnectTimeout):
+		return glitch.NewDataError(err, ErrorConnectTimeout, "Timed out connecting to the service")
+	case errors.Is(err, ErrTLSHandshakeTimeout):
+		return glitch.NewDataError(err, ErrorTLSTimeout, "Timed out during the TLS handshake with the service")
+	case errors.Is(err, ErrResponseHeaderTimeout):
+		return glitch.NewDataError(err, ErrorHeaderTimeout, "Timed out waiting for the service to respond")
+	case errors.Is(err, ErrAuthentication):
+		return glitch.NewDataError(err, ErrorAuthentication, "Could not authenticate the request")
+	case errors.Is(err, ErrSigning):
+		return glitch.NewDataError(err, ErrorSigning, "Could not sign the request")
+	case errors.Is(err, ErrInvalidSignature):
+		return glitch.NewDataError(err, ErrorInvalidSignature, "The response signature could not be verified")
+	case errors.Is(err, ErrTotalTimeout):
+		return glitch.NewDataError(err, ErrorTotalTimeout, "Timed out making the request")
+	}
+
+	return glitch.NewDataError(err, ErrorRequestError, "Could not make the request")
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// WithRequestCoalescing shares a single request between concurrent identical GET and HEAD calls without a body. Calls
+// are identical when their method, slug, query, and the values of the named headers match; any other headers are
+// taken from whichever call started the shared request. Each caller receives its own copy of the response and may
+// give up on its own context without affecting the others. The shared request runs until the latest deadline of the
+// callers waiting on it, and is only cancelled once every one of them has given up. Calls using call options other
+// than CallHeaders or CallFallback, calls passing credentials of their own, and calls made on behalf of an incoming
+// request are never shared.
+func WithRequestCoalescing(headers ...string) Option {
+	return func(c *client) {
+		keyHeaders := make([]string, len(headers))
+		for i, h := range headers {
+			keyHeaders[i] = http.CanonicalHeaderKey(h)
+		}
+		c.coalescer = &coalescer{headers: keyHeaders, calls: map[string]*coalescedCall{}}
+	}
+}
+
+// coalescer deduplicates concurrent calls sharing the same key
+type coalescer struct {
+	headers []string
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	// deadline is the latest deadline of the waiting callers, unless unbounded by one without a deadline
+	deadline  time.Time
+	unbounded bool
+
+	status int
+	md     *ResponseMetadata
+	body   []byte
+	err    glitch.DataError
+}
+
+// canCoalesce reports whether a call is safe to share with other callers
+func (g *coalescer) canCoalesce(method string, body io.Reader) bool {
+	return body == nil && (method == http.MethodGet || method == http.MethodHead)
+}
+
+// key identifies calls that can share a response
+func (g *coalescer) key(method string, slug string, query url.Values, headers http.Header) string {
+	var sb strings.Builder
+	sb.WriteString(method)
+	sb.WriteString(" ")
+	sb.WriteString(slug)
+	sb.WriteString("?")
+	sb.WriteString(query.Encode())
+	for _, h := range g.headers {
+		sb.WriteString("\n")
+		sb.WriteString(h)
+		sb.WriteString(": ")
+		sb.WriteString(strings.Join(headers.Values(h), ", "))
+	}
+
+	return sb.String()
+}
+
+// do runs fn once for all concurrent callers using key
+func (g *coalescer) do(ctx context.Context, key string, fn func(ctx context.Context) (int, *ResponseMetadata, []byte, glitch.DataError)) (int, []byte, glitch.DataError) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if !ok {
+		call = &coalescedCall{done: make(chan struct{})}
+		callCtx, cancel := context.WithCancel(sharedContext{detachedContext: detachedContext{parent: ctx}, g: g, call: call})
+		call.cancel = cancel
+		g.calls[key] = call
+
+		go func() {
+			call.status, call.md, call.body, call.err = fn(callCtx)
+			g.forget(key, call)
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.join(ctx)
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if md := responseMetadataFrom(ctx); md != nil {
+			md.StatusCode = call.status
+			md.Header = call.md.Header.Clone()
+			md.ResponseRequestID = call.md.ResponseRequestID
+			md.url, md.origin = call.md.url, call.md.origin
+		}
+		if call.body == nil {
+			return call.status, nil, call.err
+		}
+		body := make([]byte, len(call.body))
+		copy(body, call.body)
+		return call.status, body, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return 0, nil, requestError(ctx.Err())
+	}
+}
+
+// join adds a caller waiting on the call, extending the deadline of the call to that of the caller
+func (call *coalescedCall) join(ctx context.Context) {
+	call.waiters++
+
+	d, ok := ctx.Deadline()
+	switch {
+	case !ok:
+		call.unbounded = true
+		call.deadline = time.Time{}
+	case !call.unbounded && d.After(call.deadline):
+		call.deadline = d
+	}
+}
+
+func (g *coalescer) forget(key string, call *coalescedCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// detachedContext keeps the values of its parent while ignoring its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+// sharedContext keeps the values of the context that started a shared request while reporting the latest deadline of
+// the callers waiting on it, so the deadline is still propagated to the service
+type sharedContext struct {
+	detachedContext
+	g    *coalescer
+	call *coalescedCall
+}
+
+func (s sharedContext) Deadline() (time.Time, bool) {
+	s.g.mu.Lock()
+	defer s.g.mu.Unlock()
+
+	return s.call.deadline, !s.call.deadline.IsZero()
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+func TestUnit_WithRequestCoalescing(t *testing.T) {
+	type result struct {
+		status int
+		body   []byte
+		err    glitch.DataError
+	}
+
+	tests := map[string]struct {
+		callers  int
+		method   string
+		headers  func(i int) http.Header
+		ctx      func(i int) (context.Context, context.CancelFunc)
+		validate func(t *testing.T, results []result, calls int32)
+	}{
+		"base path- identical calls share one request": {
+			callers: 5,
+			method:  "GET",
+			validate: func(t *testing.T, results []result, calls int32) {
+				require.Equal(t, int32(1), calls)
+				for _, r := range results {
+					require.NoError(t, r.err)
+					require.Equal(t, http.StatusOK, r.status)
+					require.Equal(t, `{"foo":"bar"}`, string(r.body))
+				}
+				results[0].body[0] = 'X'
+				require.Equal(t, `{"foo":"bar"}`, string(results[1].body))
+			},
+		},
+		"base path- differing key headers are not shared": {
+			callers: 2,
+			method:  "GET",
+			headers: func(i int) http.Header {
+				return http.Header{"X-Tenant": []string{fmt.Sprintf("tenant-%d", i)}}
+			},
+			validate: func(t *testing.T, results []result, calls int32) {
+				require.Equal(t, int32(2), calls)
+			},
+		},
+		"base path- unsafe methods are not shared": {
+			callers: 3,
+			method:  "DELETE",
+			validate: func(t *testing.T, results []result, calls int32) {
+				require.Equal(t, int32(3), calls)
+			},
+		},
+		"exceptional path- one caller giving up does not affect the others": {
+			callers: 3,
+			method:  "GET",
+			ctx: func(i int) (context.Context, context.CancelFunc) {
+				if i == 0 {
+					return context.WithTimeout(context.Background(), 5*time.Millisecond)
+				}
+				return context.WithCancel(context.Background())
+			},
+			validate: func(t *testing.T, results []result, calls int32) {
+				require.Equal(t, int32(1), calls)
+				require.Error(t, results[0].err)
+				require.Equal(t, ErrorRequestError, results[0].err.Code())
+				for _, r := range results[1:] {
+					require.NoError(t, r.err)
+					require.Equal(t, `{"foo":"bar"}`, string(r.body))
+				}
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				_, _ = fmt.Fprintf(w, `{"foo":"bar"}`)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithRequestCoalescing("X-Tenant"))
+
+			results := make([]result, tc.callers)
+			var wg sync.WaitGroup
+			for i := 0; i < tc.callers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					ctx, cancel := context.WithCancel(context.Background())
+					if tc.ctx != nil {
+						ctx, cancel = tc.ctx(i)
+					}
+					defer cancel()
+					var headers http.Header
+					if tc.headers != nil {
+						headers = tc.headers(i)
+					}
+					status, body, err := bc.MakeRequest(ctx, tc.method, "1", nil, headers, nil)
+					results[i] = result{status: status, body: body, err: err}
+				}(i)
+			}
+			wg.Wait()
+
+			tc.validate(t, results, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestUnit_WithRequestCoalescingCallOptions(t *testing.T) {
+	tests := map[string]struct {
+		ctx      func(i int) (context.Context, context.CancelFunc)
+		validate func(t *testing.T, calls int32, budgets []string)
+	}{
+		"base path- deadline of the callers propagated": {
+			ctx: func(i int) (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), time.Duration(i+1)*time.Second)
+			},
+			validate: func(t *testing.T, calls int32, budgets []string) {
+				require.Equal(t, int32(1), calls)
+				budget, err := strconv.Atoi(budgets[0])
+				require.NoError(t, err)
+				require.Greater(t, budget, 0)
+				require.LessOrEqual(t, budget, 1000)
+			},
+		},
+		"base path- calls with their own options are not shared": {
+			ctx: func(i int) (context.Context, context.CancelFunc) {
+				ctx := context.Background()
+				if i == 0 {
+					ctx = WithCallOptions(ctx, CallTimeouts(Timeouts{Total: 5 * time.Second}))
+				}
+				return context.WithCancel(ctx)
+			},
+			validate: func(t *testing.T, calls int32, budgets []string) {
+				require.Equal(t, int32(2), calls)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			var mu sync.Mutex
+			var budgets []string
+			started := make(chan struct{})
+			release := make(chan struct{})
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				budgets = append(budgets, r.Header.Get(DefaultDeadlineHeader))
+				mu.Unlock()
+				if atomic.AddInt32(&calls, 1) == 1 {
+					close(started)
+				}
+				<-release
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 0, nil, WithRequestCoalescing(), WithDeadlinePropagation(DeadlineConfig{}))
+
+			var wg sync.WaitGroup
+			call := func(i int) {
+				defer wg.Done()
+				ctx, cancel := tc.ctx(i)
+				defer cancel()
+				_, _, err := bc.MakeRequest(ctx, http.MethodGet, "1", nil, nil, nil)
+				require.Nil(t, err)
+			}
+			wg.Add(2)
+			go call(0)
+			<-started
+			go call(1)
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			tc.validate(t, atomic.LoadInt32(&calls), budgets)
+		})
+	}
+}
+
+func TestUnit_WithRequestCoalescingCallerCredentials(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		_, _ = fmt.Fprintf(w, `{"user":%q}`, r.Header.Get("Authorization"))
+	}))
+	defer testServer.Close()
+
+	finder := func(serviceName string, useTLS bool) (url.URL, error) {
+		u, err := url.Parse(testServer.URL)
+		return *u, err
+	}
+	bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithRequestCoalescing())
+
+	users := []string{"Bearer alice", "Bearer bob"}
+	responses := make([]map[string]string, len(users))
+	var wg sync.WaitGroup
+	for i, user := range users {
+		wg.Add(1)
+		go func(i int, user string) {
+			defer wg.Done()
+			err := bc.Do(context.Background(), http.MethodGet, "/me", nil, http.Header{"Authorization": []string{user}}, nil, &responses[i])
+			require.Nil(t, err)
+		}(i, user)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	require.Equal(t, "Bearer alice", responses[0]["user"])
+	require.Equal(t, "Bearer bob", responses[1]["user"])
+}
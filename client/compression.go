@@ -0,0 +1,233 @@
+package client
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultCompressionMinSize is the smallest request body compressed unless CompressionConfig sets another size
+const DefaultCompressionMinSize = 1024
+
+// Codec compresses and decompresses bodies with one HTTP content coding. Codecs for codings the standard library
+// lacks, such as zstd or br, can be provided by implementing it.
+type Codec interface {
+	// Encoding is the name of the coding in Content-Encoding and Accept-Encoding, such as "gzip"
+	Encoding() string
+	// NewWriter returns a writer compressing into w; closing it must flush everything written
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	// NewReader returns a reader decompressing r
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCodec compresses with gzip at the given level, such as gzip.DefaultCompression
+func GzipCodec(level int) Codec {
+	return gzipCodec{level: level}
+}
+
+type gzipCodec struct {
+	level int
+}
+
+func (gzipCodec) Encoding() string {
+	return "gzip"
+}
+
+func (c gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, c.level)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// DeflateCodec compresses with the zlib format HTTP calls deflate, at the given level, such as
+// zlib.DefaultCompression
+func DeflateCodec(level int) Codec {
+	return deflateCodec{level: level}
+}
+
+type deflateCodec struct {
+	level int
+}
+
+func (deflateCodec) Encoding() string {
+	return "deflate"
+}
+
+func (c deflateCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, c.level)
+}
+
+func (deflateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+// CompressionConfig configures compression of request and response bodies
+type CompressionConfig struct {
+	// Request compresses request bodies with this codec; leave it nil to send them as they are
+	Request Codec
+	// MinSize is the smallest request body compressed; defaults to DefaultCompressionMinSize. Bodies of unknown size
+	// are always compressed, and a negative size compresses every body.
+	MinSize int64
+	// Accept are the codings asked for in Accept-Encoding and decoded from responses; defaults to gzip and deflate.
+	// The Request codec is always accepted.
+	Accept []Codec
+}
+
+// WithCompression compresses request bodies and asks for compressed responses, decompressing them before they are
+// decoded or returned. Requests that already set Content-Encoding or Accept-Encoding are left to the caller.
+func WithCompression(cfg CompressionConfig) Option {
+	return func(c *client) {
+		c.compression = &cfg
+	}
+}
+
+// compressionTransport compresses request bodies and decompresses response bodies
+type compressionTransport struct {
+	next    http.RoundTripper
+	request Codec
+	minSize int64
+	codecs  map[string]Codec
+	accept  string
+}
+
+func newCompressionTransport(next http.RoundTripper, cfg CompressionConfig) *compressionTransport {
+	if cfg.MinSize == 0 {
+		cfg.MinSize = DefaultCompressionMinSize
+	}
+	if cfg.Accept == nil {
+		cfg.Accept = []Codec{GzipCodec(gzip.DefaultCompression), DeflateCodec(zlib.DefaultCompression)}
+	}
+	if cfg.Request != nil {
+		cfg.Accept = append([]Codec{cfg.Request}, cfg.Accept...)
+	}
+
+	t := &compressionTransport{next: next, request: cfg.Request, minSize: cfg.MinSize, codecs: map[string]Codec{}}
+	var names []string
+	for _, codec := range cfg.Accept {
+		name := strings.ToLower(codec.Encoding())
+		if _, ok := t.codecs[name]; ok {
+			continue
+		}
+		t.codecs[name] = codec
+		names = append(names, name)
+	}
+	t.accept = strings.Join(names, ", ")
+
+	return t
+}
+
+func (t *compressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	compress := t.compresses(req)
+	accept := req.Header.Get("Accept-Encoding") == "" && t.accept != ""
+	if compress || accept {
+		r := req.Clone(req.Context())
+		if accept {
+			r.Header.Set("Accept-Encoding", t.accept)
+		}
+		if compress {
+			t.compressBody(r, req)
+		}
+		req = r
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || !accept {
+		return resp, err
+	}
+
+	codec, ok := t.codecs[strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))]
+	if !ok || resp.Body == nil || resp.Body == http.NoBody {
+		return resp, nil
+	}
+	resp.Body = &decodingBody{body: resp.Body, codec: codec}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	return resp, nil
+}
+
+// compresses reports whether the body of req should be compressed
+func (t *compressionTransport) compresses(req *http.Request) bool {
+	if t.request == nil || req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	return req.ContentLength <= 0 || t.minSize < 0 || req.ContentLength >= t.minSize
+}
+
+// compressBody replaces the body of r, a clone of req, with its compressed form
+func (t *compressionTransport) compressBody(r *http.Request, req *http.Request) {
+	r.Header.Set("Content-Encoding", t.request.Encoding())
+	r.ContentLength = -1
+	r.Body = compressReader(req.Body, t.request)
+	if req.GetBody != nil {
+		r.GetBody = func() (io.ReadCloser, error) {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			return compressReader(body, t.request), nil
+		}
+	}
+}
+
+// compressReader streams body compressed with codec, closing body once it is read or the reader is closed
+func compressReader(body io.ReadCloser, codec Codec) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+
+		w, err := codec.NewWriter(pw)
+		if err == nil {
+			_, err = io.Copy(w, body)
+			if cErr := w.Close(); err == nil {
+				err = cErr
+			}
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	return pr
+}
+
+// decodingBody decompresses a response body, starting on the first read so empty bodies are not mistaken for
+// corrupt ones
+type decodingBody struct {
+	body  io.ReadCloser
+	codec Codec
+	r     io.ReadCloser
+	err   error
+}
+
+func (b *decodingBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		r, err := b.codec.NewReader(b.body)
+		switch {
+		case err == io.EOF:
+			b.r = http.NoBody
+		case err != nil:
+			b.err = err
+		default:
+			b.r = r
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	return b.r.Read(p)
+}
+
+func (b *decodingBody) Close() error {
+	if b.r != nil {
+		_ = b.r.Close()
+	}
+
+	return b.body.Close()
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// upperCodec is a toy coding standing in for codecs provided outside the package
+type upperCodec struct{}
+
+func (upperCodec) Encoding() string {
+	return "x-upper"
+}
+
+func (upperCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return upperWriter{w: w}, nil
+}
+
+func (upperCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	by, err := ioutil.ReadAll(r)
+	return ioutil.NopCloser(bytes.NewReader(bytes.ToLower(by))), err
+}
+
+type upperWriter struct {
+	w io.Writer
+}
+
+func (u upperWriter) Write(p []byte) (int, error) {
+	return u.w.Write(bytes.ToUpper(p))
+}
+
+func (upperWriter) Close() error {
+	return nil
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestUnit_WithCompression(t *testing.T) {
+	large := strings.Repeat(`{"name":"value"}`, 100)
+
+	tests := map[string]struct {
+		cfg      CompressionConfig
+		method   string
+		body     string
+		headers  http.Header
+		handler  func(t *testing.T, attempt int32, w http.ResponseWriter, r *http.Request)
+		validate func(t *testing.T, response map[string]string, err glitch.DataError)
+	}{
+		"base path- large request body compressed": {
+			cfg:    CompressionConfig{Request: GzipCodec(gzip.BestSpeed)},
+			method: http.MethodPost,
+			body:   large,
+			handler: func(t *testing.T, attempt int32, w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+				require.Equal(t, "gzip, deflate", r.Header.Get("Accept-Encoding"))
+				zr, err := gzip.NewReader(r.Body)
+				require.NoError(t, err)
+				by, _ := ioutil.ReadAll(zr)
+				require.Equal(t, large, string(by))
+				_, _ = w.Write([]byte(`{"result":"ok"}`))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "ok", response["result"])
+			},
+		},
+		"base path- small request body sent as is": {
+			cfg:    CompressionConfig{Request: GzipCodec(gzip.DefaultCompression)},
+			method: http.MethodPost,
+			body:   `{"name":"value"}`,
+			handler: func(t *testing.T, attempt int32, w http.ResponseWriter, r *http.Request) {
+				require.Empty(t, r.Header.Get("Content-Encoding"))
+				by, _ := ioutil.ReadAll(r.Body)
+				require.Equal(t, `{"name":"value"}`, string(by))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+			},
+		},
+		"base path- custom codec and no minimum size": {
+			cfg:    CompressionConfig{Request: upperCodec{}, MinSize: -1},
+			method: http.MethodPost,
+			body:   `{"name":"value"}`,
+			handler: func(t *testing.T, attempt int32, w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "x-upper", r.Header.Get("Content-Encoding"))
+				require.Equal(t, "x-upper, gzip, deflate", r.Header.Get("Accept-Encoding"))
+				by, _ := ioutil.ReadAll(r.Body)
+				require.Equal(t, `{"NAME":"VALUE"}`, string(by))
+				w.Header().Set("Content-Encoding", "x-upper")
+				_, _ = w.Write([]byte(`{"RESULT":"OK"}`))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "ok", response["result"])
+			},
+		},
+		"base path- compressed body replayed on retry": {
+			cfg:    CompressionConfig{Request: DeflateCodec(zlib.DefaultCompression)},
+			method: http.MethodPut,
+			body:   large,
+			handler: func(t *testing.T, attempt int32, w http.ResponseWriter, r *http.Request) {
+				zr, err := zlib.NewReader(r.Body)
+				require.NoError(t, err)
+				by, _ := ioutil.ReadAll(zr)
+				require.Equal(t, large, string(by))
+				if attempt == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+			},
+		},
+		"base path- gzip response decoded": {
+			method: http.MethodGet,
+			handler: func(t *testing.T, attempt int32, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				_, _ = w.Write(gzipped(t, `{"result":"ok"}`))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "ok", response["result"])
+			},
+		},
+		"base path- empty compressed response": {
+			method: http.MethodGet,
+			handler: func(t *testing.T, attempt int32, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+			},
+		},
+		"base path- caller's encodings left alone": {
+			cfg:     CompressionConfig{Request: GzipCodec(gzip.DefaultCompression), MinSize: -1},
+			method:  http.MethodPost,
+			body:    `{"name":"value"}`,
+			headers: http.Header{"Content-Encoding": []string{"identity"}, "Accept-Encoding": []string{"identity"}},
+			handler: func(t *testing.T, attempt int32, w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "identity", r.Header.Get("Content-Encoding"))
+				require.Equal(t, "identity", r.Header.Get("Accept-Encoding"))
+				by, _ := ioutil.ReadAll(r.Body)
+				require.Equal(t, `{"name":"value"}`, string(by))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+			},
+		},
+		"exceptional path- compressed problem decoded": {
+			method: http.MethodGet,
+			handler: func(t *testing.T, attempt int32, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write(gzipped(t, `{"code":"CONFLICT","status":409}`))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, "CONFLICT", err.Code())
+			},
+		},
+		"exceptional path- corrupt response": {
+			method: http.MethodGet,
+			handler: func(t *testing.T, attempt int32, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				_, _ = w.Write([]byte("not gzip"))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorDecodingResponse, err.Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var attempts int32
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tc.handler(t, atomic.AddInt32(&attempts, 1), w, r)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil,
+				WithCompression(tc.cfg), WithRetries(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			response := map[string]string{}
+			err := bc.Do(context.Background(), tc.method, "/1", nil, tc.headers, body, &response)
+			tc.validate(t, response, err)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultDeadlineHeader is the header carrying the remaining deadline budget unless configured otherwise
+const DefaultDeadlineHeader = "Request-Timeout"
+
+// ErrDeadlineBudgetExceeded is returned when too little of the caller's deadline remains to make a request
+var ErrDeadlineBudgetExceeded = errors.New("deadline budget exceeded")
+
+// DeadlineFormat controls how the remaining budget is written into the deadline header
+type DeadlineFormat int
+
+// Deadline formats
+const (
+	// DeadlineMilliseconds writes the budget as a whole number of milliseconds, e.g. "1500"
+	DeadlineMilliseconds DeadlineFormat = iota
+	// DeadlineSeconds writes the budget as a decimal number of seconds, e.g. "1.5"
+	DeadlineSeconds
+	// DeadlineGRPC writes the budget in the gRPC timeout format, e.g. "1500m"
+	DeadlineGRPC
+)
+
+// DeadlineConfig configures the propagation of the caller's deadline to downstream services
+type DeadlineConfig struct {
+	// Header is the header carrying the remaining budget; defaults to DefaultDeadlineHeader
+	Header string
+	// Format is how the budget is written into Header; defaults to DeadlineMilliseconds
+	Format DeadlineFormat
+	// SafetyMargin is subtracted from the remaining budget to leave time for the response to travel back
+	SafetyMargin time.Duration
+	// MinBudget fails a request locally when less than it remains of the budget, after the safety margin
+	MinBudget time.Duration
+}
+
+// WithDeadlinePropagation sends the time remaining until the deadline of the context passed to Do or MakeRequest to
+// the service, so it can stop working on requests the caller has given up on. The client's own timeout also bounds
+// the budget; requests with neither are sent unchanged.
+func WithDeadlinePropagation(cfg DeadlineConfig) Option {
+	return func(c *client) {
+		if cfg.Header == "" {
+			cfg.Header = DefaultDeadlineHeader
+		}
+		c.deadlines = &cfg
+	}
+}
+
+// deadlineTransport stamps each attempt of a request with the budget remaining at the time it is sent
+type deadlineTransport struct {
+	next http.RoundTripper
+	cfg  DeadlineConfig
+	now  func() time.Time
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	budget := deadline.Sub(t.now()) - t.cfg.SafetyMargin
+	if budget <= 0 || budget < t.cfg.MinBudget {
+		closeRequestBody(req)
+		return nil, ErrDeadlineBudgetExceeded
+	}
+
+	r := req.Clone(req.Context())
+	r.Header.Set(t.cfg.Header, formatDeadline(budget, t.cfg.Format))
+
+	return t.next.RoundTrip(r)
+}
+
+// formatDeadline writes budget in the given format, rounding down so the service never sees more time than remains
+func formatDeadline(budget time.Duration, format DeadlineFormat) string {
+	switch format {
+	case DeadlineSeconds:
+		return strconv.FormatFloat(budget.Truncate(time.Millisecond).Seconds(), 'f', -1, 64)
+	case DeadlineGRPC:
+		return grpcTimeout(budget)
+	}
+
+	return strconv.FormatInt(int64(budget/time.Millisecond), 10)
+}
+
+// grpcTimeout formats d as a gRPC timeout, which allows at most eight digits followed by a unit
+func grpcTimeout(d time.Duration) string {
+	const maxValue = 99999999
+
+	units := []struct {
+		size   time.Duration
+		suffix string
+	}{
+		{time.Nanosecond, "n"},
+		{time.Microsecond, "u"},
+		{time.Millisecond, "m"},
+		{time.Second, "S"},
+		{time.Minute, "M"},
+		{time.Hour, "H"},
+	}
+	for _, u := range units {
+		if v := int64(d / u.size); v <= maxValue {
+			return strconv.FormatInt(v, 10) + u.suffix
+		}
+	}
+
+	return strconv.FormatInt(maxValue, 10) + "H"
+}
+
+// closeRequestBody closes the body of a request that will not be sent, as a RoundTripper must
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sprak3000/go-glitch/glitch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_WithDeadlinePropagation(t *testing.T) {
+	tests := map[string]struct {
+		cfg      DeadlineConfig
+		timeout  time.Duration
+		validate func(t *testing.T, received http.Header, calls int32, err glitch.DataError)
+	}{
+		"base path- remaining budget sent in milliseconds": {
+			timeout: 5 * time.Second,
+			validate: func(t *testing.T, received http.Header, calls int32, err glitch.DataError) {
+				require.Nil(t, err)
+				v := received.Get("Request-Timeout")
+				require.NotEmpty(t, v)
+				var ms int64
+				_, scanErr := fmt.Sscanf(v, "%d", &ms)
+				require.NoError(t, scanErr)
+				require.LessOrEqual(t, ms, int64(5000))
+				require.Greater(t, ms, int64(4000))
+			},
+		},
+		"base path- safety margin subtracted and custom header used": {
+			cfg:     DeadlineConfig{Header: "Grpc-Timeout", Format: DeadlineGRPC, SafetyMargin: 2 * time.Second},
+			timeout: 5 * time.Second,
+			validate: func(t *testing.T, received http.Header, calls int32, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Empty(t, received.Get("Request-Timeout"))
+				v := received.Get("Grpc-Timeout")
+				require.Regexp(t, `^\d{1,8}u$`, v)
+				var us int64
+				_, scanErr := fmt.Sscanf(v, "%du", &us)
+				require.NoError(t, scanErr)
+				require.LessOrEqual(t, us, int64(3000000))
+			},
+		},
+		"base path- client timeout bounds the budget without a context deadline": {
+			validate: func(t *testing.T, received http.Header, calls int32, err glitch.DataError) {
+				require.Nil(t, err)
+				var ms int64
+				_, scanErr := fmt.Sscanf(received.Get("Request-Timeout"), "%d", &ms)
+				require.NoError(t, scanErr)
+				require.LessOrEqual(t, ms, int64(10000))
+				require.Greater(t, ms, int64(9000))
+			},
+		},
+		"exceptional path- budget below the minimum fails locally": {
+			cfg:     DeadlineConfig{SafetyMargin: 50 * time.Millisecond, MinBudget: 100 * time.Millisecond},
+			timeout: 120 * time.Millisecond,
+			validate: func(t *testing.T, received http.Header, calls int32, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorDeadlineBudget, err.Code())
+				require.Equal(t, int32(0), calls)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			var received http.Header
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				received = r.Header
+				_, _ = fmt.Fprintf(w, `{}`)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithDeadlinePropagation(tc.cfg))
+
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			_, _, err := bc.MakeRequest(ctx, "GET", "1", nil, nil, nil)
+			tc.validate(t, received, atomic.LoadInt32(&calls), err)
+		})
+	}
+}
+
+func TestUnit_formatDeadline(t *testing.T) {
+	tests := map[string]struct {
+		budget   time.Duration
+		format   DeadlineFormat
+		expected string
+	}{
+		"base path- milliseconds": {
+			budget:   1500*time.Millisecond + 900*time.Microsecond,
+			format:   DeadlineMilliseconds,
+			expected: "1500",
+		},
+		"base path- seconds": {
+			budget:   1500*time.Millisecond + 900*time.Microsecond,
+			format:   DeadlineSeconds,
+			expected: "1.5",
+		},
+		"base path- gRPC small budget keeps nanosecond precision": {
+			budget:   1500 * time.Microsecond,
+			format:   DeadlineGRPC,
+			expected: "1500000n",
+		},
+		"base path- gRPC large budget uses a coarser unit": {
+			budget:   2 * time.Minute,
+			format:   DeadlineGRPC,
+			expected: "120000m",
+		},
+		"base path- gRPC huge budget": {
+			budget:   5000 * time.Hour,
+			format:   DeadlineGRPC,
+			expected: "18000000S",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, formatDeadline(tc.budget, tc.format))
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// StatusResponses maps response statuses to the values their bodies are decoded into. Pass one as the response of Do
+// for endpoints returning a different shape per status. The entry for 0 catches statuses without an entry of their
+// own; bodies of other statuses are not decoded. Statuses Do treats as errors, including 1xx and 3xx other than 304,
+// fail before reaching StatusResponses. Use ContextWithResponseMetadata to learn which status was received.
+type StatusResponses map[int]interface{}
+
+// target returns the value a body with status is decoded into
+func (sr StatusResponses) target(status int) interface{} {
+	if t, ok := sr[status]; ok {
+		return t
+	}
+
+	return sr[0]
+}
+
+// statusError classifies a response status. Statuses of 400 and above are decoded as problems; informational statuses
+// and the redirects net/http did not follow, other than 304 Not Modified, are unexpected. Other statuses are
+// successful and return nil.
+func statusError(status int, body []byte, msg string) glitch.DataError {
+	switch {
+	case status >= http.StatusBadRequest:
+		return decodeProblem(body, msg)
+	case status < http.StatusOK, status >= http.StatusMultipleChoices && status != http.StatusNotModified:
+		return glitch.NewDataError(fmt.Errorf("unexpected status %d", status), ErrorUnexpectedStatus, msg)
+	}
+
+	return nil
+}
+
+// decodeProblem converts the body of an error response into a glitch.DataError described by msg
+func decodeProblem(body []byte, msg string) glitch.DataError {
+	prob := glitch.HTTPProblem{}
+	if err := json.Unmarshal(body, &prob); err != nil {
+		return glitch.NewDataError(err, ErrorDecodingError, "Could not decode error response")
+	}
+
+	return glitch.FromHTTPProblem(prob, msg)
+}
+
+// decodeResponse decodes the body of a successful response into response. Responses without content, such as
+// 204 No Content, 205 Reset Content, 304 Not Modified, or an empty body, leave response untouched.
+func decodeResponse(status int, body []byte, response interface{}) glitch.DataError {
+	if sr, ok := response.(StatusResponses); ok {
+		response = sr.target(status)
+	}
+	if response == nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	switch status {
+	case http.StatusNoContent, http.StatusResetContent, http.StatusNotModified:
+		return nil
+	}
+
+	if err := json.Unmarshal(body, response); err != nil {
+		return glitch.NewDataError(err, ErrorDecodingResponse, "Could not decode response")
+	}
+
+	return nil
+}
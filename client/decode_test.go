@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+func TestUnit_decodeResponse(t *testing.T) {
+	type created struct {
+		ID string `json:"id"`
+	}
+	type multi struct {
+		Results []int `json:"results"`
+	}
+
+	tests := map[string]struct {
+		status   int
+		body     string
+		response func() interface{}
+		validate func(t *testing.T, response interface{}, err glitch.DataError)
+	}{
+		"base path- plain response": {
+			status:   http.StatusOK,
+			body:     `{"id":"1"}`,
+			response: func() interface{} { return &created{} },
+			validate: func(t *testing.T, response interface{}, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, &created{ID: "1"}, response)
+			},
+		},
+		"base path- status specific target": {
+			status: http.StatusMultiStatus,
+			body:   `{"results":[1,2]}`,
+			response: func() interface{} {
+				return StatusResponses{http.StatusCreated: &created{}, http.StatusMultiStatus: &multi{}}
+			},
+			validate: func(t *testing.T, response interface{}, err glitch.DataError) {
+				require.Nil(t, err)
+				sr := response.(StatusResponses)
+				require.Equal(t, &created{}, sr[http.StatusCreated])
+				require.Equal(t, &multi{Results: []int{1, 2}}, sr[http.StatusMultiStatus])
+			},
+		},
+		"base path- default target": {
+			status:   http.StatusOK,
+			body:     `{"id":"2"}`,
+			response: func() interface{} { return StatusResponses{0: &created{}, http.StatusMultiStatus: &multi{}} },
+			validate: func(t *testing.T, response interface{}, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, &created{ID: "2"}, response.(StatusResponses)[0])
+			},
+		},
+		"base path- status without a target not decoded": {
+			status:   http.StatusOK,
+			body:     `not json`,
+			response: func() interface{} { return StatusResponses{http.StatusCreated: &created{}} },
+			validate: func(t *testing.T, response interface{}, err glitch.DataError) {
+				require.Nil(t, err)
+			},
+		},
+		"base path- no content not decoded": {
+			status:   http.StatusNoContent,
+			response: func() interface{} { return &created{} },
+			validate: func(t *testing.T, response interface{}, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, &created{}, response)
+			},
+		},
+		"base path- empty body not decoded": {
+			status:   http.StatusOK,
+			body:     " \n",
+			response: func() interface{} { return &created{} },
+			validate: func(t *testing.T, response interface{}, err glitch.DataError) {
+				require.Nil(t, err)
+			},
+		},
+		"exceptional path- invalid body": {
+			status:   http.StatusOK,
+			body:     `not json`,
+			response: func() interface{} { return &created{} },
+			validate: func(t *testing.T, response interface{}, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorDecodingResponse, err.Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			response := tc.response()
+			tc.validate(t, response, decodeResponse(tc.status, []byte(tc.body), response))
+		})
+	}
+}
+
+func TestUnit_statusHandling(t *testing.T) {
+	tests := map[string]struct {
+		status       int
+		location     string
+		expectedCode string
+	}{
+		"base path- followed redirect answered by its target": {
+			status:   http.StatusFound,
+			location: "/2",
+		},
+		"base path- 205 reset content is a success": {
+			status: http.StatusResetContent,
+		},
+		"base path- 304 not modified is a success": {
+			status: http.StatusNotModified,
+		},
+		"exceptional path- 302 without a location is not followed and is unexpected": {
+			status:       http.StatusFound,
+			expectedCode: ErrorUnexpectedStatus,
+		},
+		"exceptional path- 307 without a location is not followed and is unexpected": {
+			status:       http.StatusTemporaryRedirect,
+			expectedCode: ErrorUnexpectedStatus,
+		},
+		"exceptional path- 300 multiple choices is unexpected": {
+			status:       http.StatusMultipleChoices,
+			expectedCode: ErrorUnexpectedStatus,
+		},
+		"exceptional path- errors decoded as problems": {
+			status:       http.StatusConflict,
+			expectedCode: "CONFLICT",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.location != "" {
+					if r.URL.Path == tc.location {
+						_, _ = w.Write([]byte(`{"foo":"bar"}`))
+						return
+					}
+					http.Redirect(w, r, tc.location, tc.status)
+					return
+				}
+				w.WriteHeader(tc.status)
+				if tc.status >= http.StatusBadRequest {
+					_, _ = w.Write([]byte(`{"code":"CONFLICT","status":` + strconv.Itoa(tc.status) + `}`))
+				}
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil)
+
+			response := map[string]string{}
+			err := bc.Do(context.Background(), "GET", "/1", nil, nil, nil, &response)
+			if tc.expectedCode == "" {
+				require.Nil(t, err)
+				return
+			}
+			require.NotNil(t, err)
+			require.Equal(t, tc.expectedCode, err.Code())
+		})
+	}
+}
+
+func TestUnit_statusError(t *testing.T) {
+	tests := map[string]struct {
+		status       int
+		expectedCode string
+	}{
+		"base path- 200 ok is a success": {
+			status: http.StatusOK,
+		},
+		"base path- 304 not modified is a success": {
+			status: http.StatusNotModified,
+		},
+		"exceptional path- 100 continue is unexpected": {
+			status:       http.StatusContinue,
+			expectedCode: ErrorUnexpectedStatus,
+		},
+		"exceptional path- 101 switching protocols is unexpected": {
+			status:       http.StatusSwitchingProtocols,
+			expectedCode: ErrorUnexpectedStatus,
+		},
+		"exceptional path- 301 left unfollowed is unexpected": {
+			status:       http.StatusMovedPermanently,
+			expectedCode: ErrorUnexpectedStatus,
+		},
+		"exceptional path- 500 decoded as a problem": {
+			status:       http.StatusInternalServerError,
+			expectedCode: "INTERNAL",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := statusError(tc.status, []byte(`{"code":"INTERNAL","status":500}`), "msg")
+			if tc.expectedCode == "" {
+				require.Nil(t, err)
+				return
+			}
+			require.NotNil(t, err)
+			require.Equal(t, tc.expectedCode, err.Code())
+		})
+	}
+}
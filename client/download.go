@@ -0,0 +1,518 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// Download error codes
+const (
+	ErrorDownload         = "DOWNLOAD_FAILED"
+	ErrorChecksumMismatch = "CHECKSUM_MISMATCH"
+)
+
+const (
+	defaultDownloadResumes     = 3
+	defaultDownloadResumeDelay = time.Second
+	defaultDownloadChunkSize   = 8 << 20
+)
+
+// errRepresentationChanged is raised when the resource changes between the requests of a download
+var errRepresentationChanged = errors.New("resource changed during the download")
+
+// DownloadConfig describes a download
+type DownloadConfig struct {
+	// Slug, Query, and Headers describe the GET request for the resource
+	Slug    string
+	Query   url.Values
+	Headers http.Header
+	// Resumes is how many times an interrupted request is resumed with Range and If-Range; defaults to 3. A negative
+	// value never resumes.
+	Resumes int
+	// ResumeDelay is the time waited before resuming; defaults to 1s
+	ResumeDelay time.Duration
+	// Concurrency downloads the resource in up to this many chunks at once when the service supports ranges and the
+	// destination is an io.ReaderAt, as files are. Values below 2 download it in one piece.
+	Concurrency int
+	// ChunkSize is the size of each chunk of a concurrent download; defaults to 8MiB
+	ChunkSize int64
+	// Hash and Checksum verify the downloaded resource against a checksum known beforehand. Setting only one of them is
+	// an error.
+	Hash     func() hash.Hash
+	Checksum []byte
+	// Progress is called as the download makes progress
+	Progress ProgressFunc
+}
+
+// Download fetches the resource described by cfg through s and writes it to w, returning its size. Interrupted
+// requests are resumed from where they stopped. The download is verified against the Content-Digest of each response,
+// the Repr-Digest or Digest of the resource, and the checksum in cfg, whichever are available. Failed checks are
+// reported with ErrorChecksumMismatch.
+//
+// What was written to w is left in place when the download fails; its content is then undefined.
+func Download(ctx context.Context, s Streamer, cfg DownloadConfig, w io.WriterAt) (int64, glitch.DataError) {
+	if (cfg.Hash != nil) != (len(cfg.Checksum) > 0) {
+		return 0, glitch.NewDataError(errors.New("hash and checksum must be set together"), ErrorDownload, "Could not verify the download")
+	}
+	if cfg.Resumes == 0 {
+		cfg.Resumes = defaultDownloadResumes
+	}
+	if cfg.ResumeDelay <= 0 {
+		cfg.ResumeDelay = defaultDownloadResumeDelay
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = defaultDownloadChunkSize
+	}
+
+	d := &download{streamer: s, cfg: cfg, w: w, size: -1}
+	if cfg.Progress != nil {
+		d.tracker = newProgressTracker(cfg.Progress, 0, 0, -1)
+	}
+	if ra, ok := w.(io.ReaderAt); ok && cfg.Concurrency > 1 {
+		d.readBack = ra
+	}
+
+	var size int64
+	var gErr glitch.DataError
+	if d.readBack != nil {
+		size, gErr = d.concurrent(ctx)
+	} else {
+		size, gErr = d.fetch(ctx, 0, -1)
+	}
+	if gErr != nil {
+		return 0, gErr
+	}
+	if d.tracker != nil {
+		d.tracker.done()
+	}
+
+	return size, d.verify(size)
+}
+
+// DownloadFile downloads the resource described by cfg into the file at path, creating or truncating it. The file
+// is removed if the download fails.
+func DownloadFile(ctx context.Context, s Streamer, cfg DownloadConfig, path string) (int64, glitch.DataError) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, glitch.NewDataError(err, ErrorDownload, "Could not create the download file")
+	}
+
+	size, gErr := Download(ctx, s, cfg, f)
+	if gErr == nil {
+		if err := f.Truncate(size); err != nil {
+			gErr = glitch.NewDataError(err, ErrorDownload, "Could not write the download file")
+		}
+	}
+	if err := f.Close(); err != nil && gErr == nil {
+		gErr = glitch.NewDataError(err, ErrorDownload, "Could not write the download file")
+	}
+	if gErr != nil {
+		_ = os.Remove(path)
+		return 0, gErr
+	}
+
+	return size, nil
+}
+
+type download struct {
+	streamer Streamer
+	cfg      DownloadConfig
+	w        io.WriterAt
+	readBack io.ReaderAt
+
+	// size, validator, and header describe the resource; they are set by the first response
+	size      int64
+	validator string
+	header    http.Header
+	ranged    bool
+	// digests hash the resource as it is written when it is downloaded in one piece
+	digests []digestCheck
+
+	tracker *progressTracker
+}
+
+// concurrent fetches the first chunk, which tells the size of the resource, then the remaining chunks at once
+func (d *download) concurrent(ctx context.Context) (int64, glitch.DataError) {
+	first, gErr := d.fetch(ctx, 0, d.cfg.ChunkSize-1)
+	if gErr != nil {
+		return 0, gErr
+	}
+	if d.size < 0 && d.ranged {
+		return d.fetch(ctx, first, -1)
+	}
+	if d.size <= first {
+		return first, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make(chan int64)
+	errs := make(chan glitch.DataError, d.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < d.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range chunks {
+				end := start + d.cfg.ChunkSize - 1
+				if end >= d.size {
+					end = d.size - 1
+				}
+				if _, gErr := d.fetch(ctx, start, end); gErr != nil {
+					errs <- gErr
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(chunks)
+		for start := first; start < d.size; start += d.cfg.ChunkSize {
+			select {
+			case chunks <- start:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	if gErr := <-errs; gErr != nil {
+		return 0, gErr
+	}
+
+	return d.size, nil
+}
+
+// fetch downloads the bytes from start to end, or to the end of the resource when end is negative, resuming the
+// request when it is interrupted. It returns the offset following the last byte written.
+func (d *download) fetch(ctx context.Context, start int64, end int64) (int64, glitch.DataError) {
+	pos := start
+	resumes := 0
+	for {
+		resp, md, gErr := d.open(ctx, pos, end)
+		if gErr != nil {
+			if md.StatusCode == http.StatusRequestedRangeNotSatisfiable && d.complete(md.Header, pos) {
+				return pos, nil
+			}
+			if md.StatusCode != 0 || ctx.Err() != nil || resumes >= d.cfg.Resumes {
+				return 0, gErr
+			}
+		} else {
+			var err error
+			pos, err = d.read(resp, start, pos)
+			resp.Body.Close()
+			if err == nil {
+				return pos, nil
+			}
+			if gErr, ok := err.(glitch.DataError); ok {
+				return 0, gErr
+			}
+			if ctx.Err() != nil {
+				return 0, requestError(ctx.Err())
+			}
+			if errors.Is(err, errRepresentationChanged) || resumes >= d.cfg.Resumes {
+				return 0, glitch.NewDataError(err, ErrorDownload, "Could not download the resource")
+			}
+		}
+
+		resumes++
+		timer := time.NewTimer(d.cfg.ResumeDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, requestError(ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// open requests the bytes from pos to end, returning what is known of the response even if it failed
+func (d *download) open(ctx context.Context, pos int64, end int64) (*http.Response, *ResponseMetadata, glitch.DataError) {
+	headers := cloneHeader(d.cfg.Headers)
+	// Ranges must address the resource as stored rather than a compressed encoding of it
+	headers.Set("Accept-Encoding", "identity")
+	if pos > 0 || end >= 0 {
+		r := fmt.Sprintf("bytes=%d-", pos)
+		if end >= 0 {
+			r += strconv.FormatInt(end, 10)
+		}
+		headers.Set("Range", r)
+		if d.validator != "" {
+			headers.Set("If-Range", d.validator)
+		}
+	}
+
+	ctx, md := ContextWithResponseMetadata(ctx)
+	resp, err := d.streamer.Stream(ctx, http.MethodGet, d.cfg.Slug, d.cfg.Query, headers, nil)
+	return resp, md, err
+}
+
+// complete reports whether a 416 Range Not Satisfiable answer means the resource ends at pos, as happens when it is
+// empty or the connection dropped just after its last byte
+func (d *download) complete(header http.Header, pos int64) bool {
+	v := header.Get("Content-Range")
+	if !strings.HasPrefix(v, "bytes */") {
+		return false
+	}
+	size, err := strconv.ParseInt(strings.TrimPrefix(v, "bytes */"), 10, 64)
+	if err != nil || size != pos {
+		return false
+	}
+	if d.header == nil {
+		return d.describe(header, size) == nil
+	}
+
+	return true
+}
+
+// read writes the body of resp to w. The response is either the requested range, starting at pos, or the whole
+// resource, which restarts a download in one piece from the beginning. It returns the offset following the last byte
+// written.
+func (d *download) read(resp *http.Response, start int64, pos int64) (int64, error) {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		first, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return pos, glitch.NewDataError(err, ErrorDownload, "Could not read the range sent by the service")
+		}
+		if first != pos {
+			return pos, glitch.NewDataError(fmt.Errorf("asked for offset %d, received %d", pos, first), ErrorDownload, "The service sent the wrong range")
+		}
+		if d.header == nil {
+			d.ranged = true
+			if gErr := d.describe(resp.Header, size); gErr != nil {
+				return pos, gErr
+			}
+		} else if v := validator(resp.Header); v != "" && v != d.validator {
+			return pos, errRepresentationChanged
+		}
+	default:
+		if start > 0 {
+			// Only the first chunk of a concurrent download can start over
+			return pos, errRepresentationChanged
+		}
+		pos = 0
+		d.ranged = false
+		d.restart()
+		if gErr := d.describe(resp.Header, resp.ContentLength); gErr != nil {
+			return pos, gErr
+		}
+	}
+
+	parts, err := digestChecks(resp.Header, "Content-Digest")
+	if err != nil {
+		return pos, glitch.NewDataError(err, ErrorDownload, "Could not read the digest sent by the service")
+	}
+	hashes := hashesOf(parts)
+	if d.readBack == nil {
+		hashes = append(hashes, hashesOf(d.digests)...)
+	}
+
+	out := &offsetWriter{w: d.w, off: pos, hash: io.MultiWriter(hashes...), progress: d.progress}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return out.off, err
+	}
+
+	for _, c := range parts {
+		if gErr := c.verify(); gErr != nil {
+			return out.off, gErr
+		}
+	}
+
+	return out.off, nil
+}
+
+// describe records what the first response tells about the resource, including the digests it should match
+func (d *download) describe(header http.Header, size int64) glitch.DataError {
+	d.header = header
+	d.size = size
+	d.validator = validator(header)
+	if d.tracker != nil {
+		d.tracker.setTotal(size)
+	}
+
+	d.digests = nil
+	for _, name := range []string{"Repr-Digest", "Digest"} {
+		checks, err := digestChecks(header, name)
+		if err != nil {
+			return glitch.NewDataError(err, ErrorDownload, "Could not read the digest sent by the service")
+		}
+		d.digests = append(d.digests, checks...)
+	}
+	if d.cfg.Hash != nil {
+		d.digests = append(d.digests, digestCheck{name: "checksum", h: d.cfg.Hash(), want: d.cfg.Checksum})
+	}
+
+	return nil
+}
+
+// restart forgets what was downloaded before the service sent the whole resource
+func (d *download) restart() {
+	if d.tracker != nil {
+		d.tracker.reset(-1)
+	}
+}
+
+func (d *download) progress(n int64) {
+	if d.tracker != nil {
+		d.tracker.add(n)
+	}
+}
+
+// verify checks the downloaded resource against the digests sent by the service and the checksum in the config
+func (d *download) verify(size int64) glitch.DataError {
+	checks := d.digests
+	if d.readBack != nil && len(checks) > 0 {
+		if _, err := io.Copy(io.MultiWriter(hashesOf(checks)...), io.NewSectionReader(d.readBack, 0, size)); err != nil {
+			return glitch.NewDataError(err, ErrorDownload, "Could not read back the download")
+		}
+	}
+	for _, c := range checks {
+		if gErr := c.verify(); gErr != nil {
+			return gErr
+		}
+	}
+
+	return nil
+}
+
+// offsetWriter writes sequentially to an io.WriterAt from an offset
+type offsetWriter struct {
+	w        io.WriterAt
+	off      int64
+	hash     io.Writer
+	progress func(n int64)
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.off)
+	w.off += int64(n)
+	_, _ = w.hash.Write(p[:n])
+	w.progress(int64(n))
+	if err != nil {
+		return n, glitch.NewDataError(err, ErrorDownload, "Could not write the download")
+	}
+
+	return n, nil
+}
+
+// validator returns the strong ETag, or failing that the Last-Modified date, identifying the version of a resource
+func validator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+
+	return h.Get("Last-Modified")
+}
+
+// parseContentRange reads the first byte and the size of the resource from a Content-Range header such as
+// "bytes 0-99/1000". The size is -1 when the service does not know it.
+func parseContentRange(v string) (int64, int64, error) {
+	spec := strings.TrimPrefix(v, "bytes ")
+	slash := strings.IndexByte(spec, '/')
+	dash := strings.IndexByte(spec, '-')
+	if spec == v || slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", v)
+	}
+
+	first, err := strconv.ParseInt(spec[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q: %w", v, err)
+	}
+	if spec[slash+1:] == "*" {
+		return first, -1, nil
+	}
+	size, err := strconv.ParseInt(spec[slash+1:], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q: %w", v, err)
+	}
+
+	return first, size, nil
+}
+
+// digestCheck compares a hash with the digest it should have
+type digestCheck struct {
+	name string
+	h    hash.Hash
+	want []byte
+}
+
+func (c digestCheck) verify() glitch.DataError {
+	if got := c.h.Sum(nil); !bytes.Equal(got, c.want) {
+		err := fmt.Errorf("%s is %s, expected %s", c.name, base64.StdEncoding.EncodeToString(got), base64.StdEncoding.EncodeToString(c.want))
+		return glitch.NewDataError(err, ErrorChecksumMismatch, "The download does not match its checksum")
+	}
+
+	return nil
+}
+
+func hashesOf(checks []digestCheck) []io.Writer {
+	hashes := make([]io.Writer, 0, len(checks))
+	for _, c := range checks {
+		hashes = append(hashes, c.h)
+	}
+
+	return hashes
+}
+
+// digestAlgorithms are the hashes digests can be verified with
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// digestChecks reads the digests of the RFC 9530 header name, such as "sha-256=:<base64>:", or of the RFC 3230 Digest
+// header, such as "SHA-256=<base64>". Digests of unsupported algorithms are ignored.
+func digestChecks(h http.Header, name string) ([]digestCheck, error) {
+	var checks []digestCheck
+	for _, v := range h.Values(name) {
+		for _, member := range strings.Split(v, ",") {
+			kv := strings.SplitN(strings.TrimSpace(member), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			algorithm := strings.ToLower(kv[0])
+			newHash, ok := digestAlgorithms[algorithm]
+			if !ok {
+				continue
+			}
+
+			value := kv[1]
+			if name != "Digest" {
+				if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+					return nil, fmt.Errorf("invalid %s %q", name, v)
+				}
+				value = value[1 : len(value)-1]
+			}
+			want, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", name, v, err)
+			}
+
+			checks = append(checks, digestCheck{name: name + " " + algorithm, h: newHash(), want: want})
+		}
+	}
+
+	return checks, nil
+}
@@ -0,0 +1,343 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// memFile is an in memory io.WriterAt and io.ReaderAt
+type memFile struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if end := int(off) + len(p); end > len(f.b) {
+		f.b = append(f.b, make([]byte, end-len(f.b))...)
+	}
+	return copy(f.b[off:], p), nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if off >= int64(len(f.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// writerAt hides the io.ReaderAt of a memFile
+type writerAt struct {
+	f *memFile
+}
+
+func (w writerAt) WriteAt(p []byte, off int64) (int, error) {
+	return w.f.WriteAt(p, off)
+}
+
+func sha256Digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func TestUnit_Download(t *testing.T) {
+	content := strings.Repeat("0123456789", 9) + "abcde"
+	sum := sha256.Sum256([]byte(content))
+
+	serve := func(w http.ResponseWriter, r *http.Request, etag string) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Repr-Digest", sha256Digest(content))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}
+	interrupt := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "95")
+		_, _ = w.Write([]byte(content[:40]))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+
+	tests := map[string]struct {
+		handler  func(attempt int32, w http.ResponseWriter, r *http.Request)
+		cfg      DownloadConfig
+		parallel bool
+		validate func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError)
+	}{
+		"base path- downloaded in one piece": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				serve(w, r, `"v1"`)
+			},
+			cfg: DownloadConfig{Hash: sha256.New, Checksum: sum[:]},
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, int64(95), size)
+				require.Equal(t, content, string(f.b))
+				require.Len(t, requests, 1)
+				require.Empty(t, requests[0].Get("Range"))
+				require.Equal(t, "identity", requests[0].Get("Accept-Encoding"))
+				last := progress[len(progress)-1]
+				require.Equal(t, int64(95), last.Transferred)
+				require.Equal(t, int64(95), last.Total)
+			},
+		},
+		"base path- resumed after an interruption": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				if attempt == 1 {
+					interrupt(w, r)
+				}
+				serve(w, r, `"v1"`)
+			},
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, content, string(f.b))
+				require.Len(t, requests, 2)
+				require.Equal(t, "bytes=40-", requests[1].Get("Range"))
+				require.Equal(t, `"v1"`, requests[1].Get("If-Range"))
+				last := progress[len(progress)-1]
+				require.Equal(t, int64(95), last.Transferred)
+				require.Equal(t, int64(95), last.Total)
+			},
+		},
+		"base path- restarted when the resource changed": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				if attempt == 1 {
+					interrupt(w, r)
+				}
+				serve(w, r, `"v2"`)
+			},
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, content, string(f.b))
+				require.Len(t, requests, 2)
+				last := progress[len(progress)-1]
+				require.Equal(t, int64(95), last.Transferred)
+				require.Equal(t, int64(95), last.Total)
+			},
+		},
+		"base path- downloaded in parallel chunks": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				serve(w, r, `"v1"`)
+			},
+			cfg:      DownloadConfig{Concurrency: 3, ChunkSize: 10, Hash: sha256.New, Checksum: sum[:]},
+			parallel: true,
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, int64(95), size)
+				require.Equal(t, content, string(f.b))
+				require.Len(t, requests, 10)
+				require.Equal(t, "bytes=0-9", requests[0].Get("Range"))
+				for _, h := range requests[1:] {
+					require.Equal(t, `"v1"`, h.Get("If-Range"))
+				}
+				last := progress[len(progress)-1]
+				require.Equal(t, int64(95), last.Transferred)
+				require.Equal(t, int64(95), last.Total)
+			},
+		},
+		"base path- parallel download of an empty resource": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(""))
+			},
+			cfg:      DownloadConfig{Concurrency: 3, ChunkSize: 10},
+			parallel: true,
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, int64(0), size)
+			},
+		},
+		"base path- parallel download without range support": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(content))
+			},
+			cfg:      DownloadConfig{Concurrency: 3, ChunkSize: 10},
+			parallel: true,
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, content, string(f.b))
+				require.Len(t, requests, 1)
+			},
+		},
+		"exceptional path- checksum mismatch": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				serve(w, r, `"v1"`)
+			},
+			cfg: DownloadConfig{Hash: sha256.New, Checksum: []byte("wrong")},
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorChecksumMismatch, err.Code())
+			},
+		},
+		"exceptional path- hash without a checksum": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				serve(w, r, `"v1"`)
+			},
+			cfg: DownloadConfig{Hash: sha256.New},
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorDownload, err.Code())
+				require.Empty(t, requests)
+			},
+		},
+		"exceptional path- checksum without a hash": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				serve(w, r, `"v1"`)
+			},
+			cfg: DownloadConfig{Checksum: []byte("sum")},
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorDownload, err.Code())
+				require.Empty(t, requests)
+			},
+		},
+		"exceptional path- repr digest mismatch in parallel": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Repr-Digest", sha256Digest("other"))
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+			},
+			cfg:      DownloadConfig{Concurrency: 2, ChunkSize: 50},
+			parallel: true,
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorChecksumMismatch, err.Code())
+			},
+		},
+		"exceptional path- content digest mismatch": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Digest", sha256Digest("other")+", md5=:AAAA:")
+				_, _ = w.Write([]byte(content))
+			},
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorChecksumMismatch, err.Code())
+			},
+		},
+		"exceptional path- resumes exhausted": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				interrupt(w, r)
+			},
+			cfg: DownloadConfig{Resumes: 2},
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorDownload, err.Code())
+				require.Len(t, requests, 3)
+			},
+		},
+		"exceptional path- error response": {
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"code":"NOT_FOUND"}`))
+			},
+			validate: func(t *testing.T, f *memFile, size int64, requests []http.Header, progress []Progress, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, "NOT_FOUND", err.Code())
+				require.Len(t, requests, 1)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var attempts int32
+			var mu sync.Mutex
+			var requests []http.Header
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.Header.Clone())
+				mu.Unlock()
+				tc.handler(atomic.AddInt32(&attempts, 1), w, r)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil)
+
+			var progress []Progress
+			cfg := tc.cfg
+			cfg.Slug = "/artifact"
+			cfg.ResumeDelay = time.Millisecond
+			cfg.Progress = func(p Progress) {
+				progress = append(progress, p)
+			}
+
+			f := &memFile{}
+			var w io.WriterAt = writerAt{f: f}
+			if tc.parallel {
+				w = f
+			}
+			size, err := Download(context.Background(), bc.(Streamer), cfg, w)
+
+			mu.Lock()
+			defer mu.Unlock()
+			tc.validate(t, f, size, requests, progress, err)
+		})
+	}
+}
+
+func TestUnit_DownloadFile(t *testing.T) {
+	content := strings.Repeat("x", 100)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer testServer.Close()
+
+	finder := func(serviceName string, useTLS bool) (url.URL, error) {
+		u, err := url.Parse(testServer.URL)
+		return *u, err
+	}
+	bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil)
+
+	dir, err := ioutil.TempDir("", "download")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "artifact")
+	require.NoError(t, ioutil.WriteFile(path, bytes.Repeat([]byte("stale"), 100), 0o600))
+
+	size, gErr := DownloadFile(context.Background(), bc.(Streamer), DownloadConfig{Slug: "/artifact", Concurrency: 4, ChunkSize: 30}, path)
+	require.Nil(t, gErr)
+	require.Equal(t, int64(100), size)
+	by, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, string(by))
+
+	_, gErr = DownloadFile(context.Background(), bc.(Streamer), DownloadConfig{Slug: "/missing"}, path)
+	require.NotNil(t, gErr)
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// FallbackFunc fills in response for a call that failed with cause. Returning an error fails the call with that
+// error instead.
+type FallbackFunc func(ctx context.Context, cause glitch.DataError, response interface{}) glitch.DataError
+
+// Fallback describes how Do degrades when a call fails. The last known good response is preferred when enabled and
+// available; otherwise Func is used.
+type Fallback struct {
+	// Codes limits the fallback to errors with one of these codes; empty applies it to every error
+	Codes []string
+	// UseLastKnownGood serves the most recent successful response to the same method, slug, and query. Only GET and
+	// HEAD calls that pass no credentials of their own, such as an Authorization header, are remembered and served.
+	UseLastKnownGood bool
+	// Func produces a response when no last known good response is served
+	Func FallbackFunc
+}
+
+type routeFallback struct {
+	template string
+	fallback Fallback
+}
+
+// WithRouteFallback makes Do fall back for calls to slugs matching template, such as /v1/users/{id}, instead of
+// returning an error. When several templates match a slug the first one registered is used. Callers can tell a
+// fallback was used through ContextWithResponseMetadata.
+func WithRouteFallback(template string, fb Fallback) Option {
+	return func(c *client) {
+		c.fallbacks = append(c.fallbacks, routeFallback{template: template, fallback: fb})
+	}
+}
+
+// fallbackFor returns the fallback for a call to slug made with ctx, if any
+func (c *client) fallbackFor(ctx context.Context, slug string) *Fallback {
+	if o := callOptionsFrom(ctx); o != nil && o.fallback != nil {
+		return o.fallback
+	}
+
+	for i := range c.fallbacks {
+		if matchRoute(c.fallbacks[i].template, slug) {
+			return &c.fallbacks[i].fallback
+		}
+	}
+
+	return nil
+}
+
+// applies reports whether the fallback handles err
+func (fb *Fallback) applies(err glitch.DataError) bool {
+	if len(fb.Codes) == 0 {
+		return true
+	}
+
+	for _, code := range fb.Codes {
+		if code == err.Code() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lastKnownGoodKey returns the key the last known good response of a call is stored under, or an empty key for calls
+// whose responses must not be handed to other callers: those using unsafe methods, passing credentials of their own, or
+// made on behalf of an incoming request
+func (c *client) lastKnownGoodKey(ctx context.Context, fb *Fallback, method string, slug string, query url.Values, headers http.Header) string {
+	if !fb.UseLastKnownGood || (method != http.MethodGet && method != http.MethodHead) || c.propagates(ctx) {
+		return ""
+	}
+	if c.callerCredentials(c.requestHeaders(ctx, callOptionsFrom(ctx).applyHeaders(headers))) {
+		return ""
+	}
+
+	return fallbackKey(method, slug, query)
+}
+
+// remember stores a successful response as the last known good response for key
+func (c *client) remember(key string, status int, body []byte) {
+	if key != "" {
+		c.lastKnownGood.Set(key, &CacheEntry{StatusCode: status, Body: body, ResponseTime: time.Now()})
+	}
+}
+
+// fallback fills in response after a call failed with cause, from the last known good response for key if there is one
+func (c *client) fallback(ctx context.Context, fb *Fallback, key string, cause glitch.DataError, response interface{}) glitch.DataError {
+	if key != "" {
+		if entry, ok := c.lastKnownGood.Get(key); ok {
+			if err := decodeResponse(entry.StatusCode, entry.Body, response); err != nil {
+				return err
+			}
+			markFallback(ctx, cause)
+			return nil
+		}
+	}
+
+	if fb.Func != nil {
+		if err := fb.Func(ctx, cause, response); err != nil {
+			return err
+		}
+		markFallback(ctx, cause)
+		return nil
+	}
+
+	return cause
+}
+
+func markFallback(ctx context.Context, cause glitch.DataError) {
+	if md := responseMetadataFrom(ctx); md != nil {
+		md.FallbackUsed = true
+		md.FallbackCause = cause
+	}
+}
+
+func fallbackKey(method string, slug string, query url.Values) string {
+	return method + " " + slug + "?" + query.Encode()
+}
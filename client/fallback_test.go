@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+func TestUnit_WithRouteFallback(t *testing.T) {
+	failing := func(calls *int32) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(calls, 1) > 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = fmt.Fprintf(w, `{"code":"UNAVAILABLE"}`)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"name":"first"}`)
+		}
+	}
+
+	tests := map[string]struct {
+		template string
+		fallback Fallback
+		opts     []Option
+		validate func(t *testing.T, bc BaseClient)
+	}{
+		"base path- last known good response served": {
+			template: "/v1/users/{id}",
+			fallback: Fallback{UseLastKnownGood: true},
+			validate: func(t *testing.T, bc BaseClient) {
+				resp := map[string]string{}
+				require.NoError(t, bc.Do(context.Background(), "GET", "/v1/users/1", nil, nil, nil, &resp))
+
+				ctx, md := ContextWithResponseMetadata(context.Background())
+				resp = map[string]string{}
+				require.NoError(t, bc.Do(ctx, "GET", "/v1/users/1", nil, nil, nil, &resp))
+				require.Equal(t, "first", resp["name"])
+				require.True(t, md.FallbackUsed)
+				require.Equal(t, "UNAVAILABLE", md.FallbackCause.Code())
+				require.Equal(t, http.StatusServiceUnavailable, md.StatusCode)
+			},
+		},
+		"base path- fallback function used without a last known good response": {
+			template: "/v1/users/{id}",
+			fallback: Fallback{
+				UseLastKnownGood: true,
+				Func: func(ctx context.Context, cause glitch.DataError, response interface{}) glitch.DataError {
+					(*response.(*map[string]string))["name"] = "default"
+					return nil
+				},
+			},
+			validate: func(t *testing.T, bc BaseClient) {
+				resp := map[string]string{}
+				require.NoError(t, bc.Do(context.Background(), "GET", "/v1/users/1", nil, nil, nil, &resp))
+
+				ctx, md := ContextWithResponseMetadata(context.Background())
+				resp = map[string]string{}
+				require.NoError(t, bc.Do(ctx, "GET", "/v1/users/2", nil, nil, nil, &resp))
+				require.Equal(t, "default", resp["name"])
+				require.True(t, md.FallbackUsed)
+			},
+		},
+		"base path- unmatched code returns the error": {
+			template: "/v1/users/{id}",
+			fallback: Fallback{Codes: []string{"TIMEOUT"}, UseLastKnownGood: true},
+			validate: func(t *testing.T, bc BaseClient) {
+				require.NoError(t, bc.Do(context.Background(), "GET", "/v1/users/1", nil, nil, nil, nil))
+
+				ctx, md := ContextWithResponseMetadata(context.Background())
+				err := bc.Do(ctx, "GET", "/v1/users/1", nil, nil, nil, nil)
+				require.Error(t, err)
+				require.Equal(t, "UNAVAILABLE", err.Code())
+				require.False(t, md.FallbackUsed)
+			},
+		},
+		"base path- unmatched route returns the error": {
+			template: "/v1/groups/{id}",
+			fallback: Fallback{UseLastKnownGood: true},
+			validate: func(t *testing.T, bc BaseClient) {
+				require.NoError(t, bc.Do(context.Background(), "GET", "/v1/users/1", nil, nil, nil, nil))
+				err := bc.Do(context.Background(), "GET", "/v1/users/1", nil, nil, nil, nil)
+				require.Error(t, err)
+			},
+		},
+		"exceptional path- unsafe methods not remembered": {
+			template: "/v1/users/{id}",
+			fallback: Fallback{UseLastKnownGood: true},
+			validate: func(t *testing.T, bc BaseClient) {
+				require.NoError(t, bc.Do(context.Background(), "POST", "/v1/users/1", nil, nil, nil, nil))
+				err := bc.Do(context.Background(), "POST", "/v1/users/1", nil, nil, nil, nil)
+				require.Error(t, err)
+				require.Equal(t, "UNAVAILABLE", err.Code())
+			},
+		},
+		"exceptional path- responses to callers' own credentials not served to others": {
+			template: "/v1/users/{id}",
+			fallback: Fallback{UseLastKnownGood: true},
+			validate: func(t *testing.T, bc BaseClient) {
+				alice := http.Header{"Authorization": []string{"Bearer alice"}}
+				require.NoError(t, bc.Do(context.Background(), "GET", "/v1/users/1", nil, alice, nil, nil))
+
+				bob := http.Header{"Authorization": []string{"Bearer bob"}}
+				resp := map[string]string{}
+				err := bc.Do(context.Background(), "GET", "/v1/users/1", nil, bob, nil, &resp)
+				require.Error(t, err)
+				require.Equal(t, "UNAVAILABLE", err.Code())
+				require.Empty(t, resp)
+			},
+		},
+		"exceptional path- responses to calls made on behalf of others not served to anyone": {
+			template: "/v1/users/{id}",
+			fallback: Fallback{UseLastKnownGood: true},
+			opts:     []Option{WithPropagation(PropagationConfig{ForwardCredential: true})},
+			validate: func(t *testing.T, bc BaseClient) {
+				alice := ContextWithInbound(context.Background(), Inbound{Credential: "Bearer alice"})
+				require.NoError(t, bc.Do(alice, "GET", "/v1/users/1", nil, nil, nil, nil))
+
+				bob := ContextWithInbound(context.Background(), Inbound{Credential: "Bearer bob"})
+				resp := map[string]string{}
+				err := bc.Do(bob, "GET", "/v1/users/1", nil, nil, nil, &resp)
+				require.Error(t, err)
+				require.Equal(t, "UNAVAILABLE", err.Code())
+				require.Empty(t, resp)
+
+				err = bc.Do(context.Background(), "GET", "/v1/users/1", nil, nil, nil, &resp)
+				require.Error(t, err)
+				require.Empty(t, resp)
+			},
+		},
+		"exceptional path- fallback function fails": {
+			template: "/v1/users/{id}",
+			fallback: Fallback{
+				Func: func(ctx context.Context, cause glitch.DataError, response interface{}) glitch.DataError {
+					return glitch.NewDataError(nil, "NO_DEFAULT", "no default available").Wrap(cause)
+				},
+			},
+			validate: func(t *testing.T, bc BaseClient) {
+				require.NoError(t, bc.Do(context.Background(), "GET", "/v1/users/1", nil, nil, nil, nil))
+				err := bc.Do(context.Background(), "GET", "/v1/users/1", nil, nil, nil, nil)
+				require.Error(t, err)
+				require.Equal(t, "NO_DEFAULT", err.Code())
+				require.Equal(t, "UNAVAILABLE", err.GetCause().Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			testServer := httptest.NewServer(failing(&calls))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			opts := append([]Option{WithRouteFallback(tc.template, tc.fallback)}, tc.opts...)
+			tc.validate(t, NewBaseClient(finder, "foo", false, 10*time.Second, nil, opts...))
+		})
+	}
+}
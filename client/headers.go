@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// HeaderProvider returns headers for a call made with ctx, such as a tenant ID the caller stored in the context
+type HeaderProvider func(ctx context.Context) http.Header
+
+type acceptKey struct{}
+
+// WithDefaultHeaders sends h with every request, such as a User-Agent or API version. Headers of the same name from a
+// HeaderProvider or passed to a call replace them.
+func WithDefaultHeaders(h http.Header) Option {
+	return func(c *client) {
+		if c.defaultHeaders == nil {
+			c.defaultHeaders = http.Header{}
+		}
+		setHeaders(c.defaultHeaders, h)
+	}
+}
+
+// WithHeaderProvider adds the headers p returns to each call. Providers run in the order they were added, replacing
+// default headers and those of earlier providers with the same name; headers passed to a call replace theirs.
+func WithHeaderProvider(p HeaderProvider) Option {
+	return func(c *client) {
+		c.headerProviders = append(c.headerProviders, p)
+	}
+}
+
+// requestHeaders merges the default headers, those of the header providers, and the headers of a call made with ctx,
+// later ones replacing earlier ones of the same name. A call header with no values removes the header. Accept is
+// filled in when the call decodes the response and no header set it. The caller's headers are never modified.
+func (c *client) requestHeaders(ctx context.Context, headers http.Header) http.Header {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	accept, _ := ctx.Value(acceptKey{}).(string)
+	if len(c.defaultHeaders) == 0 && len(c.headerProviders) == 0 && (accept == "" || headers.Get("Accept") != "") {
+		return headers
+	}
+
+	h := http.Header{}
+	setHeaders(h, c.defaultHeaders)
+	for _, p := range c.headerProviders {
+		setHeaders(h, p(ctx))
+	}
+	setHeaders(h, headers)
+	if accept != "" && h.Get("Accept") == "" {
+		h.Set("Accept", accept)
+	}
+
+	return h
+}
+
+// setHeaders copies the headers of src into dst, replacing any of the same name
+func setHeaders(dst http.Header, src http.Header) {
+	for k, v := range src {
+		k = http.CanonicalHeaderKey(k)
+		if len(v) == 0 {
+			delete(dst, k)
+			continue
+		}
+		dst[k] = append([]string(nil), v...)
+	}
+}
+
+// contextWithAccept returns a context whose calls ask for mimeType unless their headers say otherwise
+func contextWithAccept(ctx context.Context, mimeType string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return context.WithValue(ctx, acceptKey{}, mimeType)
+}
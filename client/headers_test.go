@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type tenantKey struct{}
+
+func TestUnit_WithDefaultHeaders(t *testing.T) {
+	tenantProvider := func(ctx context.Context) http.Header {
+		if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+			return http.Header{"X-Tenant": []string{tenant}, "X-Api-Version": []string{"3"}}
+		}
+		return nil
+	}
+
+	tests := map[string]struct {
+		opts     []Option
+		ctx      context.Context
+		headers  http.Header
+		body     func(t *testing.T) io.Reader
+		useDo    bool
+		validate func(t *testing.T, h http.Header)
+	}{
+		"base path- defaults sent": {
+			opts: []Option{WithDefaultHeaders(http.Header{"User-Agent": []string{"orders/1.0"}, "x-api-version": []string{"2"}})},
+			validate: func(t *testing.T, h http.Header) {
+				require.Equal(t, "orders/1.0", h.Get("User-Agent"))
+				require.Equal(t, "2", h.Get("X-Api-Version"))
+				require.Empty(t, h.Get("Accept"))
+			},
+		},
+		"base path- providers replace defaults": {
+			opts: []Option{
+				WithDefaultHeaders(http.Header{"X-Api-Version": []string{"2"}}),
+				WithHeaderProvider(tenantProvider),
+			},
+			ctx: context.WithValue(context.Background(), tenantKey{}, "acme"),
+			validate: func(t *testing.T, h http.Header) {
+				require.Equal(t, "acme", h.Get("X-Tenant"))
+				require.Equal(t, []string{"3"}, h.Values("X-Api-Version"))
+			},
+		},
+		"base path- call headers replace the rest": {
+			opts: []Option{
+				WithDefaultHeaders(http.Header{"X-Api-Version": []string{"2"}, "User-Agent": []string{"orders/1.0"}}),
+				WithHeaderProvider(tenantProvider),
+			},
+			ctx:     context.WithValue(context.Background(), tenantKey{}, "acme"),
+			headers: http.Header{"x-tenant": []string{"other"}, "User-Agent": nil},
+			validate: func(t *testing.T, h http.Header) {
+				require.Equal(t, []string{"other"}, h.Values("X-Tenant"))
+				require.Equal(t, "3", h.Get("X-Api-Version"))
+				require.NotEqual(t, "orders/1.0", h.Get("User-Agent"))
+			},
+		},
+		"base path- JSON body and response asked for": {
+			body: func(t *testing.T) io.Reader {
+				r, err := ObjectToJSONReader(map[string]string{"name": "value"})
+				require.Nil(t, err)
+				return r
+			},
+			useDo: true,
+			validate: func(t *testing.T, h http.Header) {
+				require.Equal(t, JSONMimeType, h.Get("Content-Type"))
+				require.Equal(t, JSONMimeType, h.Get("Accept"))
+			},
+		},
+		"base path- caller's content headers kept": {
+			opts:    []Option{WithDefaultHeaders(http.Header{"Accept": []string{"application/vnd.orders+json"}})},
+			headers: http.Header{"Content-Type": []string{"application/merge-patch+json"}},
+			body: func(t *testing.T) io.Reader {
+				r, err := ObjectToJSONReader([]byte(`{"name":"value"}`))
+				require.Nil(t, err)
+				return r
+			},
+			useDo: true,
+			validate: func(t *testing.T, h http.Header) {
+				require.Equal(t, "application/merge-patch+json", h.Get("Content-Type"))
+				require.Equal(t, "application/vnd.orders+json", h.Get("Accept"))
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			received := make(chan http.Header, 1)
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Header.Clone()
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, tc.opts...)
+
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			var body io.Reader
+			if tc.body != nil {
+				body = tc.body(t)
+			}
+			var callerHeaders http.Header
+			if tc.headers != nil {
+				callerHeaders = tc.headers.Clone()
+			}
+
+			if tc.useDo {
+				response := map[string]string{}
+				require.Nil(t, bc.Do(ctx, http.MethodPost, "/1", nil, tc.headers, body, &response))
+			} else {
+				_, _, err := bc.MakeRequest(ctx, http.MethodPost, "/1", nil, tc.headers, body)
+				require.Nil(t, err)
+			}
+
+			require.Equal(t, callerHeaders, tc.headers)
+			tc.validate(t, <-received)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	hedgeBudgetBurst     = 10
+	hedgeLatencySamples  = 100
+	hedgeMinimumSamples  = 20
+	defaultHedgeAttempts = 2
+	defaultHedgeBudget   = 0.1
+	defaultHedgeDelay    = 100 * time.Millisecond
+)
+
+// idempotentMethods lists the methods RFC 9110 defines as idempotent
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// HedgePolicy configures request hedging. Hedging only applies to idempotent methods.
+type HedgePolicy struct {
+	// Delay is how long to wait for a response before sending another attempt; defaults to 100ms
+	Delay time.Duration
+	// Percentile, when set, replaces Delay with this percentile (0-100) of recently observed latencies once enough
+	// calls have been made to learn it
+	Percentile float64
+	// MaxAttempts is the total number of attempts, including the original, that may be in flight; defaults to 2
+	MaxAttempts int
+	// BudgetRatio caps hedged attempts to this fraction of requests; defaults to 0.1
+	BudgetRatio float64
+}
+
+// WithHedging sends additional attempts of slow idempotent requests, possibly to a different instance returned by the
+// service finder, and uses whichever successful response arrives first. Client errors, 429, and server errors do not end
+// the race; the last of them is returned when no attempt succeeds. Requests whose body cannot be sent by several
+// attempts at once are not hedged.
+func WithHedging(p HedgePolicy) Option {
+	return func(c *client) {
+		c.hedging = &p
+	}
+}
+
+// hedgingTransport races attempts of a request against each other
+type hedgingTransport struct {
+	next    http.RoundTripper
+	policy  HedgePolicy
+	resolve func() (url.URL, error)
+
+	mu        sync.Mutex
+	tokens    float64
+	latencies []time.Duration
+	nextIdx   int
+}
+
+type hedgeResult struct {
+	attempt int
+	resp    *http.Response
+	err     error
+	cancel  context.CancelFunc
+	latency time.Duration
+}
+
+func newHedgingTransport(next http.RoundTripper, p HedgePolicy, resolve func() (url.URL, error)) *hedgingTransport {
+	if p.MaxAttempts < 2 {
+		p.MaxAttempts = defaultHedgeAttempts
+	}
+	if p.BudgetRatio <= 0 {
+		p.BudgetRatio = defaultHedgeBudget
+	}
+	if p.Delay <= 0 {
+		p.Delay = defaultHedgeDelay
+	}
+
+	return &hedgingTransport{next: next, policy: p, resolve: resolve, tokens: hedgeBudgetBurst}
+}
+
+func (t *hedgingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !idempotentMethods[req.Method] || !concurrentBody(req) || isStream(req.Context()) {
+		return t.next.RoundTrip(req)
+	}
+	t.deposit()
+
+	results := make(chan hedgeResult, t.policy.MaxAttempts)
+	var cancels []context.CancelFunc
+	attempt := func(r *http.Request, cancel context.CancelFunc) {
+		res := hedgeResult{attempt: len(cancels), cancel: cancel}
+		cancels = append(cancels, cancel)
+		go func() {
+			start := time.Now()
+			res.resp, res.err = t.next.RoundTrip(r)
+			res.latency = time.Since(start)
+			results <- res
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	attempt(req.WithContext(ctx), cancel)
+	launched, pending := 1, 1
+
+	timer := time.NewTimer(t.delay())
+	defer timer.Stop()
+
+	var last *hedgeResult
+	for {
+		select {
+		case <-timer.C:
+			if launched >= t.policy.MaxAttempts || !t.withdraw() {
+				continue
+			}
+			ctx, cancel := context.WithCancel(req.Context())
+			r, err := t.hedgeRequest(ctx, req)
+			if err != nil {
+				cancel()
+				continue
+			}
+			attempt(r, cancel)
+			launched++
+			pending++
+			if launched < t.policy.MaxAttempts {
+				timer.Reset(t.delay())
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil && res.resp.StatusCode < http.StatusBadRequest {
+				t.record(res.latency)
+				if last != nil {
+					discardResult(*last)
+				}
+				return t.finish(res, cancels, pending, results), nil
+			}
+
+			if last != nil {
+				discardResult(*last)
+			}
+			last = &res
+			if pending == 0 {
+				if last.err != nil {
+					for _, cancel := range cancels {
+						cancel()
+					}
+					return nil, last.err
+				}
+				return t.finish(*last, cancels, pending, results), nil
+			}
+		}
+	}
+}
+
+// finish cancels the losing attempts, cleans up any still in flight, and keeps the winner's context alive until its
+// body is closed
+func (t *hedgingTransport) finish(winner hedgeResult, cancels []context.CancelFunc, pending int, results chan hedgeResult) *http.Response {
+	for i, cancel := range cancels {
+		if i != winner.attempt {
+			cancel()
+		}
+	}
+
+	go func() {
+		for i := 0; i < pending; i++ {
+			discardResult(<-results)
+		}
+	}()
+
+	winner.resp.Body = &releasingBody{ReadCloser: winner.resp.Body, release: winner.cancel}
+	return winner.resp
+}
+
+// hedgeRequest copies req for another attempt, sending it to the instance the finder currently returns
+func (t *hedgingTransport) hedgeRequest(ctx context.Context, req *http.Request) (*http.Request, error) {
+	r := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	// A call sent to a base URL of its own is hedged against the same host
+	if o := callOptionsFrom(ctx); t.resolve != nil && (o == nil || o.baseURL == nil) {
+		if u, err := t.resolve(); err == nil && u.Host != "" {
+			r.URL.Scheme = u.Scheme
+			r.URL.Host = u.Host
+			r.Host = ""
+		}
+	}
+
+	return r, nil
+}
+
+// delay returns how long to wait before the next hedged attempt
+func (t *hedgingTransport) delay() time.Duration {
+	if t.policy.Percentile <= 0 {
+		return t.policy.Delay
+	}
+
+	t.mu.Lock()
+	samples := make([]time.Duration, len(t.latencies))
+	copy(samples, t.latencies)
+	t.mu.Unlock()
+
+	if len(samples) < hedgeMinimumSamples {
+		return t.policy.Delay
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(float64(len(samples)-1) * t.policy.Percentile / 100)
+	if idx >= len(samples) {
+		idx = len(samples) - 1
+	}
+
+	return samples[idx]
+}
+
+func (t *hedgingTransport) record(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.latencies) < hedgeLatencySamples {
+		t.latencies = append(t.latencies, latency)
+		return
+	}
+	t.latencies[t.nextIdx] = latency
+	t.nextIdx = (t.nextIdx + 1) % hedgeLatencySamples
+}
+
+func (t *hedgingTransport) deposit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tokens += t.policy.BudgetRatio
+	if t.tokens > hedgeBudgetBurst {
+		t.tokens = hedgeBudgetBurst
+	}
+}
+
+func (t *hedgingTransport) withdraw() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+func discardResult(res hedgeResult) {
+	if res.resp != nil {
+		res.resp.Body.Close()
+	}
+	res.cancel()
+}
@@ -0,0 +1,231 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+func TestUnit_WithHedging(t *testing.T) {
+	tests := map[string]struct {
+		method         string
+		body           func() io.Reader
+		policy         HedgePolicy
+		requestHandler func(calls *int32) http.HandlerFunc
+		validate       func(t *testing.T, status int, body []byte, calls int32, elapsed time.Duration, err glitch.DataError)
+	}{
+		"base path- slow first attempt is hedged": {
+			method: "GET",
+			policy: HedgePolicy{Delay: 10 * time.Millisecond},
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if atomic.AddInt32(calls, 1) == 1 {
+						select {
+						case <-r.Context().Done():
+						case <-time.After(2 * time.Second):
+						}
+						return
+					}
+					_, _ = fmt.Fprintf(w, `{"foo":"hedged"}`)
+				}
+			},
+			validate: func(t *testing.T, status int, body []byte, calls int32, elapsed time.Duration, err glitch.DataError) {
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, status)
+				require.Equal(t, `{"foo":"hedged"}`, string(body))
+				require.Equal(t, int32(2), calls)
+				require.Less(t, int64(elapsed), int64(time.Second))
+			},
+		},
+		"base path- fast response is not hedged": {
+			method: "GET",
+			policy: HedgePolicy{Delay: time.Second},
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(calls, 1)
+					_, _ = fmt.Fprintf(w, `{"foo":"bar"}`)
+				}
+			},
+			validate: func(t *testing.T, status int, body []byte, calls int32, elapsed time.Duration, err glitch.DataError) {
+				require.NoError(t, err)
+				require.Equal(t, `{"foo":"bar"}`, string(body))
+				require.Equal(t, int32(1), calls)
+			},
+		},
+		"base path- replayable body sent with the hedge": {
+			method: "PUT",
+			body:   func() io.Reader { return bytes.NewBufferString(`{"test":true}`) },
+			policy: HedgePolicy{Delay: 10 * time.Millisecond},
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					buf := new(bytes.Buffer)
+					_, _ = buf.ReadFrom(r.Body)
+					if atomic.AddInt32(calls, 1) == 1 {
+						select {
+						case <-r.Context().Done():
+						case <-time.After(2 * time.Second):
+						}
+						return
+					}
+					_, _ = w.Write(buf.Bytes())
+				}
+			},
+			validate: func(t *testing.T, status int, body []byte, calls int32, elapsed time.Duration, err glitch.DataError) {
+				require.NoError(t, err)
+				require.Equal(t, `{"test":true}`, string(body))
+				require.Equal(t, int32(2), calls)
+			},
+		},
+		"base path- client errors do not end the race": {
+			method: "GET",
+			policy: HedgePolicy{Delay: 10 * time.Millisecond},
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if atomic.AddInt32(calls, 1) == 1 {
+						time.Sleep(100 * time.Millisecond)
+						_, _ = fmt.Fprintf(w, `{"foo":"bar"}`)
+						return
+					}
+					w.WriteHeader(http.StatusTooManyRequests)
+				}
+			},
+			validate: func(t *testing.T, status int, body []byte, calls int32, elapsed time.Duration, err glitch.DataError) {
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, status)
+				require.Equal(t, `{"foo":"bar"}`, string(body))
+				require.Equal(t, int32(2), calls)
+			},
+		},
+		"base path- body that cannot be sent twice at once is not hedged": {
+			method: "PUT",
+			body: func() io.Reader {
+				return NewStreamingBody("application/json", func(w io.Writer) error {
+					_, err := io.WriteString(w, `{"test":true}`)
+					return err
+				})
+			},
+			policy: HedgePolicy{Delay: time.Millisecond},
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(calls, 1)
+					time.Sleep(50 * time.Millisecond)
+					_, _ = io.Copy(w, r.Body)
+				}
+			},
+			validate: func(t *testing.T, status int, body []byte, calls int32, elapsed time.Duration, err glitch.DataError) {
+				require.NoError(t, err)
+				require.Equal(t, `{"test":true}`, string(body))
+				require.Equal(t, int32(1), calls)
+			},
+		},
+		"base path- non-idempotent methods are not hedged": {
+			method: "POST",
+			policy: HedgePolicy{Delay: time.Millisecond},
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(calls, 1)
+					time.Sleep(50 * time.Millisecond)
+					_, _ = fmt.Fprintf(w, `{"foo":"bar"}`)
+				}
+			},
+			validate: func(t *testing.T, status int, body []byte, calls int32, elapsed time.Duration, err glitch.DataError) {
+				require.NoError(t, err)
+				require.Equal(t, int32(1), calls)
+			},
+		},
+		"exceptional path- all attempts fail with server errors": {
+			method: "GET",
+			policy: HedgePolicy{Delay: time.Millisecond},
+			requestHandler: func(calls *int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(calls, 1)
+					time.Sleep(20 * time.Millisecond)
+					w.WriteHeader(http.StatusBadGateway)
+					_, _ = fmt.Fprintf(w, `{"code":"BAD_GATEWAY"}`)
+				}
+			},
+			validate: func(t *testing.T, status int, body []byte, calls int32, elapsed time.Duration, err glitch.DataError) {
+				require.NoError(t, err)
+				require.Equal(t, http.StatusBadGateway, status)
+				require.Equal(t, `{"code":"BAD_GATEWAY"}`, string(body))
+				require.Equal(t, int32(2), calls)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			testServer := httptest.NewServer(tc.requestHandler(&calls))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithHedging(tc.policy))
+
+			var body io.Reader
+			if tc.body != nil {
+				body = tc.body()
+			}
+
+			start := time.Now()
+			status, ret, err := bc.MakeRequest(context.Background(), tc.method, "1", nil, nil, body)
+			tc.validate(t, status, ret, atomic.LoadInt32(&calls), time.Since(start), err)
+		})
+	}
+}
+
+func TestUnit_hedgingTransport_delay(t *testing.T) {
+	tests := map[string]struct {
+		policy    HedgePolicy
+		latencies []time.Duration
+		expected  time.Duration
+	}{
+		"base path- default delay": {
+			expected: defaultHedgeDelay,
+		},
+		"base path- fixed delay": {
+			policy:   HedgePolicy{Delay: 5 * time.Millisecond},
+			expected: 5 * time.Millisecond,
+		},
+		"base path- too few samples falls back to the fixed delay": {
+			policy:    HedgePolicy{Delay: 5 * time.Millisecond, Percentile: 90},
+			latencies: []time.Duration{time.Second},
+			expected:  5 * time.Millisecond,
+		},
+		"base path- learned percentile": {
+			policy: HedgePolicy{Delay: 5 * time.Millisecond, Percentile: 90},
+			latencies: func() []time.Duration {
+				var l []time.Duration
+				for i := 1; i <= 100; i++ {
+					l = append(l, time.Duration(i)*time.Millisecond)
+				}
+				return l
+			}(),
+			expected: 90 * time.Millisecond,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ht := newHedgingTransport(http.DefaultTransport, tc.policy, nil)
+			for _, l := range tc.latencies {
+				ht.record(l)
+			}
+			require.Equal(t, tc.expected, ht.delay())
+		})
+	}
+}
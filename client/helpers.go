@@ -5,20 +5,36 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 
 	"github.com/sprak3000/go-glitch/glitch"
 )
 
-// ObjectToJSONReader will provide an io.Reader of the JSON representation of v
+// JSONMimeType is the content type of JSON bodies
+const JSONMimeType = "application/json"
+
+// ObjectToJSONReader will provide an io.Reader of the JSON representation of v. Requests sent with it carry a JSON
+// Content-Type unless their headers set one.
 func ObjectToJSONReader(v interface{}) (io.Reader, glitch.DataError) {
 	if by, ok := v.([]byte); ok {
-		return bytes.NewBuffer(by), nil
+		return &jsonBody{Buffer: bytes.NewBuffer(by)}, nil
 	}
 	by, err := json.Marshal(v)
 	if err != nil {
 		return nil, glitch.NewDataError(err, ErrorMarshallingObject, "Error marshalling object to json")
 	}
-	return bytes.NewBuffer(by), nil
+	return &jsonBody{Buffer: bytes.NewBuffer(by)}, nil
+}
+
+// jsonBody is a request body of JSON
+type jsonBody struct {
+	*bytes.Buffer
+}
+
+// ContentType is JSONMimeType
+func (*jsonBody) ContentType() string {
+	return JSONMimeType
 }
 
 // PrefixRoute ensures a prefix is applied to the routes being called
@@ -47,3 +63,22 @@ func normalizePathPart(route string) string {
 
 	return fmt.Sprintf("/%s", route)
 }
+
+// cloneHeader copies h so it can be modified without affecting the caller, returning an empty header for nil
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return http.Header{}
+	}
+
+	return h.Clone()
+}
+
+// cloneValues copies v so it can be modified without affecting the caller
+func cloneValues(v url.Values) url.Values {
+	c := url.Values{}
+	for k, vs := range v {
+		c[k] = append([]string(nil), vs...)
+	}
+
+	return c
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"io"
 	"io/ioutil"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -115,3 +116,28 @@ func TestUnit_PrefixRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestUnit_cloneHeader(t *testing.T) {
+	tests := map[string]struct {
+		input    http.Header
+		expected http.Header
+	}{
+		"base path- header copied": {
+			input:    http.Header{"X-Foo": []string{"bar"}},
+			expected: http.Header{"X-Foo": []string{"bar"}},
+		},
+		"base path- nil becomes an empty header": {
+			expected: http.Header{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := cloneHeader(tc.input)
+			require.Equal(t, tc.expected, h)
+
+			h.Set("X-Added", "1")
+			require.Empty(t, tc.input.Get("X-Added"))
+		})
+	}
+}
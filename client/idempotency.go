@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// DefaultIdempotencyKeyHeader is the header carrying idempotency keys unless configured otherwise
+const DefaultIdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyKeyFunc derives the idempotency key for a call from its context. Returning false generates a new key.
+type IdempotencyKeyFunc func(ctx context.Context) (key string, ok bool)
+
+// IdempotencyConfig configures the idempotency keys attached to requests
+type IdempotencyConfig struct {
+	// Header carries the key; defaults to Idempotency-Key
+	Header string
+	// Methods receive a key; defaults to POST and PATCH
+	Methods []string
+	// KeyFunc derives the key from the call's context; a random UUID is used when it is nil or declines
+	KeyFunc IdempotencyKeyFunc
+}
+
+// WithIdempotencyKeys attaches an idempotency key to requests using the configured methods, unless the caller already
+// supplied one in the request headers. The key is chosen once per call, so every retry of the call sends the same key,
+// which is what makes retrying those methods safe.
+func WithIdempotencyKeys(cfg IdempotencyConfig) Option {
+	return func(c *client) {
+		if cfg.Header == "" {
+			cfg.Header = DefaultIdempotencyKeyHeader
+		}
+		if cfg.Methods == nil {
+			cfg.Methods = []string{http.MethodPost, http.MethodPatch}
+		}
+		c.idempotency = &cfg
+	}
+}
+
+// idempotencyKeyHeader returns the header the client looks for idempotency keys in
+func (c *client) idempotencyKeyHeader() string {
+	if c.idempotency != nil {
+		return c.idempotency.Header
+	}
+
+	return DefaultIdempotencyKeyHeader
+}
+
+// idempotencyKey returns the key to attach to a call, or false if the call should not carry one
+func (cfg *IdempotencyConfig) idempotencyKey(ctx context.Context, method string, headers http.Header) (string, bool, error) {
+	if headers.Get(cfg.Header) != "" {
+		return "", false, nil
+	}
+
+	applies := false
+	for _, m := range cfg.Methods {
+		if m == method {
+			applies = true
+			break
+		}
+	}
+	if !applies {
+		return "", false, nil
+	}
+
+	if cfg.KeyFunc != nil && ctx != nil {
+		if key, ok := cfg.KeyFunc(ctx); ok && key != "" {
+			return key, true, nil
+		}
+	}
+
+	key, err := newUUIDv4()
+	if err != nil {
+		return "", false, err
+	}
+
+	return key, true, nil
+}
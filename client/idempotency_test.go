@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type paymentIDKey struct{}
+
+func TestUnit_WithIdempotencyKeys(t *testing.T) {
+	tests := map[string]struct {
+		cfg      IdempotencyConfig
+		ctx      context.Context
+		method   string
+		headers  http.Header
+		validate func(t *testing.T, received http.Header, headers http.Header)
+	}{
+		"base path- key generated for POST": {
+			method: "POST",
+			validate: func(t *testing.T, received http.Header, headers http.Header) {
+				require.Len(t, received.Get("Idempotency-Key"), 36)
+			},
+		},
+		"base path- key derived from the context": {
+			cfg: IdempotencyConfig{
+				KeyFunc: func(ctx context.Context) (string, bool) {
+					id, ok := ctx.Value(paymentIDKey{}).(string)
+					return "payment-" + id, ok
+				},
+			},
+			ctx:    context.WithValue(context.Background(), paymentIDKey{}, "42"),
+			method: "PATCH",
+			validate: func(t *testing.T, received http.Header, headers http.Header) {
+				require.Equal(t, "payment-42", received.Get("Idempotency-Key"))
+			},
+		},
+		"base path- custom header": {
+			cfg:    IdempotencyConfig{Header: "X-Request-Key"},
+			method: "POST",
+			validate: func(t *testing.T, received http.Header, headers http.Header) {
+				require.NotEmpty(t, received.Get("X-Request-Key"))
+				require.Empty(t, received.Get("Idempotency-Key"))
+			},
+		},
+		"base path- caller supplied key kept and caller headers untouched": {
+			method:  "POST",
+			headers: http.Header{"Idempotency-Key": []string{"mine"}},
+			validate: func(t *testing.T, received http.Header, headers http.Header) {
+				require.Equal(t, "mine", received.Get("Idempotency-Key"))
+				require.Equal(t, http.Header{"Idempotency-Key": []string{"mine"}}, headers)
+			},
+		},
+		"base path- caller headers not mutated when a key is added": {
+			method:  "POST",
+			headers: http.Header{"X-Foo": []string{"bar"}},
+			validate: func(t *testing.T, received http.Header, headers http.Header) {
+				require.NotEmpty(t, received.Get("Idempotency-Key"))
+				require.Equal(t, "bar", received.Get("X-Foo"))
+				require.Equal(t, http.Header{"X-Foo": []string{"bar"}}, headers)
+			},
+		},
+		"base path- idempotent methods do not receive a key": {
+			method: "GET",
+			validate: func(t *testing.T, received http.Header, headers http.Header) {
+				require.Empty(t, received.Get("Idempotency-Key"))
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var received http.Header
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r.Header
+				_, _ = fmt.Fprintf(w, `{}`)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithIdempotencyKeys(tc.cfg))
+
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			_, _, err := bc.MakeRequest(ctx, tc.method, "1", nil, tc.headers, nil)
+			require.NoError(t, err)
+			tc.validate(t, received, tc.headers)
+		})
+	}
+}
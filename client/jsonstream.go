@@ -0,0 +1,235 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// JSONStreamFormat is the layout of the records in a streamed JSON response
+type JSONStreamFormat int
+
+const (
+	// JSONStreamDetect reads a top level array when the body starts with '[' and newline delimited JSON otherwise
+	JSONStreamDetect JSONStreamFormat = iota
+	// JSONStreamNDJSON reads one record per line
+	JSONStreamNDJSON
+	// JSONStreamArray reads the elements of a top level array
+	JSONStreamArray
+)
+
+// JSONStreamConfig describes a request whose response is a stream of JSON records
+type JSONStreamConfig struct {
+	// Method, Slug, Query, Headers, and Body describe the request. Method defaults to GET.
+	Method  string
+	Slug    string
+	Query   url.Values
+	Headers http.Header
+	Body    io.Reader
+	// Format is the layout of the records; it is detected from the body by default
+	Format JSONStreamFormat
+}
+
+// JSONStream iterates over the records of a newline delimited JSON response or of a response holding a JSON array,
+// reading one record at a time from the body as it arrives. No more than one record is held in memory.
+//
+// A newline delimited record that is not valid JSON only fails its own Decode; iteration carries on with the next
+// line. A malformed array cannot be read past, so it stops the iteration and is reported by Err.
+type JSONStream struct {
+	ctx      context.Context
+	streamer Streamer
+	cfg      JSONStreamConfig
+
+	body   io.ReadCloser
+	reader *bufio.Reader
+	dec    *json.Decoder
+	record json.RawMessage
+	opened bool
+	done   bool
+	err    glitch.DataError
+}
+
+// NewJSONStream creates a JSONStream for the request described by cfg, made through s. No request is made until
+// Next is called.
+func NewJSONStream(ctx context.Context, s Streamer, cfg JSONStreamConfig) *JSONStream {
+	if cfg.Method == "" {
+		cfg.Method = http.MethodGet
+	}
+
+	return &JSONStream{ctx: ctx, streamer: s, cfg: cfg}
+}
+
+// Next reads the next record, making the request on the first call. It returns false once the stream ends or an error
+// occurred; the body of the response is closed at that point.
+func (js *JSONStream) Next() bool {
+	if js.done {
+		return false
+	}
+	if !js.opened {
+		js.opened = true
+		if err := js.open(); err != nil {
+			return js.fail(err)
+		}
+	}
+
+	var err error
+	if js.dec != nil {
+		err = js.nextElement()
+	} else {
+		err = js.nextLine()
+	}
+
+	switch {
+	case err == io.EOF:
+		js.Close()
+		return false
+	case err != nil:
+		return js.fail(js.readError(err))
+	}
+
+	return true
+}
+
+// Decode unmarshals the current record into v
+func (js *JSONStream) Decode(v interface{}) glitch.DataError {
+	if err := json.Unmarshal(js.record, v); err != nil {
+		return glitch.NewDataError(err, ErrorDecodingResponse, "Could not decode record")
+	}
+
+	return nil
+}
+
+// Raw returns the current record as it was received
+func (js *JSONStream) Raw() json.RawMessage {
+	return js.record
+}
+
+// Err returns the error that stopped the iteration, if any
+func (js *JSONStream) Err() glitch.DataError {
+	return js.err
+}
+
+// Close ends the iteration and closes the body of the response. It only needs to be called when the iteration is
+// abandoned before Next returns false.
+func (js *JSONStream) Close() {
+	js.done = true
+	js.record = nil
+	if js.body != nil {
+		js.body.Close()
+		js.body = nil
+	}
+}
+
+func (js *JSONStream) open() glitch.DataError {
+	resp, err := js.streamer.Stream(js.ctx, js.cfg.Method, js.cfg.Slug, js.cfg.Query, js.cfg.Headers, js.cfg.Body)
+	if err != nil {
+		return err
+	}
+	js.body = resp.Body
+	js.reader = bufio.NewReader(resp.Body)
+
+	format := js.cfg.Format
+	if format == JSONStreamDetect {
+		format = JSONStreamNDJSON
+		if b, err := js.peek(); err == nil && b == '[' {
+			format = JSONStreamArray
+		} else if err != nil && err != io.EOF {
+			return js.readError(err)
+		}
+	}
+	if format != JSONStreamArray {
+		return nil
+	}
+
+	js.dec = json.NewDecoder(js.reader)
+	tok, tErr := js.dec.Token()
+	if tErr == io.EOF {
+		return nil
+	}
+	if tErr != nil {
+		return js.readError(tErr)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return glitch.NewDataError(fmt.Errorf("expected an array, found %v", tok), ErrorDecodingResponse, "Could not read records")
+	}
+
+	return nil
+}
+
+// peek returns the first byte of the body that is not whitespace, without consuming it
+func (js *JSONStream) peek() (byte, error) {
+	for {
+		b, err := js.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !isJSONSpace(b) {
+			return b, js.reader.UnreadByte()
+		}
+	}
+}
+
+// nextLine reads the next non-blank line of a newline delimited body
+func (js *JSONStream) nextLine() error {
+	for {
+		line, err := js.reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			js.record = line
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// nextElement reads the next element of an array body
+func (js *JSONStream) nextElement() error {
+	if !js.dec.More() {
+		if _, err := js.dec.Token(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+
+	var record json.RawMessage
+	if err := js.dec.Decode(&record); err != nil {
+		return err
+	}
+	js.record = record
+
+	return nil
+}
+
+// readError reports a failure reading the body, favouring the context when it is done
+func (js *JSONStream) readError(err error) glitch.DataError {
+	if ctxErr := js.ctx.Err(); ctxErr != nil {
+		return requestError(ctxErr)
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || err == io.ErrUnexpectedEOF {
+		return glitch.NewDataError(err, ErrorDecodingResponse, "Could not read records")
+	}
+
+	return glitch.NewDataError(err, ErrorDecodingResponse, "Could not read response body")
+}
+
+func (js *JSONStream) fail(err glitch.DataError) bool {
+	js.Close()
+	js.err = err
+	return false
+}
+
+func isJSONSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
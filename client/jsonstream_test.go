@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+func TestUnit_JSONStream(t *testing.T) {
+	type record struct {
+		ID int `json:"id"`
+	}
+
+	tests := map[string]struct {
+		status      int
+		body        string
+		format      JSONStreamFormat
+		expectedIDs []int
+		validate    func(t *testing.T, decodeErrs []glitch.DataError, err glitch.DataError)
+	}{
+		"base path- newline delimited records": {
+			status:      http.StatusOK,
+			body:        "{\"id\":1}\n\n{\"id\":2}\r\n{\"id\":3}",
+			expectedIDs: []int{1, 2, 3},
+			validate: func(t *testing.T, decodeErrs []glitch.DataError, err glitch.DataError) {
+				require.Empty(t, decodeErrs)
+				require.Nil(t, err)
+			},
+		},
+		"base path- array elements": {
+			status:      http.StatusOK,
+			body:        "\n [{\"id\":1},\n{\"id\":2}]",
+			expectedIDs: []int{1, 2},
+			validate: func(t *testing.T, decodeErrs []glitch.DataError, err glitch.DataError) {
+				require.Empty(t, decodeErrs)
+				require.Nil(t, err)
+			},
+		},
+		"base path- newline delimited arrays with an explicit format": {
+			status:      http.StatusOK,
+			body:        "[1]\n[2]\n",
+			format:      JSONStreamNDJSON,
+			expectedIDs: []int{0, 0},
+			validate: func(t *testing.T, decodeErrs []glitch.DataError, err glitch.DataError) {
+				require.Len(t, decodeErrs, 2)
+				require.Nil(t, err)
+			},
+		},
+		"base path- empty array": {
+			status: http.StatusOK,
+			body:   " []",
+			validate: func(t *testing.T, decodeErrs []glitch.DataError, err glitch.DataError) {
+				require.Nil(t, err)
+			},
+		},
+		"exceptional path- bad record does not stop the stream": {
+			status:      http.StatusOK,
+			body:        "{\"id\":1}\n{\"id\":\n{\"id\":\"three\"}\n{\"id\":4}\n",
+			expectedIDs: []int{1, 0, 0, 4},
+			validate: func(t *testing.T, decodeErrs []glitch.DataError, err glitch.DataError) {
+				require.Len(t, decodeErrs, 2)
+				for _, dErr := range decodeErrs {
+					require.Equal(t, ErrorDecodingResponse, dErr.Code())
+				}
+				require.Nil(t, err)
+			},
+		},
+		"exceptional path- malformed array": {
+			status:      http.StatusOK,
+			body:        `[{"id":1},{"id":}]`,
+			expectedIDs: []int{1},
+			validate: func(t *testing.T, decodeErrs []glitch.DataError, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorDecodingResponse, err.Code())
+			},
+		},
+		"exceptional path- truncated array": {
+			status:      http.StatusOK,
+			body:        `[{"id":1}`,
+			expectedIDs: []int{1},
+			validate: func(t *testing.T, decodeErrs []glitch.DataError, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorDecodingResponse, err.Code())
+			},
+		},
+		"exceptional path- array expected": {
+			status: http.StatusOK,
+			body:   `{"id":1}`,
+			format: JSONStreamArray,
+			validate: func(t *testing.T, decodeErrs []glitch.DataError, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorDecodingResponse, err.Code())
+			},
+		},
+		"exceptional path- error response": {
+			status: http.StatusNotFound,
+			body:   `{"code":"NOT_FOUND"}`,
+			validate: func(t *testing.T, decodeErrs []glitch.DataError, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, "NOT_FOUND", err.Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil)
+
+			js := NewJSONStream(context.Background(), bc.(Streamer), JSONStreamConfig{Slug: "/export", Format: tc.format})
+			var ids []int
+			var decodeErrs []glitch.DataError
+			for js.Next() {
+				var r record
+				if err := js.Decode(&r); err != nil {
+					decodeErrs = append(decodeErrs, err)
+				}
+				ids = append(ids, r.ID)
+			}
+
+			require.Equal(t, tc.expectedIDs, ids)
+			require.False(t, js.Next())
+			tc.validate(t, decodeErrs, js.Err())
+		})
+	}
+}
+
+func TestUnit_JSONStreamCancel(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("{\"id\":1}\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer testServer.Close()
+
+	finder := func(serviceName string, useTLS bool) (url.URL, error) {
+		u, err := url.Parse(testServer.URL)
+		return *u, err
+	}
+	bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	js := NewJSONStream(ctx, bc.(Streamer), JSONStreamConfig{Slug: "/export"})
+	require.True(t, js.Next())
+	cancel()
+	require.False(t, js.Next())
+	require.NotNil(t, js.Err())
+	require.Equal(t, ErrorRequestError, js.Err().Code())
+}
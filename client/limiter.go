@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrLimitExceeded is returned when a request cannot obtain a concurrency slot in time
+var ErrLimitExceeded = errors.New("concurrency limit exceeded")
+
+// Limiter restricts the number of requests in flight to a downstream service
+type Limiter interface {
+	// Acquire obtains a slot for a request. The returned release function must be called exactly once with the
+	// latency of the request and whether the downstream showed signs of overload.
+	Acquire(ctx context.Context) (release func(latency time.Duration, overloaded bool), err error)
+	// Limit returns the number of requests currently permitted to be in flight
+	Limit() int
+	// InFlight returns the number of requests currently in flight
+	InFlight() int
+}
+
+// AIMDLimiterConfig configures an AIMDLimiter
+type AIMDLimiterConfig struct {
+	// InitialLimit is the starting number of permitted in-flight requests; defaults to 10
+	InitialLimit int
+	// MinLimit is the floor the limit can shrink to; defaults to 1
+	MinLimit int
+	// MaxLimit is the ceiling the limit can grow to; defaults to 200
+	MaxLimit int
+	// BackoffRatio is the multiplicative decrease applied on overload; defaults to 0.9
+	BackoffRatio float64
+	// LatencyThreshold marks requests slower than it as overloaded; zero only reacts to errors
+	LatencyThreshold time.Duration
+	// MaxWait is how long Acquire waits for a free slot; zero rejects immediately when the limit is reached
+	MaxWait time.Duration
+}
+
+// AIMDLimiter is an adaptive Limiter using additive increase, multiplicative decrease. The limit grows by one for
+// each successful request made while the limit was being used and shrinks by BackoffRatio whenever a request fails,
+// is rejected by the downstream, or exceeds the latency threshold.
+type AIMDLimiter struct {
+	cfg AIMDLimiterConfig
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	released chan struct{}
+}
+
+// NewAIMDLimiter creates an AIMDLimiter, filling in defaults for unset configuration
+func NewAIMDLimiter(cfg AIMDLimiterConfig) *AIMDLimiter {
+	if cfg.MinLimit <= 0 {
+		cfg.MinLimit = 1
+	}
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = 200
+	}
+	if cfg.InitialLimit <= 0 {
+		cfg.InitialLimit = 10
+	}
+	if cfg.InitialLimit < cfg.MinLimit {
+		cfg.InitialLimit = cfg.MinLimit
+	}
+	if cfg.InitialLimit > cfg.MaxLimit {
+		cfg.InitialLimit = cfg.MaxLimit
+	}
+	if cfg.BackoffRatio <= 0 || cfg.BackoffRatio >= 1 {
+		cfg.BackoffRatio = 0.9
+	}
+
+	return &AIMDLimiter{cfg: cfg, limit: float64(cfg.InitialLimit), released: make(chan struct{})}
+}
+
+// Acquire obtains a slot, waiting up to the configured MaxWait for one to free up
+func (l *AIMDLimiter) Acquire(ctx context.Context) (func(time.Duration, bool), error) {
+	var timeout <-chan time.Time
+	if l.cfg.MaxWait > 0 {
+		t := time.NewTimer(l.cfg.MaxWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	for {
+		l.mu.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mu.Unlock()
+			return l.releaser(), nil
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		if timeout == nil {
+			return nil, ErrLimitExceeded
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout:
+			return nil, ErrLimitExceeded
+		case <-released:
+		}
+	}
+}
+
+func (l *AIMDLimiter) releaser() func(time.Duration, bool) {
+	var once sync.Once
+	return func(latency time.Duration, overloaded bool) {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			if l.cfg.LatencyThreshold > 0 && latency > l.cfg.LatencyThreshold {
+				overloaded = true
+			}
+
+			if overloaded {
+				l.limit *= l.cfg.BackoffRatio
+				if l.limit < float64(l.cfg.MinLimit) {
+					l.limit = float64(l.cfg.MinLimit)
+				}
+			} else if float64(l.inFlight)*2 >= l.limit {
+				// Only grow when the current limit is actually being used; otherwise an idle client would drift to
+				// the maximum and offer no protection once load arrives.
+				l.limit++
+				if l.limit > float64(l.cfg.MaxLimit) {
+					l.limit = float64(l.cfg.MaxLimit)
+				}
+			}
+
+			l.inFlight--
+			close(l.released)
+			l.released = make(chan struct{})
+		})
+	}
+}
+
+// Limit returns the number of requests currently permitted to be in flight
+func (l *AIMDLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of requests currently in flight
+func (l *AIMDLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// WithConcurrencyLimiter restricts the number of concurrent requests the client makes using l
+func WithConcurrencyLimiter(l Limiter) Option {
+	return func(c *client) {
+		c.limiter = l
+	}
+}
+
+// limitedTransport holds a limiter slot for the lifetime of each request, including reading the response body. Streamed
+// responses, which may stay open indefinitely, give up their slot once their headers arrive.
+type limitedTransport struct {
+	next    http.RoundTripper
+	limiter Limiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.Acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		// A caller giving up says nothing about the health of the downstream
+		release(time.Since(start), !errors.Is(err, context.Canceled))
+		return nil, err
+	}
+
+	overloaded := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+	if isStream(req.Context()) {
+		release(time.Since(start), overloaded)
+		return resp, nil
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { release(time.Since(start), overloaded) }}
+
+	return resp, nil
+}
+
+// releasingBody invokes release once the body has been closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_AIMDLimiter(t *testing.T) {
+	tests := map[string]struct {
+		cfg      AIMDLimiterConfig
+		validate func(t *testing.T, l *AIMDLimiter)
+	}{
+		"base path- defaults applied": {
+			validate: func(t *testing.T, l *AIMDLimiter) {
+				require.Equal(t, 10, l.Limit())
+				require.Equal(t, 0, l.InFlight())
+			},
+		},
+		"base path- limit grows on success while in use": {
+			cfg: AIMDLimiterConfig{InitialLimit: 2, MaxLimit: 3},
+			validate: func(t *testing.T, l *AIMDLimiter) {
+				for i := 0; i < 3; i++ {
+					release, err := l.Acquire(context.Background())
+					require.NoError(t, err)
+					release(time.Millisecond, false)
+				}
+				require.Equal(t, 3, l.Limit())
+			},
+		},
+		"base path- limit shrinks on overload": {
+			cfg: AIMDLimiterConfig{InitialLimit: 10, BackoffRatio: 0.5},
+			validate: func(t *testing.T, l *AIMDLimiter) {
+				release, err := l.Acquire(context.Background())
+				require.NoError(t, err)
+				release(time.Millisecond, true)
+				require.Equal(t, 5, l.Limit())
+			},
+		},
+		"base path- slow requests count as overload": {
+			cfg: AIMDLimiterConfig{InitialLimit: 10, BackoffRatio: 0.5, LatencyThreshold: time.Millisecond},
+			validate: func(t *testing.T, l *AIMDLimiter) {
+				release, err := l.Acquire(context.Background())
+				require.NoError(t, err)
+				release(time.Second, false)
+				require.Equal(t, 5, l.Limit())
+			},
+		},
+		"base path- limit never drops below the minimum": {
+			cfg: AIMDLimiterConfig{InitialLimit: 2, MinLimit: 2, BackoffRatio: 0.1},
+			validate: func(t *testing.T, l *AIMDLimiter) {
+				release, err := l.Acquire(context.Background())
+				require.NoError(t, err)
+				release(time.Millisecond, true)
+				require.Equal(t, 2, l.Limit())
+			},
+		},
+		"base path- waiter obtains a released slot": {
+			cfg: AIMDLimiterConfig{InitialLimit: 1, MaxLimit: 1, MaxWait: time.Second},
+			validate: func(t *testing.T, l *AIMDLimiter) {
+				release, err := l.Acquire(context.Background())
+				require.NoError(t, err)
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					release(time.Millisecond, false)
+				}()
+				release2, err := l.Acquire(context.Background())
+				require.NoError(t, err)
+				require.Equal(t, 1, l.InFlight())
+				release2(time.Millisecond, false)
+			},
+		},
+		"exceptional path- rejects immediately when full": {
+			cfg: AIMDLimiterConfig{InitialLimit: 1},
+			validate: func(t *testing.T, l *AIMDLimiter) {
+				_, err := l.Acquire(context.Background())
+				require.NoError(t, err)
+				_, err = l.Acquire(context.Background())
+				require.Equal(t, ErrLimitExceeded, err)
+			},
+		},
+		"exceptional path- context cancelled while waiting": {
+			cfg: AIMDLimiterConfig{InitialLimit: 1, MaxWait: time.Minute},
+			validate: func(t *testing.T, l *AIMDLimiter) {
+				_, err := l.Acquire(context.Background())
+				require.NoError(t, err)
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				_, err = l.Acquire(ctx)
+				require.Equal(t, context.DeadlineExceeded, err)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.validate(t, NewAIMDLimiter(tc.cfg))
+		})
+	}
+}
+
+func TestUnit_WithConcurrencyLimiter(t *testing.T) {
+	tests := map[string]struct {
+		requestHandler http.HandlerFunc
+		validate       func(t *testing.T, bc BaseClient, l *AIMDLimiter)
+	}{
+		"base path- slot released after the call": {
+			requestHandler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprintf(w, `{"foo":"bar"}`)
+			},
+			validate: func(t *testing.T, bc BaseClient, l *AIMDLimiter) {
+				err := bc.Do(context.Background(), "GET", "1", nil, nil, nil, new(map[string]string))
+				require.NoError(t, err)
+				require.Equal(t, 0, l.InFlight())
+			},
+		},
+		"base path- server errors shrink the limit": {
+			requestHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = fmt.Fprintf(w, `{"code":"UNAVAILABLE"}`)
+			},
+			validate: func(t *testing.T, bc BaseClient, l *AIMDLimiter) {
+				err := bc.Do(context.Background(), "GET", "1", nil, nil, nil, nil)
+				require.Error(t, err)
+				require.Equal(t, "UNAVAILABLE", err.Code())
+				require.Equal(t, 2, l.Limit())
+			},
+		},
+		"base path- streamed response releases its slot once headers arrive": {
+			requestHandler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprintf(w, "first\n")
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			},
+			validate: func(t *testing.T, bc BaseClient, l *AIMDLimiter) {
+				resp, err := bc.(Streamer).Stream(context.Background(), "GET", "1", nil, nil, nil)
+				require.Nil(t, err)
+				defer resp.Body.Close()
+				require.Equal(t, 0, l.InFlight())
+				require.Equal(t, 4, l.Limit())
+			},
+		},
+		"exceptional path- limit reached": {
+			requestHandler: func(w http.ResponseWriter, r *http.Request) {},
+			validate: func(t *testing.T, bc BaseClient, l *AIMDLimiter) {
+				for i := 0; i < l.Limit(); i++ {
+					_, err := l.Acquire(context.Background())
+					require.NoError(t, err)
+				}
+				_, _, err := bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+				require.Error(t, err)
+				require.Equal(t, ErrorConcurrencyLimit, err.Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			testServer := httptest.NewServer(tc.requestHandler)
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			l := NewAIMDLimiter(AIMDLimiterConfig{InitialLimit: 4, BackoffRatio: 0.5})
+			tc.validate(t, NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithConcurrencyLimiter(l)), l)
+		})
+	}
+}
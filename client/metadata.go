@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+type responseMetadataKey struct{}
+
+// ResponseMetadata describes how a call made through Do or MakeRequest was answered
+type ResponseMetadata struct {
+	// StatusCode is the status of the response received from the service
+	StatusCode int
+	// Header holds the headers of the response received from the service
+	Header http.Header
+	// FallbackUsed reports whether the returned response came from a fallback rather than the service
+	FallbackUsed bool
+	// FallbackCause is the error that triggered the fallback
+	FallbackCause glitch.DataError
+	// RequestID is the request ID sent with the call by a client configured WithRequestID
+	RequestID string
+	// ResponseRequestID is the request ID the service echoed in its response, which is that of the shared request for a
+	// coalesced call
+	ResponseRequestID string
+
+	// url is where the response came from, after any redirects, and origin the host the call was first sent to
+	url    *url.URL
+	origin string
+}
+
+// ContextWithResponseMetadata returns a context that records details of the response to a call made with it
+func ContextWithResponseMetadata(ctx context.Context) (context.Context, *ResponseMetadata) {
+	md := &ResponseMetadata{}
+	return context.WithValue(ctx, responseMetadataKey{}, md), md
+}
+
+// responseMetadataFrom returns the ResponseMetadata attached to ctx, if any
+func responseMetadataFrom(ctx context.Context) *ResponseMetadata {
+	if ctx == nil {
+		return nil
+	}
+
+	md, _ := ctx.Value(responseMetadataKey{}).(*ResponseMetadata)
+	return md
+}
+
+// recordResponse notes the status, headers, and whereabouts of resp if the caller asked for metadata
+func recordResponse(ctx context.Context, resp *http.Response) {
+	md := responseMetadataFrom(ctx)
+	if md == nil {
+		return
+	}
+
+	md.StatusCode = resp.StatusCode
+	md.Header = resp.Header
+	md.url, md.origin = nil, ""
+	if r := resp.Request; r != nil {
+		md.url = r.URL
+		for r.Response != nil && r.Response.Request != nil {
+			r = r.Response.Request
+		}
+		md.origin = r.URL.Host
+	}
+}
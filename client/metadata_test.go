@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_ContextWithResponseMetadata(t *testing.T) {
+	tests := map[string]struct {
+		opts     []Option
+		validate func(t *testing.T, bc BaseClient)
+	}{
+		"base path- status and headers recorded": {
+			validate: func(t *testing.T, bc BaseClient) {
+				ctx, md := ContextWithResponseMetadata(context.Background())
+				_, _, err := bc.MakeRequest(ctx, "GET", "1", nil, nil, nil)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusAccepted, md.StatusCode)
+				require.Equal(t, "bar", md.Header.Get("X-Foo"))
+				require.False(t, md.FallbackUsed)
+			},
+		},
+		"base path- every coalesced caller receives metadata": {
+			opts: []Option{WithRequestCoalescing()},
+			validate: func(t *testing.T, bc BaseClient) {
+				var wg sync.WaitGroup
+				mds := make([]*ResponseMetadata, 3)
+				for i := range mds {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						var ctx context.Context
+						ctx, mds[i] = ContextWithResponseMetadata(context.Background())
+						_, _, err := bc.MakeRequest(ctx, "GET", "1", nil, nil, nil)
+						require.NoError(t, err)
+					}(i)
+				}
+				wg.Wait()
+
+				for _, md := range mds {
+					require.Equal(t, http.StatusAccepted, md.StatusCode)
+					require.Equal(t, "bar", md.Header.Get("X-Foo"))
+				}
+			},
+		},
+		"exceptional path- no metadata requested": {
+			validate: func(t *testing.T, bc BaseClient) {
+				_, _, err := bc.MakeRequest(context.Background(), "GET", "1", nil, nil, nil)
+				require.NoError(t, err)
+				require.Nil(t, responseMetadataFrom(context.Background()))
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(10 * time.Millisecond)
+				w.Header().Set("X-Foo", "bar")
+				w.WriteHeader(http.StatusAccepted)
+				_, _ = fmt.Fprintf(w, `{}`)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			tc.validate(t, NewBaseClient(finder, "foo", false, 10*time.Second, nil, tc.opts...))
+		})
+	}
+}
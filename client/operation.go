@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// ErrorOperationFailed is the code of the error returned when a long-running operation ends in failure
+const ErrorOperationFailed = "OPERATION_FAILED"
+
+const (
+	defaultOperationInterval    = time.Second
+	defaultOperationMaxInterval = time.Minute
+)
+
+// OperationState is the state of a long-running operation
+type OperationState int
+
+// Operation states
+const (
+	OperationRunning OperationState = iota
+	OperationSucceeded
+	OperationFailed
+)
+
+// OperationStatus is a response received while polling a long-running operation
+type OperationStatus struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// OperationConfig configures DoOperation
+type OperationConfig struct {
+	// Interval is the time between polls when the service does not send Retry-After; defaults to 1s
+	Interval time.Duration
+	// MaxInterval caps the time between polls asked for through Retry-After; defaults to 1 minute
+	MaxInterval time.Duration
+	// State works out the state of the operation from a poll response; defaults to DefaultOperationState
+	State func(s OperationStatus) OperationState
+	// ResultLocation returns where the finished resource can be fetched from once the operation succeeded. When it is
+	// nil or returns an empty string, the last poll response is decoded instead.
+	ResultLocation func(s OperationStatus) string
+}
+
+// DefaultOperationState treats 202 Accepted as a running operation and any other successful status as done
+func DefaultOperationState(s OperationStatus) OperationState {
+	if s.StatusCode == http.StatusAccepted {
+		return OperationRunning
+	}
+
+	return OperationSucceeded
+}
+
+// OperationStatusField reads the state of an operation from a field of the poll response, a dot separated path such
+// as "status". Values listed in succeeded or failed end the operation; any other value means it is still running.
+func OperationStatusField(field string, succeeded []string, failed []string) func(s OperationStatus) OperationState {
+	return func(s OperationStatus) OperationState {
+		raw, ok, err := jsonField(s.Body, field)
+		if err != nil || !ok {
+			return OperationRunning
+		}
+
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return OperationRunning
+		}
+		for _, v := range succeeded {
+			if v == value {
+				return OperationSucceeded
+			}
+		}
+		for _, v := range failed {
+			if v == value {
+				return OperationFailed
+			}
+		}
+
+		return OperationRunning
+	}
+}
+
+// DoOperation makes a request like Do. When the service answers 202 Accepted with an Operation-Location or Location
+// header, the URL in it is polled until the operation ends, and the finished resource is decoded into response.
+// Polling stops when ctx is done.
+func DoOperation(ctx context.Context, bc BaseClient, method string, slug string, query url.Values, headers http.Header, body io.Reader, cfg OperationConfig, response interface{}) glitch.DataError {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultOperationInterval
+	}
+	if cfg.MaxInterval <= 0 {
+		cfg.MaxInterval = defaultOperationMaxInterval
+	}
+	if cfg.State == nil {
+		cfg.State = DefaultOperationState
+	}
+
+	last, md, err := operationRequest(ctx, bc, method, slug, query, headers, body)
+	if err != nil {
+		return err
+	}
+	// Locations only get credentials when they are on the host the operation was started on
+	origin := md.origin
+
+	location := last.Header.Get("Operation-Location")
+	if location == "" {
+		location = last.Header.Get("Location")
+	}
+	if last.StatusCode != http.StatusAccepted || location == "" {
+		return decodeResponse(last.StatusCode, last.Body, response)
+	}
+
+	pollCtx, pollURL, rErr := resolveLocation(ctx, md.url, slug, origin, location)
+	if rErr != nil {
+		return glitch.NewDataError(rErr, ErrorOperationFailed, "Could not follow the operation")
+	}
+	pollHeaders := operationPollHeaders(headers)
+
+	for {
+		if err := waitToPoll(ctx, last, cfg); err != nil {
+			return err
+		}
+
+		last, md, err = operationRequest(pollCtx, bc, http.MethodGet, pollURL.Path, pollURL.Query(), pollHeaders, nil)
+		if err != nil {
+			return err
+		}
+
+		switch cfg.State(last) {
+		case OperationRunning:
+			continue
+		case OperationFailed:
+			return glitch.NewDataError(fmt.Errorf("operation at %s failed: %s", location, last.Body), ErrorOperationFailed, "The operation failed")
+		}
+
+		if cfg.ResultLocation != nil {
+			if result := cfg.ResultLocation(last); result != "" {
+				// The result is relative to the poll that announced it, wherever that was
+				base := md.url
+				if base == nil {
+					base = pollURL
+				}
+				resultCtx, resultURL, rErr := resolveLocation(pollCtx, base, pollURL.Path, origin, result)
+				if rErr != nil {
+					return glitch.NewDataError(rErr, ErrorOperationFailed, "Could not fetch the result of the operation")
+				}
+				return bc.Do(resultCtx, http.MethodGet, resultURL.Path, resultURL.Query(), pollHeaders, nil, response)
+			}
+		}
+
+		return decodeResponse(last.StatusCode, last.Body, response)
+	}
+}
+
+// operationRequest makes one request of an operation, failing on error responses. The metadata of the response tells
+// where it came from.
+func operationRequest(ctx context.Context, bc BaseClient, method string, slug string, query url.Values, headers http.Header, body io.Reader) (OperationStatus, *ResponseMetadata, glitch.DataError) {
+	ctx, md := ContextWithResponseMetadata(ctx)
+	status, ret, err := bc.MakeRequest(ctx, method, slug, query, headers, body)
+	if err != nil {
+		return OperationStatus{}, nil, err
+	}
+	if err := statusError(status, ret, fmt.Sprintf("Error from %s to %s", method, slug)); err != nil {
+		return OperationStatus{}, nil, err
+	}
+
+	return OperationStatus{StatusCode: status, Header: md.Header, Body: ret}, md, nil
+}
+
+// waitToPoll waits for the delay the last response asked for, or the configured interval
+func waitToPoll(ctx context.Context, last OperationStatus, cfg OperationConfig) glitch.DataError {
+	delay := cfg.Interval
+	if retryAfter, ok := parseRetryAfter(last.Header.Get("Retry-After")); ok {
+		delay = retryAfter
+		if delay > cfg.MaxInterval {
+			delay = cfg.MaxInterval
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return requestError(ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// operationPollHeaders returns the headers of the GETs polling an operation: those of the call that started it, less
+// the ones describing its body or making it idempotent
+func operationPollHeaders(headers http.Header) http.Header {
+	h := cloneHeader(headers)
+	for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Content-Digest", DefaultIdempotencyKeyHeader} {
+		h.Del(name)
+	}
+
+	return h
+}
+
+// resolveLocation resolves location against base, the URL of the response it came in, or against slug when that is
+// unknown. Locations naming a host are reached through the base URL of the call, and get no credentials unless that
+// host is origin, the one the operation was started on.
+func resolveLocation(ctx context.Context, base *url.URL, slug string, origin string, location string) (context.Context, *url.URL, error) {
+	ref, err := url.Parse(location)
+	if err != nil {
+		return nil, nil, err
+	}
+	if base == nil {
+		base = &url.URL{Path: slug}
+	}
+
+	u := base.ResolveReference(ref)
+	if u.Host != "" {
+		ctx = WithCallOptions(ctx, callLocation(url.URL{Scheme: u.Scheme, Host: u.Host}, strings.EqualFold(u.Host, origin)))
+	}
+
+	return ctx, u, nil
+}
@@ -0,0 +1,296 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+func TestUnit_DoOperation(t *testing.T) {
+	type thing struct {
+		Name string `json:"name"`
+	}
+
+	statusField := OperationStatusField("status", []string{"succeeded"}, []string{"failed"})
+
+	tests := map[string]struct {
+		slug     string
+		cfg      OperationConfig
+		timeout  time.Duration
+		validate func(t *testing.T, resp thing, polls int32, err glitch.DataError)
+	}{
+		"base path- polled until the operation is done": {
+			slug: "/accepted",
+			validate: func(t *testing.T, resp thing, polls int32, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "done", resp.Name)
+				require.Equal(t, int32(3), polls)
+			},
+		},
+		"base path- absolute location followed": {
+			slug: "/absolute",
+			validate: func(t *testing.T, resp thing, polls int32, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "done", resp.Name)
+			},
+		},
+		"base path- status field and result location": {
+			slug: "/status",
+			cfg: OperationConfig{
+				State: statusField,
+				ResultLocation: func(s OperationStatus) string {
+					var body struct {
+						Resource string `json:"resource"`
+					}
+					_ = json.Unmarshal(s.Body, &body)
+					return body.Resource
+				},
+			},
+			validate: func(t *testing.T, resp thing, polls int32, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "result", resp.Name)
+				require.Equal(t, int32(3), polls)
+			},
+		},
+		"base path- response without an operation decoded as is": {
+			slug: "/immediate",
+			validate: func(t *testing.T, resp thing, polls int32, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "immediate", resp.Name)
+				require.Equal(t, int32(0), polls)
+			},
+		},
+		"exceptional path- operation failed": {
+			slug: "/failing",
+			cfg:  OperationConfig{State: statusField},
+			validate: func(t *testing.T, resp thing, polls int32, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorOperationFailed, err.Code())
+			},
+		},
+		"exceptional path- error while polling": {
+			slug: "/gone",
+			validate: func(t *testing.T, resp thing, polls int32, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, "GONE", err.Code())
+			},
+		},
+		"exceptional path- context done while waiting": {
+			slug:    "/slow",
+			timeout: 50 * time.Millisecond,
+			validate: func(t *testing.T, resp thing, polls int32, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorRequestError, err.Code())
+				require.Equal(t, int32(0), polls)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var polls int32
+			var serverURL string
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/accepted", "/status", "/failing", "/gone":
+					w.Header().Set("Operation-Location", "/operations/1?kind="+r.URL.Path[1:])
+					w.Header().Set("Location", "/ignored")
+					w.WriteHeader(http.StatusAccepted)
+				case "/absolute":
+					w.Header().Set("Location", serverURL+"/operations/1?kind=accepted")
+					w.WriteHeader(http.StatusAccepted)
+				case "/slow":
+					w.Header().Set("Location", "/operations/1")
+					w.Header().Set("Retry-After", "10")
+					w.WriteHeader(http.StatusAccepted)
+				case "/immediate":
+					_, _ = fmt.Fprintf(w, `{"name":"immediate"}`)
+				case "/operations/1":
+					n := atomic.AddInt32(&polls, 1)
+					switch kind := r.URL.Query().Get("kind"); {
+					case kind == "gone":
+						w.WriteHeader(http.StatusGone)
+						_, _ = fmt.Fprintf(w, `{"code":"GONE"}`)
+					case kind == "failing":
+						_, _ = fmt.Fprintf(w, `{"status":"failed"}`)
+					case n < 3 && kind == "status":
+						_, _ = fmt.Fprintf(w, `{"status":"running"}`)
+					case kind == "status":
+						_, _ = fmt.Fprintf(w, `{"status":"succeeded","resource":"/things/1"}`)
+					case n < 3:
+						w.Header().Set("Retry-After", "0")
+						w.WriteHeader(http.StatusAccepted)
+					default:
+						_, _ = fmt.Fprintf(w, `{"name":"done"}`)
+					}
+				case "/things/1":
+					_, _ = fmt.Fprintf(w, `{"name":"result"}`)
+				}
+			}))
+			defer testServer.Close()
+			serverURL = testServer.URL
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil)
+
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			if tc.cfg.Interval == 0 {
+				tc.cfg.Interval = time.Millisecond
+			}
+
+			var resp thing
+			err := DoOperation(ctx, bc, "POST", tc.slug, nil, nil, nil, tc.cfg, &resp)
+			tc.validate(t, resp, atomic.LoadInt32(&polls), err)
+		})
+	}
+}
+
+func TestUnit_DoOperationPollHeaders(t *testing.T) {
+	tests := map[string]struct {
+		foreign  bool
+		absolute bool
+		rotate   bool
+		result   bool
+		headers  http.Header
+		validate func(t *testing.T, poll http.Header, resp map[string]string, err glitch.DataError)
+	}{
+		"base path- body headers dropped from polls": {
+			headers: http.Header{"Content-Type": []string{"application/json"}, "Idempotency-Key": []string{"key-1"}, "X-Tenant": []string{"acme"}},
+			validate: func(t *testing.T, poll http.Header, resp map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Empty(t, poll.Get("Content-Type"))
+				require.Empty(t, poll.Get("Idempotency-Key"))
+				require.Equal(t, "acme", poll.Get("X-Tenant"))
+				require.Equal(t, "Bearer service", poll.Get("Authorization"))
+			},
+		},
+		"base path- caller credentials kept on the service's host": {
+			headers: http.Header{"Authorization": []string{"Bearer mine"}, "Cookie": []string{"session=1"}},
+			validate: func(t *testing.T, poll http.Header, resp map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "Bearer mine", poll.Get("Authorization"))
+				require.Equal(t, "session=1", poll.Get("Cookie"))
+			},
+		},
+		"base path- credentials kept on the instance a load balancing finder picked": {
+			absolute: true,
+			rotate:   true,
+			validate: func(t *testing.T, poll http.Header, resp map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "Bearer service", poll.Get("Authorization"))
+			},
+		},
+		"base path- no credentials sent to another host": {
+			foreign: true,
+			headers: http.Header{"Authorization": []string{"Bearer mine"}, "Cookie": []string{"session=1"}, "X-Tenant": []string{"acme"}},
+			validate: func(t *testing.T, poll http.Header, resp map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Empty(t, poll.Get("Authorization"))
+				require.Empty(t, poll.Get("Cookie"))
+				require.Equal(t, "acme", poll.Get("X-Tenant"))
+			},
+		},
+		"base path- client credentials not added for another host": {
+			foreign: true,
+			validate: func(t *testing.T, poll http.Header, resp map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Empty(t, poll.Get("Authorization"))
+			},
+		},
+		"base path- result fetched from the host of the operation": {
+			foreign: true,
+			result:  true,
+			validate: func(t *testing.T, poll http.Header, resp map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "other host", resp["name"])
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			polls := make(chan http.Header, 20)
+			handler := func(host string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/result" {
+						_, _ = fmt.Fprintf(w, `{"name":%q}`, host)
+						return
+					}
+					polls <- r.Header.Clone()
+					_, _ = fmt.Fprintf(w, `{"name":"done"}`)
+				}
+			}
+			other := httptest.NewServer(handler("other host"))
+			defer other.Close()
+
+			serviceHandler := handler("service host")
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/things" {
+					serviceHandler(w, r)
+					return
+				}
+				location := "/operations/1"
+				switch {
+				case tc.foreign:
+					location = other.URL + location
+				case tc.absolute:
+					location = "http://" + r.Host + location
+				}
+				w.Header().Set("Location", location)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer testServer.Close()
+
+			// A rotating finder hands out the same server under two names, as one spreading load across instances would
+			var found int32
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				if tc.rotate && atomic.AddInt32(&found, 1)%2 == 0 {
+					u.Host = strings.Replace(u.Host, "127.0.0.1", "localhost", 1)
+				}
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithAuth(BearerToken("service")))
+
+			cfg := OperationConfig{Interval: time.Millisecond}
+			if tc.result {
+				cfg.ResultLocation = func(s OperationStatus) string { return "/result" }
+			}
+
+			runs := 1
+			if tc.rotate {
+				runs = 10
+			}
+			for i := 0; i < runs; i++ {
+				var resp map[string]string
+				err := DoOperation(context.Background(), bc, "POST", "/things", nil, tc.headers, nil, cfg, &resp)
+
+				var poll http.Header
+				select {
+				case poll = <-polls:
+				default:
+				}
+				tc.validate(t, poll, resp, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// ErrorPagination is the code of errors raised while working out the next page of a list
+const ErrorPagination = "PAGINATION_ERROR"
+
+// Page is one page of a paginated list
+type Page struct {
+	// Number is the position of the page in the list, starting at 1
+	Number int
+	// Slug and Query are the route and query the page was requested with
+	Slug  string
+	Query url.Values
+	// Header holds the response headers; it is only filled in by clients created with NewBaseClient
+	Header http.Header
+	// Body is the raw response body
+	Body json.RawMessage
+	// Items holds the raw items of the page
+	Items []json.RawMessage
+}
+
+// PageStrategy works out the request for the page following a page of a list
+type PageStrategy interface {
+	// NextPage returns the slug and query of the page after page, or false if page is the last one
+	NextPage(page Page) (slug string, query url.Values, ok bool, err error)
+}
+
+// firstPager is implemented by strategies adding parameters to the query of the first page
+type firstPager interface {
+	firstPage(query url.Values) url.Values
+}
+
+// PaginationConfig describes a paginated list
+type PaginationConfig struct {
+	// Method, Slug, Query, and Headers describe the request for the first page. Method defaults to GET.
+	Method  string
+	Slug    string
+	Query   url.Values
+	Headers http.Header
+	// Strategy finds the following pages
+	Strategy PageStrategy
+	// ItemsField is the dot separated path to the array of items in each page, such as "data" or "result.items".
+	// Leave it empty when the body of each page is the array.
+	ItemsField string
+	// MaxPages stops the iteration after this many pages; zero follows the list to its end
+	MaxPages int
+}
+
+// Pages lazily fetches the pages of a list through Do, one page per call to Next. Iteration stops at the end of the
+// list, after MaxPages pages, on the first error, or when the context is cancelled.
+type Pages struct {
+	ctx context.Context
+	bc  BaseClient
+	cfg PaginationConfig
+
+	slug  string
+	query url.Values
+	page  Page
+	done  bool
+	err   glitch.DataError
+}
+
+// NewPages creates a Pages for the list described by cfg. No request is made until Next is called.
+func NewPages(ctx context.Context, bc BaseClient, cfg PaginationConfig) *Pages {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodGet
+	}
+	query := cfg.Query
+	if fp, ok := cfg.Strategy.(firstPager); ok {
+		query = fp.firstPage(query)
+	}
+
+	return &Pages{ctx: ctx, bc: bc, cfg: cfg, slug: cfg.Slug, query: query}
+}
+
+// Next fetches the next page, returning false when there are no more pages or an error occurred
+func (p *Pages) Next() bool {
+	if p.done {
+		return false
+	}
+	if p.cfg.MaxPages > 0 && p.page.Number >= p.cfg.MaxPages {
+		p.done = true
+		return false
+	}
+	if p.page.Number > 0 {
+		slug, query, ok, err := p.cfg.Strategy.NextPage(p.page)
+		if err != nil {
+			return p.fail(glitch.NewDataError(err, ErrorPagination, "Could not find the next page"))
+		}
+		if !ok {
+			p.done = true
+			return false
+		}
+		p.slug, p.query = slug, query
+	}
+	if err := p.ctx.Err(); err != nil {
+		return p.fail(requestError(err))
+	}
+
+	ctx, md := ContextWithResponseMetadata(p.ctx)
+	var body json.RawMessage
+	if err := p.bc.Do(ctx, p.cfg.Method, p.slug, p.query, p.cfg.Headers, nil, &body); err != nil {
+		return p.fail(err)
+	}
+
+	items, err := pageItems(body, p.cfg.ItemsField)
+	if err != nil {
+		return p.fail(glitch.NewDataError(err, ErrorDecodingResponse, "Could not find the items of the page"))
+	}
+
+	p.page = Page{Number: p.page.Number + 1, Slug: p.slug, Query: p.query, Header: md.Header, Body: body, Items: items}
+	return true
+}
+
+// Page returns the page fetched by the last call to Next
+func (p *Pages) Page() Page {
+	return p.page
+}
+
+// Err returns the error that stopped the iteration, if any
+func (p *Pages) Err() glitch.DataError {
+	return p.err
+}
+
+func (p *Pages) fail(err glitch.DataError) bool {
+	p.err = err
+	p.done = true
+	return false
+}
+
+// Items lazily iterates over the items of every page of a list
+type Items struct {
+	pages *Pages
+	items []json.RawMessage
+	item  json.RawMessage
+}
+
+// NewItems creates an Items for the list described by cfg. No request is made until Next is called.
+func NewItems(ctx context.Context, bc BaseClient, cfg PaginationConfig) *Items {
+	return &Items{pages: NewPages(ctx, bc, cfg)}
+}
+
+// Next moves to the next item, fetching the next page when needed. It returns false once every item has been seen or
+// an error occurred.
+func (it *Items) Next() bool {
+	for len(it.items) == 0 {
+		if !it.pages.Next() {
+			it.item = nil
+			return false
+		}
+		it.items = it.pages.Page().Items
+	}
+
+	it.item, it.items = it.items[0], it.items[1:]
+	return true
+}
+
+// Decode unmarshals the current item into v
+func (it *Items) Decode(v interface{}) glitch.DataError {
+	if err := json.Unmarshal(it.item, v); err != nil {
+		return glitch.NewDataError(err, ErrorDecodingResponse, "Could not decode item")
+	}
+
+	return nil
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Items) Err() glitch.DataError {
+	return it.pages.Err()
+}
+
+// LinkPagination follows the RFC 8288 Link header with rel="next" of each page. Links are followed on the same
+// service, using their path and query.
+func LinkPagination() PageStrategy {
+	return linkStrategy{}
+}
+
+type linkStrategy struct{}
+
+func (linkStrategy) NextPage(page Page) (string, url.Values, bool, error) {
+	next, ok := parseLinks(page.Header["Link"])["next"]
+	if !ok {
+		return "", nil, false, nil
+	}
+
+	base := url.URL{Path: page.Slug, RawQuery: page.Query.Encode()}
+	ref, err := url.Parse(next)
+	if err != nil {
+		return "", nil, false, err
+	}
+	u := base.ResolveReference(ref)
+
+	return u.Path, u.Query(), true, nil
+}
+
+// parseLinks returns the target of each relation in Link header values, keeping the first link of each relation
+func parseLinks(values []string) map[string]string {
+	links := map[string]string{}
+	for _, v := range values {
+		for v != "" {
+			start := strings.IndexByte(v, '<')
+			end := strings.IndexByte(v, '>')
+			if start < 0 || end < start {
+				break
+			}
+			target := v[start+1 : end]
+			v = v[end+1:]
+
+			params := v
+			if next := strings.IndexByte(v, '<'); next >= 0 {
+				params, v = v[:next], v[next:]
+			} else {
+				v = ""
+			}
+
+			for _, param := range strings.Split(params, ";") {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimRight(strings.TrimSpace(kv[1]), ","), `"`)) {
+					rel = strings.ToLower(rel)
+					if _, ok := links[rel]; !ok {
+						links[rel] = target
+					}
+				}
+			}
+		}
+	}
+
+	return links
+}
+
+// CursorPagination reads the cursor of the next page from field, a dot separated path such as "meta.next_cursor",
+// and sends it in the query parameter param. The list ends when the cursor is missing, null, or empty.
+func CursorPagination(field string, param string) PageStrategy {
+	return cursorStrategy{field: field, param: param}
+}
+
+type cursorStrategy struct {
+	field string
+	param string
+}
+
+func (s cursorStrategy) NextPage(page Page) (string, url.Values, bool, error) {
+	raw, ok, err := jsonField(page.Body, s.field)
+	if err != nil || !ok {
+		return "", nil, false, err
+	}
+
+	// Numbers are kept as they were sent, since IDs used as cursors may not fit a float64
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var cursor interface{}
+	if err := d.Decode(&cursor); err != nil {
+		return "", nil, false, err
+	}
+
+	var value string
+	switch c := cursor.(type) {
+	case nil:
+		return "", nil, false, nil
+	case string:
+		value = c
+	case json.Number:
+		value = c.String()
+	default:
+		return "", nil, false, fmt.Errorf("cursor %s is not a string or number", s.field)
+	}
+	if value == "" {
+		return "", nil, false, nil
+	}
+
+	query := cloneValues(page.Query)
+	query.Set(s.param, value)
+	return page.Slug, query, true, nil
+}
+
+// OffsetPagination advances the query parameter offsetParam by the number of items on each page, starting from the
+// offset in the query of the first page or 0. When limit is positive it is sent in limitParam with every page, unless
+// the query of the first page sets it, and a page with fewer items ends the list; otherwise an empty page ends it.
+func OffsetPagination(offsetParam string, limitParam string, limit int) PageStrategy {
+	return offsetStrategy{offsetParam: offsetParam, limitParam: limitParam, limit: limit}
+}
+
+type offsetStrategy struct {
+	offsetParam string
+	limitParam  string
+	limit       int
+}
+
+func (s offsetStrategy) firstPage(query url.Values) url.Values {
+	query = cloneValues(query)
+	if query.Get(s.offsetParam) == "" {
+		query.Set(s.offsetParam, "0")
+	}
+	if s.limit > 0 && s.limitParam != "" && query.Get(s.limitParam) == "" {
+		query.Set(s.limitParam, strconv.Itoa(s.limit))
+	}
+
+	return query
+}
+
+func (s offsetStrategy) NextPage(page Page) (string, url.Values, bool, error) {
+	if len(page.Items) == 0 || (s.limit > 0 && len(page.Items) < s.limit) {
+		return "", nil, false, nil
+	}
+
+	offset := 0
+	if v := page.Query.Get(s.offsetParam); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil {
+			return "", nil, false, fmt.Errorf("invalid offset %q: %w", v, err)
+		}
+		offset = o
+	}
+
+	query := s.firstPage(page.Query)
+	query.Set(s.offsetParam, strconv.Itoa(offset+len(page.Items)))
+
+	return page.Slug, query, true, nil
+}
+
+// pageItems returns the items found at path in body
+func pageItems(body json.RawMessage, path string) ([]json.RawMessage, error) {
+	raw, ok, err := jsonField(body, path)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// jsonField returns the value at the dot separated path in body, or false if it is not there. An empty path returns
+// body itself.
+func jsonField(body json.RawMessage, path string) (json.RawMessage, bool, error) {
+	if path == "" {
+		return body, true, nil
+	}
+
+	raw := body
+	for _, key := range strings.Split(path, ".") {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, false, errors.New("expected an object holding " + key)
+		}
+		v, ok := obj[key]
+		if !ok {
+			return nil, false, nil
+		}
+		raw = v
+	}
+
+	return raw, true, nil
+}
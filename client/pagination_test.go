@@ -0,0 +1,263 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// listHandler serves the numbers 1 through 5 in pages of two, paginated in the style named by the path
+func listHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		start := 0
+		switch r.URL.Path {
+		case "/link":
+			start, _ = strconv.Atoi(q.Get("page"))
+			if start+2 < 5 {
+				w.Header().Add("Link", `</first>; rel="first", <?page=`+strconv.Itoa(start+2)+`>; rel="next"`)
+			}
+		case "/cursor":
+			start, _ = strconv.Atoi(q.Get("after"))
+		case "/offset":
+			require.NotEmpty(t, q.Get("offset"))
+			start, _ = strconv.Atoi(q.Get("offset"))
+			require.Equal(t, "2", q.Get("limit"))
+		case "/broken":
+			if q.Get("page") != "" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = fmt.Fprintf(w, `{"code":"UNAVAILABLE"}`)
+				return
+			}
+			w.Header().Set("Link", `</broken?page=2>; rel="next"`)
+		}
+
+		var items []int
+		for i := start + 1; i <= start+2 && i <= 5; i++ {
+			items = append(items, i)
+		}
+		next := "null"
+		if start+2 < 5 {
+			next = strconv.Quote(strconv.Itoa(start + 2))
+		}
+		by, _ := json.Marshal(items)
+		_, _ = fmt.Fprintf(w, `{"meta":{"next":%s},"data":%s}`, next, by)
+	}
+}
+
+func TestUnit_Pages(t *testing.T) {
+	tests := map[string]struct {
+		cfg      PaginationConfig
+		cancel   bool
+		noCtx    bool
+		validate func(t *testing.T, pages []Page, err glitch.DataError)
+	}{
+		"base path- Link header": {
+			cfg: PaginationConfig{Slug: "/link", Strategy: LinkPagination(), ItemsField: "data"},
+			validate: func(t *testing.T, pages []Page, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Len(t, pages, 3)
+				require.Equal(t, 3, pages[2].Number)
+				require.Equal(t, "4", pages[2].Query.Get("page"))
+				require.Len(t, pages[2].Items, 1)
+			},
+		},
+		"base path- cursor": {
+			cfg: PaginationConfig{Slug: "/cursor", Strategy: CursorPagination("meta.next", "after"), ItemsField: "data"},
+			validate: func(t *testing.T, pages []Page, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Len(t, pages, 3)
+				require.Equal(t, "4", pages[2].Query.Get("after"))
+			},
+		},
+		"base path- offset": {
+			cfg: PaginationConfig{
+				Slug:       "/offset",
+				Strategy:   OffsetPagination("offset", "limit", 2),
+				ItemsField: "data",
+			},
+			validate: func(t *testing.T, pages []Page, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Len(t, pages, 3)
+				require.Equal(t, "0", pages[0].Query.Get("offset"))
+				require.Equal(t, "4", pages[2].Query.Get("offset"))
+			},
+		},
+		"base path- offset from the first page's query": {
+			cfg: PaginationConfig{
+				Slug:       "/offset",
+				Query:      url.Values{"offset": []string{"2"}},
+				Strategy:   OffsetPagination("offset", "limit", 2),
+				ItemsField: "data",
+			},
+			noCtx: true,
+			validate: func(t *testing.T, pages []Page, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Len(t, pages, 2)
+				require.Equal(t, "2", pages[0].Query.Get("limit"))
+			},
+		},
+		"base path- stops after the maximum pages": {
+			cfg: PaginationConfig{Slug: "/link", Strategy: LinkPagination(), ItemsField: "data", MaxPages: 2},
+			validate: func(t *testing.T, pages []Page, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Len(t, pages, 2)
+			},
+		},
+		"exceptional path- error on a later page": {
+			cfg: PaginationConfig{Slug: "/broken", Strategy: LinkPagination(), ItemsField: "data"},
+			validate: func(t *testing.T, pages []Page, err glitch.DataError) {
+				require.Len(t, pages, 1)
+				require.NotNil(t, err)
+				require.Equal(t, "UNAVAILABLE", err.Code())
+			},
+		},
+		"exceptional path- items field is not an array": {
+			cfg: PaginationConfig{Slug: "/link", Strategy: LinkPagination(), ItemsField: "meta"},
+			validate: func(t *testing.T, pages []Page, err glitch.DataError) {
+				require.Empty(t, pages)
+				require.NotNil(t, err)
+				require.Equal(t, ErrorDecodingResponse, err.Code())
+			},
+		},
+		"exceptional path- context cancelled": {
+			cfg:    PaginationConfig{Slug: "/link", Strategy: LinkPagination(), ItemsField: "data"},
+			cancel: true,
+			validate: func(t *testing.T, pages []Page, err glitch.DataError) {
+				require.Len(t, pages, 1)
+				require.NotNil(t, err)
+				require.Equal(t, ErrorRequestError, err.Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			testServer := httptest.NewServer(listHandler(t))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var pages []Page
+			if tc.noCtx {
+				ctx = nil
+			}
+			p := NewPages(ctx, bc, tc.cfg)
+			for p.Next() {
+				pages = append(pages, p.Page())
+				if tc.cancel {
+					cancel()
+				}
+			}
+			tc.validate(t, pages, p.Err())
+		})
+	}
+}
+
+func TestUnit_Items(t *testing.T) {
+	testServer := httptest.NewServer(listHandler(t))
+	defer testServer.Close()
+
+	finder := func(serviceName string, useTLS bool) (url.URL, error) {
+		u, err := url.Parse(testServer.URL)
+		return *u, err
+	}
+	bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil)
+
+	var got []int
+	it := NewItems(context.Background(), bc, PaginationConfig{Slug: "/cursor", Strategy: CursorPagination("meta.next", "after"), ItemsField: "data"})
+	for it.Next() {
+		var n int
+		require.Nil(t, it.Decode(&n))
+		got = append(got, n)
+	}
+	require.Nil(t, it.Err())
+	require.Equal(t, []int{1, 2, 3, 4, 5}, got)
+}
+
+func TestUnit_parseLinks(t *testing.T) {
+	tests := map[string]struct {
+		values   []string
+		expected map[string]string
+	}{
+		"base path- several links in one value": {
+			values:   []string{`<https://example.com/a?page=2>; rel="next", <https://example.com/a?page=1>; rel="prev"`},
+			expected: map[string]string{"next": "https://example.com/a?page=2", "prev": "https://example.com/a?page=1"},
+		},
+		"base path- links across values with several relations": {
+			values:   []string{`</a?page=9>; title="last"; rel="last LAST"`, `</a?page=2>;rel=next`},
+			expected: map[string]string{"last": "/a?page=9", "next": "/a?page=2"},
+		},
+		"base path- first link of a relation kept": {
+			values:   []string{`</one>; rel="next", </two>; rel="next"`},
+			expected: map[string]string{"next": "/one"},
+		},
+		"exceptional path- malformed": {
+			values:   []string{`no links here`, `>broken<; rel="next"`},
+			expected: map[string]string{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, parseLinks(tc.values))
+		})
+	}
+}
+
+func TestUnit_cursorStrategy(t *testing.T) {
+	tests := map[string]struct {
+		body        string
+		expected    string
+		expectedOK  bool
+		expectedErr bool
+	}{
+		"base path- string cursor": {
+			body:       `{"meta":{"next":"abc"}}`,
+			expected:   "abc",
+			expectedOK: true,
+		},
+		"base path- large integer cursor sent verbatim": {
+			body:       `{"meta":{"next":1234567890123456789}}`,
+			expected:   "1234567890123456789",
+			expectedOK: true,
+		},
+		"base path- null cursor ends the list": {
+			body: `{"meta":{"next":null}}`,
+		},
+		"exceptional path- cursor of another type": {
+			body:        `{"meta":{"next":true}}`,
+			expectedErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, query, ok, err := CursorPagination("meta.next", "after").NextPage(Page{Slug: "/cursor", Body: []byte(tc.body)})
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOK, ok)
+			require.Equal(t, tc.expected, query.Get("after"))
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Progress reports how far a transfer has got
+type Progress struct {
+	// Transferred is the number of bytes transferred so far
+	Transferred int64
+	// Total is the size of the transfer, or -1 if it is not known
+	Total int64
+	// Rate is the average speed of the transfer so far, in bytes per second
+	Rate float64
+}
+
+// ProgressFunc is called as a transfer makes progress. It may be called from several goroutines, but never
+// concurrently.
+type ProgressFunc func(p Progress)
+
+// ProgressConfig asks for progress reports on the bodies of a call
+type ProgressConfig struct {
+	// Upload is called as the request body is sent. It starts over from zero if the body is sent again.
+	Upload ProgressFunc
+	// Download is called as the response body is received
+	Download ProgressFunc
+	// Interval and Bytes set how often reports are made: once Interval has passed or Bytes more bytes have been
+	// transferred since the last report, whichever comes first. When both are zero every read is reported. A final
+	// report is always made when the transfer ends.
+	Interval time.Duration
+	Bytes    int64
+}
+
+// CallProgress reports the progress of the call's request and response bodies. Calls asking for progress are never
+// coalesced with others.
+func CallProgress(cfg ProgressConfig) CallOption {
+	return func(o *callOptions) {
+		o.progress = &cfg
+	}
+}
+
+// progressTracker counts the bytes of a transfer and reports them at the configured granularity
+type progressTracker struct {
+	fn       ProgressFunc
+	interval time.Duration
+	bytes    int64
+	now      func() time.Time
+
+	mu           sync.Mutex
+	total        int64
+	start        time.Time
+	transferred  int64
+	lastReport   time.Time
+	lastReported int64
+}
+
+func newProgressTracker(fn ProgressFunc, interval time.Duration, bytes int64, total int64) *progressTracker {
+	p := &progressTracker{fn: fn, interval: interval, bytes: bytes, now: time.Now}
+	p.reset(total)
+	return p
+}
+
+// reset starts the transfer over
+func (p *progressTracker) reset(total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.total = total
+	p.start = p.now()
+	p.transferred = 0
+	p.lastReport = p.start
+	p.lastReported = 0
+}
+
+// setTotal updates the size of the transfer once it becomes known
+func (p *progressTracker) setTotal(total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.total = total
+}
+
+// add counts n more bytes, reporting them if enough time has passed or enough bytes were transferred
+func (p *progressTracker) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.transferred += n
+	now := p.now()
+	due := p.interval <= 0 && p.bytes <= 0
+	if p.interval > 0 && now.Sub(p.lastReport) >= p.interval {
+		due = true
+	}
+	if p.bytes > 0 && p.transferred-p.lastReported >= p.bytes {
+		due = true
+	}
+	if due {
+		p.report(now)
+	}
+}
+
+// done makes the final report of the transfer unless it was already made
+func (p *progressTracker) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lastReported != p.transferred || p.transferred == 0 {
+		p.report(p.now())
+	}
+}
+
+func (p *progressTracker) report(now time.Time) {
+	rate := 0.0
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		rate = float64(p.transferred) / elapsed
+	}
+	p.lastReport = now
+	p.lastReported = p.transferred
+
+	p.fn(Progress{Transferred: p.transferred, Total: p.total, Rate: rate})
+}
+
+// progressBody reports the bytes read from a body
+type progressBody struct {
+	io.ReadCloser
+	tracker *progressTracker
+	once    sync.Once
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.tracker.add(int64(n))
+	}
+	if err == io.EOF {
+		b.once.Do(b.tracker.done)
+	}
+
+	return n, err
+}
+
+// Close closes the body, making the final report if the transfer ended early
+func (b *progressBody) Close() error {
+	b.once.Do(b.tracker.done)
+	return b.ReadCloser.Close()
+}
+
+// trackUpload reports the progress of the body of req, starting over each time the body is produced again
+func trackUpload(req *http.Request, cfg *ProgressConfig) {
+	if cfg == nil || cfg.Upload == nil || req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+
+	total := req.ContentLength
+	if total <= 0 {
+		total = -1
+	}
+	tracker := newProgressTracker(cfg.Upload, cfg.Interval, cfg.Bytes, total)
+	req.Body = &progressBody{ReadCloser: req.Body, tracker: tracker}
+
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			rc, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			tracker.reset(total)
+			return &progressBody{ReadCloser: rc, tracker: tracker}, nil
+		}
+	}
+}
+
+// trackDownload reports the progress of the body of resp
+func trackDownload(resp *http.Response, cfg *ProgressConfig) {
+	if cfg == nil || cfg.Download == nil {
+		return
+	}
+
+	total := resp.ContentLength
+	if total < 0 {
+		total = -1
+	}
+	resp.Body = &progressBody{ReadCloser: resp.Body, tracker: newProgressTracker(cfg.Download, cfg.Interval, cfg.Bytes, total)}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_progressTracker(t *testing.T) {
+	tests := map[string]struct {
+		interval time.Duration
+		bytes    int64
+		expected []int64
+	}{
+		"base path- every read reported": {
+			expected: []int64{10, 20, 30, 40, 50},
+		},
+		"base path- reported every 25 bytes": {
+			bytes:    25,
+			expected: []int64{30, 50},
+		},
+		"base path- reported every 2 seconds": {
+			interval: 2 * time.Second,
+			expected: []int64{20, 40, 50},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			var reports []Progress
+			p := newProgressTracker(func(pr Progress) { reports = append(reports, pr) }, tc.interval, tc.bytes, 50)
+			p.now = func() time.Time { return now }
+			p.reset(50)
+
+			for i := 0; i < 5; i++ {
+				now = now.Add(time.Second)
+				p.add(10)
+			}
+			p.done()
+
+			var transferred []int64
+			for _, r := range reports {
+				require.Equal(t, int64(50), r.Total)
+				transferred = append(transferred, r.Transferred)
+			}
+			require.Equal(t, tc.expected, transferred)
+			require.Equal(t, 10.0, reports[len(reports)-1].Rate)
+		})
+	}
+}
+
+func TestUnit_CallProgress(t *testing.T) {
+	download := strings.Repeat("d", 3000)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Length", strconv.Itoa(len(download)))
+		_, _ = w.Write([]byte(download))
+	}))
+	defer testServer.Close()
+
+	finder := func(serviceName string, useTLS bool) (url.URL, error) {
+		u, err := url.Parse(testServer.URL)
+		return *u, err
+	}
+	bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithRequestCoalescing())
+
+	var mu sync.Mutex
+	var uploads, downloads []Progress
+	ctx := WithCallOptions(context.Background(), CallProgress(ProgressConfig{
+		Upload: func(p Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			uploads = append(uploads, p)
+		},
+		Download: func(p Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			downloads = append(downloads, p)
+		},
+		Bytes: 1000,
+	}))
+
+	_, _, err := bc.MakeRequest(ctx, http.MethodPut, "/upload", nil, nil, strings.NewReader(strings.Repeat("u", 2500)))
+	require.Nil(t, err)
+	mu.Lock()
+	downloads = nil
+	mu.Unlock()
+
+	_, ret, err := bc.MakeRequest(ctx, http.MethodGet, "/download", nil, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, download, string(ret))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, uploads)
+	require.Equal(t, int64(2500), uploads[len(uploads)-1].Transferred)
+	require.Equal(t, int64(2500), uploads[len(uploads)-1].Total)
+
+	require.True(t, len(downloads) >= 2)
+	last := downloads[len(downloads)-1]
+	require.Equal(t, int64(3000), last.Transferred)
+	require.Equal(t, int64(3000), last.Total)
+	for i := 1; i < len(downloads); i++ {
+		require.Greater(t, downloads[i].Transferred, downloads[i-1].Transferred)
+	}
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Inbound is what an incoming request carries that outgoing calls made on its behalf may forward
+type Inbound struct {
+	// Credential is the Authorization header of the incoming request
+	Credential string
+	// Headers are the captured headers of the incoming request
+	Headers http.Header
+}
+
+type inboundKey struct{}
+
+// ContextWithInbound returns a context carrying in, for entry points other than HTTP handlers, such as queue consumers
+func ContextWithInbound(ctx context.Context, in Inbound) context.Context {
+	return context.WithValue(ctx, inboundKey{}, in)
+}
+
+// InboundFromContext returns what was captured from the incoming request of ctx
+func InboundFromContext(ctx context.Context) (Inbound, bool) {
+	in, ok := ctx.Value(inboundKey{}).(Inbound)
+	return in, ok
+}
+
+// CaptureInbound is server middleware storing the Authorization header and the headers named in the context of each
+// request, so calls made with r.Context() can forward them to services configured WithPropagation
+func CaptureInbound(headers ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			in := Inbound{Credential: r.Header.Get("Authorization"), Headers: http.Header{}}
+			for _, h := range headers {
+				if values := r.Header.Values(h); len(values) > 0 {
+					in.Headers[http.CanonicalHeaderKey(h)] = append([]string(nil), values...)
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(ContextWithInbound(r.Context(), in)))
+		})
+	}
+}
+
+// TokenExchanger exchanges the credential of an incoming request for a token to call a service with, as in an
+// OAuth2 token exchange. Implementations should cache the tokens they obtain since Exchange is called for every
+// attempt.
+type TokenExchanger interface {
+	// Exchange returns a token for audience, the name of the service called, acting on behalf of subjectToken
+	Exchange(ctx context.Context, subjectToken string, audience string) (Token, error)
+}
+
+// TokenExchangerFunc adapts a function to a TokenExchanger
+type TokenExchangerFunc func(ctx context.Context, subjectToken string, audience string) (Token, error)
+
+// Exchange calls f
+func (f TokenExchangerFunc) Exchange(ctx context.Context, subjectToken string, audience string) (Token, error) {
+	return f(ctx, subjectToken, audience)
+}
+
+// PropagationConfig describes what calls forward from the incoming request they are made on behalf of
+type PropagationConfig struct {
+	// Headers lists the captured headers forwarded to the service; others are never forwarded
+	Headers []string
+	// ForwardCredential forwards the Authorization header of the incoming request as is
+	ForwardCredential bool
+	// Exchanger, when set, exchanges the credential of the incoming request for a token for the service, which is
+	// sent instead
+	Exchanger TokenExchanger
+}
+
+// WithPropagation forwards what CaptureInbound or ContextWithInbound stored in the context of each call, as allowed by
+// cfg. Headers and credentials set by the caller are not replaced, and the credential takes precedence over WithAuth.
+// The credential is only sent to the host the client's ServiceFinder returns, never to another one reached through
+// CallBaseURL or an operation's location.
+// Calls carrying an inbound context skip the client's HTTP cache and last known good responses and are never coalesced,
+// so one caller's responses are never handed to another.
+func WithPropagation(cfg PropagationConfig) Option {
+	return func(c *client) {
+		c.propagation = &cfg
+	}
+}
+
+// propagates reports whether calls made with ctx forward what an incoming request carried
+func (c *client) propagates(ctx context.Context) bool {
+	if c.propagation == nil || ctx == nil {
+		return false
+	}
+	_, ok := InboundFromContext(ctx)
+
+	return ok
+}
+
+// propagationTransport forwards the inbound credential and allowed headers of a call's context
+type propagationTransport struct {
+	next     http.RoundTripper
+	cfg      PropagationConfig
+	audience string
+}
+
+type sentElsewhereKey struct{}
+
+// sentElsewhere returns a context whose call goes to a URL other than the service's, which never gets the inbound
+// credential. Calls are judged by where they were told to go rather than by asking the ServiceFinder again, whose
+// answer may change from one call to the next.
+func sentElsewhere(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sentElsewhereKey{}, true)
+}
+
+// forService reports whether req goes to the service the client calls, so may carry the inbound credential
+func forService(req *http.Request) bool {
+	elsewhere, _ := req.Context().Value(sentElsewhereKey{}).(bool)
+	return !elsewhere && !redirectedElsewhere(req)
+}
+
+func (t *propagationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	in, ok := InboundFromContext(req.Context())
+	if !ok || credentialsWithheld(req.Context()) {
+		return t.next.RoundTrip(req)
+	}
+
+	r := req.Clone(req.Context())
+	for _, h := range t.cfg.Headers {
+		if values := in.Headers.Values(h); len(values) > 0 && r.Header.Get(h) == "" {
+			r.Header[http.CanonicalHeaderKey(h)] = append([]string(nil), values...)
+		}
+	}
+
+	if in.Credential != "" && r.Header.Get("Authorization") == "" && forService(r) {
+		switch {
+		case t.cfg.Exchanger != nil:
+			tok, err := t.exchange(r.Context(), in.Credential)
+			if err != nil {
+				closeRequestBody(req)
+				return nil, err
+			}
+			r.Header.Set("Authorization", authorization(tok))
+		case t.cfg.ForwardCredential:
+			r.Header.Set("Authorization", in.Credential)
+		}
+	}
+
+	return t.next.RoundTrip(r)
+}
+
+// exchange obtains a token for the service on behalf of the inbound credential
+func (t *propagationTransport) exchange(ctx context.Context, credential string) (Token, error) {
+	_, subject := splitAuthorization(credential)
+	if subject == "" {
+		return Token{}, fmt.Errorf("%w: the inbound credential has no token to exchange", ErrAuthentication)
+	}
+
+	tok, err := t.cfg.Exchanger.Exchange(ctx, subject, t.audience)
+	if err != nil {
+		if ctx.Err() == nil && !errors.Is(err, ErrAuthentication) {
+			err = fmt.Errorf("%w: token exchange failed: %v", ErrAuthentication, err)
+		}
+		return Token{}, err
+	}
+
+	return tok, nil
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+func TestUnit_CaptureInbound(t *testing.T) {
+	var captured Inbound
+	var ok bool
+	handler := CaptureInbound("X-Tenant", "x-request-id")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured, ok = InboundFromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer user-token")
+	r.Header.Set("X-Tenant", "acme")
+	r.Header.Set("Cookie", "session=secret")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	require.True(t, ok)
+	require.Equal(t, "Bearer user-token", captured.Credential)
+	require.Equal(t, http.Header{"X-Tenant": []string{"acme"}}, captured.Headers)
+}
+
+func TestUnit_WithPropagation(t *testing.T) {
+	inbound := Inbound{
+		Credential: "Bearer user-token",
+		Headers:    http.Header{"X-Tenant": []string{"acme"}, "X-Debug": []string{"on"}},
+	}
+
+	tests := map[string]struct {
+		cfg       PropagationConfig
+		auth      Authenticator
+		inbound   *Inbound
+		headers   http.Header
+		otherHost bool
+		redirect  bool
+		validate  func(t *testing.T, r *http.Request, err glitch.DataError)
+	}{
+		"base path- allowed headers and credential forwarded": {
+			cfg:     PropagationConfig{Headers: []string{"x-tenant"}, ForwardCredential: true},
+			inbound: &inbound,
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "acme", r.Header.Get("X-Tenant"))
+				require.Empty(t, r.Header.Get("X-Debug"))
+				require.Equal(t, "Bearer user-token", r.Header.Get("Authorization"))
+			},
+		},
+		"base path- credential not forwarded unless asked": {
+			cfg:     PropagationConfig{Headers: []string{"X-Tenant"}},
+			inbound: &inbound,
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "acme", r.Header.Get("X-Tenant"))
+				require.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+		"base path- credential exchanged": {
+			cfg: PropagationConfig{
+				ForwardCredential: true,
+				Exchanger: TokenExchangerFunc(func(ctx context.Context, subjectToken string, audience string) (Token, error) {
+					return Token{AccessToken: subjectToken + "-for-" + audience}, nil
+				}),
+			},
+			inbound: &inbound,
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "Bearer user-token-for-foo", r.Header.Get("Authorization"))
+			},
+		},
+		"base path- propagated credential preferred to the client's": {
+			cfg:     PropagationConfig{ForwardCredential: true},
+			auth:    BearerToken("service-token"),
+			inbound: &inbound,
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "Bearer user-token", r.Header.Get("Authorization"))
+			},
+		},
+		"base path- caller's headers kept": {
+			cfg:     PropagationConfig{Headers: []string{"X-Tenant"}, ForwardCredential: true},
+			inbound: &inbound,
+			headers: http.Header{"X-Tenant": []string{"other"}, "Authorization": []string{"Bearer mine"}},
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "other", r.Header.Get("X-Tenant"))
+				require.Equal(t, "Bearer mine", r.Header.Get("Authorization"))
+			},
+		},
+		"base path- credential not forwarded to another host": {
+			cfg:       PropagationConfig{Headers: []string{"X-Tenant"}, ForwardCredential: true},
+			inbound:   &inbound,
+			otherHost: true,
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "acme", r.Header.Get("X-Tenant"))
+				require.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+		"base path- credential not forwarded on a redirect to another host": {
+			cfg:      PropagationConfig{ForwardCredential: true},
+			inbound:  &inbound,
+			redirect: true,
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "/moved", r.URL.Path)
+				require.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+		"base path- nothing captured": {
+			cfg: PropagationConfig{Headers: []string{"X-Tenant"}, ForwardCredential: true},
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Empty(t, r.Header.Get("X-Tenant"))
+				require.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+		"exceptional path- exchange fails": {
+			cfg: PropagationConfig{
+				Exchanger: TokenExchangerFunc(func(ctx context.Context, subjectToken string, audience string) (Token, error) {
+					return Token{}, errors.New("audience not allowed")
+				}),
+			},
+			inbound: &inbound,
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorAuthentication, err.Code())
+				require.Nil(t, r)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			received := make(chan *http.Request, 1)
+			var testServer *httptest.Server
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.redirect && r.URL.Path == "/1" {
+					// The same server under another name is another host as far as the client can tell
+					http.Redirect(w, r, strings.Replace(testServer.URL, "127.0.0.1", "localhost", 1)+"/moved", http.StatusFound)
+					return
+				}
+				received <- r.Clone(context.Background())
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			opts := []Option{WithPropagation(tc.cfg)}
+			if tc.auth != nil {
+				opts = append(opts, WithAuth(tc.auth))
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, opts...)
+
+			ctx := context.Background()
+			if tc.inbound != nil {
+				ctx = ContextWithInbound(ctx, *tc.inbound)
+			}
+			if tc.otherHost {
+				// The same server under another name is another host as far as the client can tell
+				u, err := url.Parse(strings.Replace(testServer.URL, "127.0.0.1", "localhost", 1))
+				require.NoError(t, err)
+				ctx = WithCallOptions(ctx, CallBaseURL(*u))
+			}
+			_, _, err := bc.MakeRequest(ctx, http.MethodGet, "/1", nil, tc.headers, nil)
+
+			var r *http.Request
+			select {
+			case r = <-received:
+			default:
+			}
+			tc.validate(t, r, err)
+		})
+	}
+}
+
+func TestUnit_WithPropagationRotatingFinder(t *testing.T) {
+	var mu sync.Mutex
+	var credentials []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		credentials = append(credentials, r.Header.Get("Authorization"))
+		mu.Unlock()
+	}))
+	defer testServer.Close()
+
+	// Instances of the service are found under alternating names, as a load-balancing ServiceFinder would return them
+	var found int32
+	finder := func(serviceName string, useTLS bool) (url.URL, error) {
+		raw := testServer.URL
+		if atomic.AddInt32(&found, 1)%2 == 0 {
+			raw = strings.Replace(raw, "127.0.0.1", "localhost", 1)
+		}
+		u, err := url.Parse(raw)
+		return *u, err
+	}
+	bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithPropagation(PropagationConfig{ForwardCredential: true}))
+
+	ctx := ContextWithInbound(context.Background(), Inbound{Credential: "Bearer user-token"})
+	for i := 0; i < 10; i++ {
+		_, _, err := bc.MakeRequest(ctx, http.MethodGet, "/1", nil, nil, nil)
+		require.Nil(t, err)
+	}
+
+	require.Len(t, credentials, 10)
+	for _, credential := range credentials {
+		require.Equal(t, "Bearer user-token", credential)
+	}
+}
+
+func TestUnit_WithPropagationSkipsCache(t *testing.T) {
+	var calls int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer testServer.Close()
+
+	finder := func(serviceName string, useTLS bool) (url.URL, error) {
+		u, err := url.Parse(testServer.URL)
+		return *u, err
+	}
+	bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil,
+		WithPropagation(PropagationConfig{ForwardCredential: true}), WithCache(NewHTTPCache(nil)))
+
+	for _, user := range []string{"Bearer alice", "Bearer bob"} {
+		_, ret, err := bc.MakeRequest(ContextWithInbound(context.Background(), Inbound{Credential: user}), http.MethodGet, "/1", nil, nil, nil)
+		require.Nil(t, err)
+		require.Equal(t, user, string(ret))
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// Calls made on the service's own behalf are still cached
+	for i := 0; i < 2; i++ {
+		_, _, err := bc.MakeRequest(context.Background(), http.MethodGet, "/1", nil, nil, nil)
+		require.Nil(t, err)
+	}
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// DefaultRequestIDHeader is the header carrying request IDs unless configured otherwise
+const DefaultRequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context whose calls send id as their request ID
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// CaptureRequestID is server middleware storing the request ID of each incoming request in its context, so calls made
+// with r.Context() send the same ID. Requests without one are given a new UUIDv7. The ID is echoed in the response.
+// header defaults to X-Request-ID.
+func CaptureRequestID(header string) func(http.Handler) http.Handler {
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if id == "" {
+				id, _ = newUUIDv7(time.Now())
+			}
+			if id != "" {
+				w.Header().Set(header, id)
+				r = r.WithContext(ContextWithRequestID(r.Context(), id))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequestIDConfig configures the request IDs sent with calls
+type RequestIDConfig struct {
+	// Header carries the ID; defaults to X-Request-ID
+	Header string
+	// Generate makes an ID for calls whose context carries none; defaults to a UUIDv7
+	Generate func() (string, error)
+}
+
+// WithRequestID sends a request ID with every call: the one passed in the call's headers, else the one stored in its
+// context, else a new one. The ID is kept for every retry and hedge of the call. It is recorded in the
+// ResponseMetadata of the call along with the ID the service echoed back, and errors returned by the call are a
+// *RequestIDError carrying both. A call sharing the request of another through WithRequestCoalescing keeps its own ID,
+// while the service echoes the ID of the shared request.
+func WithRequestID(cfg RequestIDConfig) Option {
+	return func(c *client) {
+		if cfg.Header == "" {
+			cfg.Header = DefaultRequestIDHeader
+		}
+		if cfg.Generate == nil {
+			cfg.Generate = func() (string, error) {
+				return newUUIDv7(time.Now())
+			}
+		}
+		c.requestIDs = &cfg
+	}
+}
+
+// RequestIDError is a glitch.DataError returned by a client configured WithRequestID, noting the request ID of the call
+// that failed. Use errors.As to get at it.
+type RequestIDError struct {
+	glitch.DataError
+	// RequestID is the ID sent with the call
+	RequestID string
+	// ResponseRequestID is the ID the service echoed back, if it answered with one
+	ResponseRequestID string
+}
+
+// Error describes the error along with the request IDs
+func (e *RequestIDError) Error() string {
+	s := fmt.Sprintf("%s Request ID: [%s]", e.DataError.Error(), e.RequestID)
+	if e.ResponseRequestID != "" && e.ResponseRequestID != e.RequestID {
+		s += fmt.Sprintf(" Response request ID: [%s]", e.ResponseRequestID)
+	}
+
+	return s
+}
+
+// Wrap sets the cause of the underlying error
+func (e *RequestIDError) Wrap(err glitch.DataError) glitch.DataError {
+	e.DataError = e.DataError.Wrap(err)
+	return e
+}
+
+// Unwrap returns the underlying error
+func (e *RequestIDError) Unwrap() error {
+	return e.DataError
+}
+
+// withRequestMetadata returns a context recording the response metadata the request ID of a call is noted in
+func (c *client) withRequestMetadata(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if c.requestIDs == nil || responseMetadataFrom(ctx) != nil {
+		return ctx
+	}
+
+	ctx, _ = ContextWithResponseMetadata(ctx)
+	return ctx
+}
+
+// requestIDHeader returns the headers of a call made with ctx including its request ID. The caller's headers are never
+// modified.
+func (c *client) requestIDHeader(ctx context.Context, headers http.Header) (http.Header, glitch.DataError) {
+	if c.requestIDs == nil {
+		return headers, nil
+	}
+
+	id := headers.Get(c.requestIDs.Header)
+	if id == "" {
+		var ok bool
+		if id, ok = RequestIDFromContext(ctx); !ok {
+			var err error
+			if id, err = c.requestIDs.Generate(); err != nil {
+				return nil, glitch.NewDataError(err, ErrorRequestCreation, "Error creating request ID")
+			}
+		}
+		headers = cloneHeader(headers)
+		headers.Set(c.requestIDs.Header, id)
+	}
+	if md := responseMetadataFrom(ctx); md != nil {
+		md.RequestID = id
+	}
+
+	return headers, nil
+}
+
+// recordRequestID notes the request ID echoed in resp, which may be nil, in the metadata of ctx
+func (c *client) recordRequestID(ctx context.Context, resp *http.Response) {
+	md := responseMetadataFrom(ctx)
+	if c.requestIDs == nil || md == nil {
+		return
+	}
+
+	md.ResponseRequestID = ""
+	if resp != nil {
+		md.ResponseRequestID = resp.Header.Get(c.requestIDs.Header)
+	}
+}
+
+// requestIDError notes the request IDs of the call made with ctx on err
+func (c *client) requestIDError(ctx context.Context, err glitch.DataError) glitch.DataError {
+	if err == nil || c.requestIDs == nil {
+		return err
+	}
+	if _, ok := err.(*RequestIDError); ok {
+		return err
+	}
+	md := responseMetadataFrom(ctx)
+	if md == nil || md.RequestID == "" {
+		return err
+	}
+
+	return &RequestIDError{DataError: err, RequestID: md.RequestID, ResponseRequestID: md.ResponseRequestID}
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+func TestUnit_CaptureRequestID(t *testing.T) {
+	tests := map[string]struct {
+		header   string
+		incoming http.Header
+		validate func(t *testing.T, id string, ok bool, w *httptest.ResponseRecorder)
+	}{
+		"base path- incoming ID kept": {
+			incoming: http.Header{"X-Request-Id": []string{"abc-123"}},
+			validate: func(t *testing.T, id string, ok bool, w *httptest.ResponseRecorder) {
+				require.True(t, ok)
+				require.Equal(t, "abc-123", id)
+				require.Equal(t, "abc-123", w.Header().Get(DefaultRequestIDHeader))
+			},
+		},
+		"base path- ID generated": {
+			validate: func(t *testing.T, id string, ok bool, w *httptest.ResponseRecorder) {
+				require.True(t, ok)
+				require.Regexp(t, regexp.MustCompile(`^[0-9a-f-]{36}$`), id)
+				require.Equal(t, id, w.Header().Get(DefaultRequestIDHeader))
+			},
+		},
+		"base path- configured header": {
+			header:   "X-Correlation-ID",
+			incoming: http.Header{"X-Correlation-Id": []string{"corr-1"}, "X-Request-Id": []string{"abc-123"}},
+			validate: func(t *testing.T, id string, ok bool, w *httptest.ResponseRecorder) {
+				require.True(t, ok)
+				require.Equal(t, "corr-1", id)
+				require.Equal(t, "corr-1", w.Header().Get("X-Correlation-ID"))
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var id string
+			var ok bool
+			handler := CaptureRequestID(tc.header)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id, ok = RequestIDFromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tc.incoming {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			tc.validate(t, id, ok, w)
+		})
+	}
+}
+
+func TestUnit_WithRequestID(t *testing.T) {
+	tests := map[string]struct {
+		cfg      RequestIDConfig
+		ctx      context.Context
+		headers  http.Header
+		echo     string
+		status   int
+		useDo    bool
+		validate func(t *testing.T, sent string, md *ResponseMetadata, err glitch.DataError)
+	}{
+		"base path- ID from the context sent": {
+			ctx:  ContextWithRequestID(context.Background(), "from-ctx"),
+			echo: "from-ctx",
+			validate: func(t *testing.T, sent string, md *ResponseMetadata, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "from-ctx", sent)
+				require.Equal(t, "from-ctx", md.RequestID)
+				require.Equal(t, "from-ctx", md.ResponseRequestID)
+			},
+		},
+		"base path- ID generated": {
+			validate: func(t *testing.T, sent string, md *ResponseMetadata, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7`), sent)
+				require.Equal(t, sent, md.RequestID)
+				require.Empty(t, md.ResponseRequestID)
+			},
+		},
+		"base path- caller's header kept": {
+			cfg:     RequestIDConfig{Header: "X-Correlation-ID"},
+			ctx:     ContextWithRequestID(context.Background(), "from-ctx"),
+			headers: http.Header{"X-Correlation-Id": []string{"mine"}},
+			validate: func(t *testing.T, sent string, md *ResponseMetadata, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "mine", sent)
+				require.Equal(t, "mine", md.RequestID)
+			},
+		},
+		"exceptional path- IDs recorded on the error": {
+			cfg:    RequestIDConfig{Generate: func() (string, error) { return "generated", nil }},
+			echo:   "downstream-1",
+			status: http.StatusNotFound,
+			useDo:  true,
+			validate: func(t *testing.T, sent string, md *ResponseMetadata, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, "generated", sent)
+				var idErr *RequestIDError
+				require.True(t, errors.As(err, &idErr))
+				require.Equal(t, "generated", idErr.RequestID)
+				require.Equal(t, "downstream-1", idErr.ResponseRequestID)
+				require.Contains(t, err.Error(), "Request ID: [generated] Response request ID: [downstream-1]")
+			},
+		},
+		"exceptional path- ID cannot be generated": {
+			cfg: RequestIDConfig{Generate: func() (string, error) { return "", errors.New("no entropy") }},
+			validate: func(t *testing.T, sent string, md *ResponseMetadata, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorRequestCreation, err.Code())
+				require.Empty(t, md.RequestID)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			header := tc.cfg.Header
+			if header == "" {
+				header = DefaultRequestIDHeader
+			}
+			received := make(chan string, 1)
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Header.Get(header)
+				if tc.echo != "" {
+					w.Header().Set(header, tc.echo)
+				}
+				if tc.status != 0 {
+					w.WriteHeader(tc.status)
+				}
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithRequestID(tc.cfg))
+
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			ctx, md := ContextWithResponseMetadata(ctx)
+
+			var err glitch.DataError
+			if tc.useDo {
+				response := map[string]string{}
+				err = bc.Do(ctx, http.MethodGet, "/1", nil, tc.headers, nil, &response)
+			} else {
+				_, _, err = bc.MakeRequest(ctx, http.MethodGet, "/1", nil, tc.headers, nil)
+			}
+
+			var sent string
+			select {
+			case sent = <-received:
+			default:
+			}
+			tc.validate(t, sent, md, err)
+		})
+	}
+}
+
+func TestUnit_WithRequestIDCoalesced(t *testing.T) {
+	release := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set(DefaultRequestIDHeader, r.Header.Get(DefaultRequestIDHeader))
+	}))
+	defer testServer.Close()
+
+	finder := func(serviceName string, useTLS bool) (url.URL, error) {
+		u, err := url.Parse(testServer.URL)
+		return *u, err
+	}
+	bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithRequestID(RequestIDConfig{}), WithRequestCoalescing())
+
+	mds := make(chan *ResponseMetadata, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ctx, md := ContextWithResponseMetadata(context.Background())
+			_, _, err := bc.MakeRequest(ctx, http.MethodGet, "/1", nil, nil, nil)
+			require.Nil(t, err)
+			mds <- md
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	// Each caller keeps its own ID, while both see the ID of the shared request echoed by the service
+	first, second := <-mds, <-mds
+	require.NotEmpty(t, first.RequestID)
+	require.NotEmpty(t, second.RequestID)
+	require.NotEqual(t, first.RequestID, second.RequestID)
+	require.Equal(t, first.ResponseRequestID, second.ResponseRequestID)
+	require.Contains(t, []string{first.RequestID, second.RequestID}, first.ResponseRequestID)
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetryAttempts  = 3
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 5 * time.Second
+)
+
+// defaultRetryableStatuses are the statuses that signal a request may succeed if tried again
+var defaultRetryableStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy configures retries of failed requests. Idempotent methods are always eligible for retries; other
+// methods, such as POST and PATCH, are only retried when the request carries an idempotency key.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the original; defaults to 3
+	MaxAttempts int
+	// BaseDelay is the starting delay for exponential backoff with full jitter; defaults to 100ms
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts. A Retry-After longer than this ends the retries. Defaults to 5s.
+	MaxDelay time.Duration
+	// RetryableStatuses are the response statuses worth retrying; defaults to 429, 502, 503, and 504
+	RetryableStatuses []int
+}
+
+// WithRetries retries requests that fail with a transport error or a retryable status
+func WithRetries(p RetryPolicy) Option {
+	return func(c *client) {
+		c.retries = &p
+	}
+}
+
+// retryTransport retries failed attempts of a request
+type retryTransport struct {
+	next      http.RoundTripper
+	policy    RetryPolicy
+	keyHeader string
+	statuses  map[int]bool
+}
+
+func newRetryTransport(next http.RoundTripper, p RetryPolicy, keyHeader string) *retryTransport {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultRetryAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultRetryBaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultRetryMaxDelay
+	}
+	if p.RetryableStatuses == nil {
+		p.RetryableStatuses = defaultRetryableStatuses
+	}
+
+	statuses := map[int]bool{}
+	for _, s := range p.RetryableStatuses {
+		statuses[s] = true
+	}
+
+	return &retryTransport{next: next, policy: p, keyHeader: keyHeader, statuses: statuses}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if o := callOptionsFrom(req.Context()); o != nil && o.retries != nil {
+		return newRetryTransport(t.next, *o.retries, t.keyHeader).roundTrip(req)
+	}
+
+	return t.roundTrip(req)
+}
+
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.policy.MaxAttempts <= 1 || !t.mayRetry(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	r := req
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(r)
+		if attempt >= t.policy.MaxAttempts || !t.retryable(req, resp, err) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				if retryAfter > t.policy.MaxDelay {
+					return resp, nil
+				}
+				delay = retryAfter
+			}
+		}
+
+		next, gErr := t.nextAttempt(req)
+		if gErr != nil {
+			return nil, replayError(gErr, resp, err)
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		r = next
+	}
+}
+
+// mayRetry reports whether req is safe to send more than once
+func (t *retryTransport) mayRetry(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	return idempotentMethods[req.Method] || req.Header.Get(t.keyHeader) != ""
+}
+
+// retryable reports whether the outcome of an attempt is worth retrying
+func (t *retryTransport) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, ErrLimitExceeded) && !errors.Is(err, ErrDeadlineBudgetExceeded) && !errors.Is(err, ErrAuthentication) &&
+			!errors.Is(err, ErrSigning)
+	}
+
+	return t.statuses[resp.StatusCode]
+}
+
+// nextAttempt copies req, with a fresh body, for another attempt
+func (t *retryTransport) nextAttempt(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	return r, nil
+}
+
+// backoff returns the delay before the attempt following attempt, using exponential backoff with full jitter
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	ceiling := t.policy.BaseDelay << uint(attempt-1)
+	if ceiling <= 0 || ceiling > t.policy.MaxDelay {
+		ceiling = t.policy.MaxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	when, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+
+	d := time.Until(when)
+	if d < 0 {
+		d = 0
+	}
+
+	return d, true
+}
+
+// replayError reports that a failed attempt could not be retried because its body could not be produced again
+func replayError(gErr error, resp *http.Response, err error) error {
+	cause := err
+	if resp != nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		cause = fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	if errors.Is(gErr, ErrBodyNotReplayable) {
+		return fmt.Errorf("%w: could not retry after %v", ErrBodyNotReplayable, cause)
+	}
+
+	return fmt.Errorf("could not retry after %v: %w", cause, gErr)
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_WithRetries(t *testing.T) {
+	type attempt struct {
+		key  string
+		body string
+	}
+
+	tests := map[string]struct {
+		method    string
+		headers   http.Header
+		body      func() io.Reader
+		policy    RetryPolicy
+		opts      []Option
+		responses []int
+		header    http.Header
+		validate  func(t *testing.T, status int, attempts []attempt)
+	}{
+		"base path- idempotent request retried until it succeeds": {
+			method:    "GET",
+			responses: []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK},
+			validate: func(t *testing.T, status int, attempts []attempt) {
+				require.Equal(t, http.StatusOK, status)
+				require.Len(t, attempts, 3)
+			},
+		},
+		"base path- gives up after the maximum attempts": {
+			method:    "GET",
+			policy:    RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+			responses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK},
+			validate: func(t *testing.T, status int, attempts []attempt) {
+				require.Equal(t, http.StatusServiceUnavailable, status)
+				require.Len(t, attempts, 2)
+			},
+		},
+		"base path- non-retryable status returned immediately": {
+			method:    "GET",
+			responses: []int{http.StatusBadRequest, http.StatusOK},
+			validate: func(t *testing.T, status int, attempts []attempt) {
+				require.Equal(t, http.StatusBadRequest, status)
+				require.Len(t, attempts, 1)
+			},
+		},
+		"base path- POST without an idempotency key is not retried": {
+			method:    "POST",
+			body:      func() io.Reader { return bytes.NewBufferString(`{"amount":1}`) },
+			responses: []int{http.StatusServiceUnavailable, http.StatusOK},
+			validate: func(t *testing.T, status int, attempts []attempt) {
+				require.Equal(t, http.StatusServiceUnavailable, status)
+				require.Len(t, attempts, 1)
+			},
+		},
+		"base path- POST with an idempotency key retried with the same key and body": {
+			method:    "POST",
+			body:      func() io.Reader { return bytes.NewBufferString(`{"amount":1}`) },
+			opts:      []Option{WithIdempotencyKeys(IdempotencyConfig{})},
+			responses: []int{http.StatusServiceUnavailable, http.StatusOK},
+			validate: func(t *testing.T, status int, attempts []attempt) {
+				require.Equal(t, http.StatusOK, status)
+				require.Len(t, attempts, 2)
+				require.NotEmpty(t, attempts[0].key)
+				require.Equal(t, attempts[0], attempts[1])
+				require.Equal(t, `{"amount":1}`, attempts[1].body)
+			},
+		},
+		"base path- caller supplied key enables retries": {
+			method:    "PATCH",
+			headers:   http.Header{"Idempotency-Key": []string{"abc"}},
+			responses: []int{http.StatusTooManyRequests, http.StatusOK},
+			validate: func(t *testing.T, status int, attempts []attempt) {
+				require.Equal(t, http.StatusOK, status)
+				require.Equal(t, []attempt{{key: "abc"}, {key: "abc"}}, attempts)
+			},
+		},
+		"exceptional path- Retry-After beyond the maximum delay ends retries": {
+			method:    "GET",
+			policy:    RetryPolicy{MaxDelay: time.Second},
+			header:    http.Header{"Retry-After": []string{"120"}},
+			responses: []int{http.StatusServiceUnavailable, http.StatusOK},
+			validate: func(t *testing.T, status int, attempts []attempt) {
+				require.Equal(t, http.StatusServiceUnavailable, status)
+				require.Len(t, attempts, 1)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			var attempts []attempt
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				by, _ := ioutil.ReadAll(r.Body)
+				mu.Lock()
+				attempts = append(attempts, attempt{key: r.Header.Get("Idempotency-Key"), body: string(by)})
+				status := tc.responses[len(attempts)-1]
+				mu.Unlock()
+
+				for k, v := range tc.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(status)
+				_, _ = fmt.Fprintf(w, `{}`)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			if tc.policy.BaseDelay == 0 {
+				tc.policy.BaseDelay = time.Millisecond
+			}
+			opts := append([]Option{WithRetries(tc.policy)}, tc.opts...)
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, opts...)
+
+			var body io.Reader
+			if tc.body != nil {
+				body = tc.body()
+			}
+			status, _, err := bc.MakeRequest(context.Background(), tc.method, "1", nil, tc.headers, body)
+			require.NoError(t, err)
+			tc.validate(t, status, attempts)
+		})
+	}
+}
+
+func TestUnit_parseRetryAfter(t *testing.T) {
+	tests := map[string]struct {
+		value       string
+		expectedOK  bool
+		expectedMin time.Duration
+		expectedMax time.Duration
+	}{
+		"base path- seconds": {
+			value:       "3",
+			expectedOK:  true,
+			expectedMin: 3 * time.Second,
+			expectedMax: 3 * time.Second,
+		},
+		"base path- HTTP date": {
+			value:       time.Now().Add(time.Minute).UTC().Format(http.TimeFormat),
+			expectedOK:  true,
+			expectedMin: 58 * time.Second,
+			expectedMax: time.Minute,
+		},
+		"base path- date in the past": {
+			value:      time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat),
+			expectedOK: true,
+		},
+		"exceptional path- empty": {
+			value: "",
+		},
+		"exceptional path- garbage": {
+			value: "soon",
+		},
+		"exceptional path- negative seconds": {
+			value: "-1",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, ok := parseRetryAfter(tc.value)
+			require.Equal(t, tc.expectedOK, ok)
+			require.GreaterOrEqual(t, int64(d), int64(tc.expectedMin))
+			require.LessOrEqual(t, int64(d), int64(tc.expectedMax))
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package client
+
+import "strings"
+
+// matchRoute reports whether slug matches a route template such as /v1/users/{id}, where each {name} segment
+// matches any single path segment. Leading and trailing slashes are ignored.
+func matchRoute(template string, slug string) bool {
+	tParts := strings.Split(strings.Trim(template, "/"), "/")
+	sParts := strings.Split(strings.Trim(slug, "/"), "/")
+	if len(tParts) != len(sParts) {
+		return false
+	}
+
+	for i, part := range tParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") && sParts[i] != "" {
+			continue
+		}
+		if part != sParts[i] {
+			return false
+		}
+	}
+
+	return true
+}
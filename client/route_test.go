@@ -0,0 +1,47 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_matchRoute(t *testing.T) {
+	tests := map[string]struct {
+		template string
+		slug     string
+		expected bool
+	}{
+		"base path- literal route": {
+			template: "/v1/users",
+			slug:     "v1/users",
+			expected: true,
+		},
+		"base path- parameter segment": {
+			template: "/v1/users/{id}",
+			slug:     "/v1/users/42/",
+			expected: true,
+		},
+		"base path- different literal": {
+			template: "/v1/users/{id}",
+			slug:     "/v1/groups/42",
+			expected: false,
+		},
+		"base path- different segment count": {
+			template: "/v1/users/{id}",
+			slug:     "/v1/users/42/settings",
+			expected: false,
+		},
+		"exceptional path- parameter does not match an empty segment": {
+			template: "/v1/users/{id}/settings",
+			slug:     "/v1/users//settings",
+			expected: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, matchRoute(tc.template, tc.slug))
+		})
+	}
+}
@@ -0,0 +1,769 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSignatureLabel = "sig1"
+	// signatureClockSkew is how far in the future a signature may have been created, allowing for clocks out of step
+	signatureClockSkew = time.Minute
+)
+
+var (
+	// ErrSigning is returned when a request cannot be signed
+	ErrSigning = errors.New("could not sign the request")
+	// ErrInvalidSignature is returned when a response is not signed as VerificationConfig requires, or its body does
+	// not match the Content-Digest it was signed with
+	ErrInvalidSignature = errors.New("invalid response signature")
+)
+
+// Signature algorithms of the RFC 9421 HTTP Signature Algorithms registry
+const (
+	SignatureHMACSHA256      = "hmac-sha256"
+	SignatureEd25519         = "ed25519"
+	SignatureECDSAP256SHA256 = "ecdsa-p256-sha256"
+)
+
+// SigningKey signs HTTP messages
+type SigningKey interface {
+	// KeyID is sent as the keyid parameter of the signature
+	KeyID() string
+	// Algorithm is the RFC 9421 name of the signature algorithm, such as SignatureEd25519
+	Algorithm() string
+	// Sign signs the signature base
+	Sign(base []byte) ([]byte, error)
+}
+
+// VerifyingKey verifies signatures of HTTP messages
+type VerifyingKey interface {
+	// KeyID is matched against the keyid parameter of signatures
+	KeyID() string
+	// Algorithm is the RFC 9421 name of the signature algorithm, such as SignatureEd25519
+	Algorithm() string
+	// Verify returns an error unless sig is a signature of the signature base
+	Verify(base []byte, sig []byte) error
+}
+
+// HMACKey is a shared secret that both signs and verifies with HMAC-SHA256
+type HMACKey struct {
+	id     string
+	secret []byte
+}
+
+// NewHMACSHA256Key creates an HMAC-SHA256 key identified by keyID
+func NewHMACSHA256Key(keyID string, secret []byte) *HMACKey {
+	return &HMACKey{id: keyID, secret: secret}
+}
+
+// KeyID identifies the key
+func (k *HMACKey) KeyID() string {
+	return k.id
+}
+
+// Algorithm is SignatureHMACSHA256
+func (k *HMACKey) Algorithm() string {
+	return SignatureHMACSHA256
+}
+
+// Sign computes the HMAC of base
+func (k *HMACKey) Sign(base []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k.secret)
+	_, _ = mac.Write(base)
+	return mac.Sum(nil), nil
+}
+
+// Verify checks sig is the HMAC of base
+func (k *HMACKey) Verify(base []byte, sig []byte) error {
+	want, _ := k.Sign(base)
+	if !hmac.Equal(want, sig) {
+		return errors.New("HMAC mismatch")
+	}
+
+	return nil
+}
+
+// NewEd25519SigningKey creates a signing key for the Ed25519 private key key
+func NewEd25519SigningKey(keyID string, key ed25519.PrivateKey) SigningKey {
+	return ed25519Key{id: keyID, private: key}
+}
+
+// NewEd25519VerifyingKey creates a verifying key for the Ed25519 public key key
+func NewEd25519VerifyingKey(keyID string, key ed25519.PublicKey) VerifyingKey {
+	return ed25519Key{id: keyID, public: key}
+}
+
+type ed25519Key struct {
+	id      string
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+func (k ed25519Key) KeyID() string {
+	return k.id
+}
+
+func (ed25519Key) Algorithm() string {
+	return SignatureEd25519
+}
+
+func (k ed25519Key) Sign(base []byte) ([]byte, error) {
+	return ed25519.Sign(k.private, base), nil
+}
+
+func (k ed25519Key) Verify(base []byte, sig []byte) error {
+	if !ed25519.Verify(k.public, base, sig) {
+		return errors.New("Ed25519 signature mismatch")
+	}
+
+	return nil
+}
+
+// NewECDSAP256SigningKey creates a signing key for the ECDSA P-256 private key key
+func NewECDSAP256SigningKey(keyID string, key *ecdsa.PrivateKey) SigningKey {
+	return ecdsaKey{id: keyID, private: key}
+}
+
+// NewECDSAP256VerifyingKey creates a verifying key for the ECDSA P-256 public key key
+func NewECDSAP256VerifyingKey(keyID string, key *ecdsa.PublicKey) VerifyingKey {
+	return ecdsaKey{id: keyID, public: key}
+}
+
+type ecdsaKey struct {
+	id      string
+	private *ecdsa.PrivateKey
+	public  *ecdsa.PublicKey
+}
+
+func (k ecdsaKey) KeyID() string {
+	return k.id
+}
+
+func (ecdsaKey) Algorithm() string {
+	return SignatureECDSAP256SHA256
+}
+
+// Sign signs base, encoding the signature as the 64 bytes of r and s RFC 9421 calls for rather than ASN.1
+func (k ecdsaKey) Sign(base []byte) ([]byte, error) {
+	if k.private.Curve != elliptic.P256() {
+		return nil, errors.New("the ECDSA key is not on the P-256 curve")
+	}
+
+	digest := sha256.Sum256(base)
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return sig, nil
+}
+
+func (k ecdsaKey) Verify(base []byte, sig []byte) error {
+	if len(sig) != 64 {
+		return fmt.Errorf("ECDSA signature is %d bytes, expected 64", len(sig))
+	}
+
+	digest := sha256.Sum256(base)
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(k.public, digest[:], r, s) {
+		return errors.New("ECDSA signature mismatch")
+	}
+
+	return nil
+}
+
+// SigningConfig configures RFC 9421 signatures of requests
+type SigningConfig struct {
+	// Key signs the requests
+	Key SigningKey
+	// Label names the signature in the Signature and Signature-Input headers; defaults to sig1
+	Label string
+	// Components are what the signature covers, in order: derived components such as @method, @target-uri,
+	// @authority, @scheme, @request-target, @path, and @query, and lowercase header names. content-digest is left out
+	// of requests without a body. Defaults to @method, @target-uri, and content-digest.
+	Components []string
+	// DigestAlgorithm computes the Content-Digest of request bodies, sha-256 or sha-512; defaults to sha-256
+	DigestAlgorithm string
+	// Expires, when set, is how long signatures are valid for
+	Expires time.Duration
+	// Nonce, when set, provides a nonce for each signature
+	Nonce func() string
+	// Tag, when set, is sent as the tag parameter naming the application of the signature
+	Tag string
+}
+
+// WithSigning signs requests with RFC 9421 HTTP Message Signatures, adding a Content-Digest header for bodies unless
+// the caller set one. Every attempt is signed as it is sent, after any credentials and compression are applied.
+// Bodies that cannot be read twice are buffered in memory to compute their digest.
+func WithSigning(cfg SigningConfig) Option {
+	return func(c *client) {
+		c.signing = &cfg
+	}
+}
+
+// VerificationConfig configures verification of RFC 9421 signatures of responses
+type VerificationConfig struct {
+	// Keys verify the signatures, chosen by the keyid parameter. A signature without a keyid is verified with the
+	// only key when there is one.
+	Keys []VerifyingKey
+	// Label is the signature verified; defaults to the first one in Signature-Input
+	Label string
+	// Required are the components the signature must cover; content-digest is waived for responses without a body.
+	// Defaults to @status and content-digest.
+	Required []string
+	// MaxAge, when set, rejects signatures created longer ago than this. Signatures created more than a minute in the
+	// future are always rejected.
+	MaxAge time.Duration
+}
+
+// WithSignatureVerification verifies the RFC 9421 signature of every response, failing calls with
+// ErrorInvalidSignature when it is missing or does not match. When the signature covers Content-Digest, the body is
+// checked against it as it is read.
+func WithSignatureVerification(cfg VerificationConfig) Option {
+	return func(c *client) {
+		c.verification = &cfg
+	}
+}
+
+// SignRequest signs req in place as WithSigning does, computing its Content-Digest from req.GetBody when it is set
+// and buffering the body otherwise
+func SignRequest(req *http.Request, cfg SigningConfig) error {
+	return signRequest(req, cfg, time.Now())
+}
+
+// VerifyResponse verifies the signature of resp as WithSignatureVerification does. When the signature covers
+// Content-Digest, resp.Body is replaced by one failing with ErrInvalidSignature at its end if the body does not match.
+func VerifyResponse(resp *http.Response, cfg VerificationConfig) error {
+	return verifyResponse(resp, cfg, time.Now())
+}
+
+// signingTransport signs each request it sends
+type signingTransport struct {
+	next http.RoundTripper
+	cfg  SigningConfig
+	now  func() time.Time
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if credentialsWithheld(req.Context()) || redirectedElsewhere(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	r := req.Clone(req.Context())
+	if err := signRequest(r, t.cfg, t.now()); err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	return t.next.RoundTrip(r)
+}
+
+// verifyingTransport verifies the signature of each response it receives
+type verifyingTransport struct {
+	next http.RoundTripper
+	cfg  VerificationConfig
+	now  func() time.Time
+}
+
+func (t *verifyingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyResponse(resp, t.cfg, t.now()); err != nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// signedMessage is what signature components are drawn from, for both requests and responses
+type signedMessage struct {
+	method string
+	url    *url.URL
+	host   string
+	status int
+	header http.Header
+}
+
+func requestMessage(req *http.Request) signedMessage {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	return signedMessage{method: req.Method, url: req.URL, host: host, header: req.Header}
+}
+
+func signRequest(req *http.Request, cfg SigningConfig, now time.Time) error {
+	if cfg.Key == nil {
+		return fmt.Errorf("%w: no signing key", ErrSigning)
+	}
+	label := cfg.Label
+	if label == "" {
+		label = defaultSignatureLabel
+	}
+	components := cfg.Components
+	if components == nil {
+		components = []string{"@method", "@target-uri", "content-digest"}
+	}
+
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	var covered []string
+	for _, c := range components {
+		c = strings.ToLower(c)
+		if c == "content-digest" && !hasBody {
+			continue
+		}
+		covered = append(covered, c)
+	}
+
+	if hasBody && req.Header.Get("Content-Digest") == "" && containsString(covered, "content-digest") {
+		digest, err := digestRequestBody(req, cfg.DigestAlgorithm)
+		if err != nil {
+			return fmt.Errorf("%w: computing the Content-Digest: %v", ErrSigning, err)
+		}
+		req.Header = cloneHeader(req.Header)
+		req.Header.Set("Content-Digest", digest)
+	}
+
+	params := signatureParams(covered, cfg, now)
+	base, err := signatureBase(requestMessage(req), covered, params)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSigning, err)
+	}
+	sig, err := cfg.Key.Sign([]byte(base))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSigning, err)
+	}
+
+	req.Header = cloneHeader(req.Header)
+	req.Header.Set("Signature-Input", label+"="+params)
+	req.Header.Set("Signature", label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+
+	return nil
+}
+
+// digestRequestBody returns the Content-Digest of the body of req. A replayable body is read from a copy, and the
+// request sent with a fresh copy so progress is reported from the start; any other body is buffered in memory.
+func digestRequestBody(req *http.Request, algorithm string) (string, error) {
+	if algorithm == "" {
+		algorithm = "sha-256"
+	}
+	newHash, ok := digestAlgorithms[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	h := newHash()
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			_, err = io.Copy(h, body)
+			body.Close()
+			if err != nil {
+				return "", err
+			}
+			fresh, err := req.GetBody()
+			if err != nil {
+				return "", err
+			}
+			req.Body.Close()
+			req.Body = fresh
+
+			return algorithm + "=:" + base64.StdEncoding.EncodeToString(h.Sum(nil)) + ":", nil
+		}
+	}
+
+	by, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	_, _ = h.Write(by)
+	setBytesBody(req, by)
+
+	return algorithm + "=:" + base64.StdEncoding.EncodeToString(h.Sum(nil)) + ":", nil
+}
+
+// signatureParams serializes the @signature-params of a signature covering components
+func signatureParams(components []string, cfg SigningConfig, now time.Time) string {
+	quoted := make([]string, len(components))
+	for i, c := range components {
+		quoted[i] = strconv.Quote(c)
+	}
+
+	var b strings.Builder
+	b.WriteString("(" + strings.Join(quoted, " ") + ")")
+	b.WriteString(";created=" + strconv.FormatInt(now.Unix(), 10))
+	if cfg.Expires > 0 {
+		b.WriteString(";expires=" + strconv.FormatInt(now.Add(cfg.Expires).Unix(), 10))
+	}
+	if cfg.Nonce != nil {
+		b.WriteString(";nonce=" + strconv.Quote(cfg.Nonce()))
+	}
+	b.WriteString(";keyid=" + strconv.Quote(cfg.Key.KeyID()))
+	b.WriteString(";alg=" + strconv.Quote(cfg.Key.Algorithm()))
+	if cfg.Tag != "" {
+		b.WriteString(";tag=" + strconv.Quote(cfg.Tag))
+	}
+
+	return b.String()
+}
+
+// signatureBase builds the RFC 9421 signature base of m covering components, ending with the serialized params
+func signatureBase(m signedMessage, components []string, params string) (string, error) {
+	var b strings.Builder
+	for _, c := range components {
+		v, err := componentValue(m, c)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(strconv.Quote(c) + ": " + v + "\n")
+	}
+	b.WriteString(`"@signature-params": ` + params)
+
+	return b.String(), nil
+}
+
+// componentValue returns the value of the component name of m
+func componentValue(m signedMessage, name string) (string, error) {
+	if !strings.HasPrefix(name, "@") {
+		header := m.header.Values(name)
+		if len(header) == 0 {
+			return "", fmt.Errorf("the message has no %s header to sign", name)
+		}
+		// Values shares its slice with the header, which must be sent as it is
+		values := make([]string, len(header))
+		for i, v := range header {
+			values[i] = strings.TrimSpace(v)
+		}
+		return strings.Join(values, ", "), nil
+	}
+
+	if name == "@status" {
+		if m.status == 0 {
+			return "", errors.New("@status only applies to responses")
+		}
+		return strconv.Itoa(m.status), nil
+	}
+	if m.url == nil {
+		return "", fmt.Errorf("%s only applies to requests", name)
+	}
+
+	switch name {
+	case "@method":
+		return strings.ToUpper(m.method), nil
+	case "@target-uri":
+		return m.url.String(), nil
+	case "@authority":
+		return authority(m.url.Scheme, m.host), nil
+	case "@scheme":
+		return strings.ToLower(m.url.Scheme), nil
+	case "@request-target":
+		return m.url.RequestURI(), nil
+	case "@path":
+		if p := m.url.EscapedPath(); p != "" {
+			return p, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + m.url.RawQuery, nil
+	}
+
+	return "", fmt.Errorf("unsupported component %s", name)
+}
+
+// authority returns host in lowercase without the default port of scheme
+func authority(scheme string, host string) string {
+	host = strings.ToLower(host)
+	switch {
+	case strings.EqualFold(scheme, "http") && strings.HasSuffix(host, ":80"):
+		return strings.TrimSuffix(host, ":80")
+	case strings.EqualFold(scheme, "https") && strings.HasSuffix(host, ":443"):
+		return strings.TrimSuffix(host, ":443")
+	}
+
+	return host
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func verifyResponse(resp *http.Response, cfg VerificationConfig, now time.Time) error {
+	inputs := parseSignatureDictionary(strings.Join(resp.Header.Values("Signature-Input"), ","))
+	if len(inputs) == 0 {
+		return fmt.Errorf("%w: the response is not signed", ErrInvalidSignature)
+	}
+	label := cfg.Label
+	if label == "" {
+		label = inputs[0].label
+	}
+	var params string
+	for _, in := range inputs {
+		if in.label == label {
+			params = in.value
+			break
+		}
+	}
+	if params == "" {
+		return fmt.Errorf("%w: the response has no signature %s", ErrInvalidSignature, label)
+	}
+
+	sig, err := signatureValue(resp.Header.Values("Signature"), label)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	components, sp, err := parseSignatureParams(params)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	key, err := verifyingKey(cfg.Keys, sp)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if err := checkSignatureTimes(sp, cfg.MaxAge, now); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	required := cfg.Required
+	if required == nil {
+		required = []string{"@status", "content-digest"}
+	}
+	hasBody := resp.Body != nil && resp.Body != http.NoBody && resp.ContentLength != 0
+	for _, r := range required {
+		r = strings.ToLower(r)
+		if r == "content-digest" && !hasBody {
+			continue
+		}
+		if !containsString(components, r) {
+			return fmt.Errorf("%w: the signature does not cover %s", ErrInvalidSignature, r)
+		}
+	}
+
+	base, err := signatureBase(signedMessage{status: resp.StatusCode, header: resp.Header}, components, params)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if err := key.Verify([]byte(base), sig); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	if containsString(components, "content-digest") && resp.Body != nil {
+		checks, err := digestChecks(resp.Header, "Content-Digest")
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+		if len(checks) == 0 {
+			return fmt.Errorf("%w: the Content-Digest uses no supported algorithm", ErrInvalidSignature)
+		}
+		resp.Body = &digestVerifyingBody{ReadCloser: resp.Body, checks: checks}
+	}
+
+	return nil
+}
+
+// verifyingKey picks the key of keys the signature with the parameters sp was made with
+func verifyingKey(keys []VerifyingKey, sp map[string]string) (VerifyingKey, error) {
+	keyID, hasID := sp["keyid"]
+	var key VerifyingKey
+	switch {
+	case hasID:
+		for _, k := range keys {
+			if k.KeyID() == keyID {
+				key = k
+				break
+			}
+		}
+	case len(keys) == 1:
+		key = keys[0]
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no key for keyid %q", keyID)
+	}
+	if alg, ok := sp["alg"]; ok && alg != key.Algorithm() {
+		return nil, fmt.Errorf("signed with %s, but key %q is %s", alg, keyID, key.Algorithm())
+	}
+
+	return key, nil
+}
+
+// checkSignatureTimes rejects signatures that have expired, were created in the future beyond signatureClockSkew, or,
+// when maxAge is set, were created too long ago
+func checkSignatureTimes(sp map[string]string, maxAge time.Duration, now time.Time) error {
+	if v, ok := sp["expires"]; ok {
+		expires, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid expires %q", v)
+		}
+		if now.Unix() > expires {
+			return errors.New("the signature has expired")
+		}
+	}
+
+	v, ok := sp["created"]
+	if !ok {
+		if maxAge > 0 {
+			return errors.New("the signature has no created time")
+		}
+		return nil
+	}
+	unix, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid created %q", v)
+	}
+	created := time.Unix(unix, 0)
+	if created.Sub(now) > signatureClockSkew {
+		return errors.New("the signature was created in the future")
+	}
+	if maxAge > 0 && now.Sub(created) > maxAge {
+		return errors.New("the signature is too old")
+	}
+
+	return nil
+}
+
+type dictionaryMember struct {
+	label string
+	value string
+}
+
+// parseSignatureDictionary splits a Signature-Input or Signature header into its members, keeping each value as
+// sent since a Signature-Input value is signed exactly as it was serialized
+func parseSignatureDictionary(v string) []dictionaryMember {
+	var members []dictionaryMember
+	depth, quoted, start := 0, false, 0
+	for i := 0; i <= len(v); i++ {
+		if i < len(v) {
+			switch c := v[i]; {
+			case quoted && c == '\\':
+				i++
+				continue
+			case c == '"':
+				quoted = !quoted
+				continue
+			case quoted:
+				continue
+			case c == '(':
+				depth++
+				continue
+			case c == ')':
+				depth--
+				continue
+			case c != ',' || depth > 0:
+				continue
+			}
+		}
+
+		member := strings.TrimSpace(v[start:i])
+		start = i + 1
+		if eq := strings.IndexByte(member, '='); eq > 0 {
+			members = append(members, dictionaryMember{label: member[:eq], value: member[eq+1:]})
+		}
+	}
+
+	return members
+}
+
+// signatureValue returns the signature labeled label from the Signature header values
+func signatureValue(values []string, label string) ([]byte, error) {
+	for _, m := range parseSignatureDictionary(strings.Join(values, ",")) {
+		if m.label != label {
+			continue
+		}
+		if len(m.value) < 2 || m.value[0] != ':' || m.value[len(m.value)-1] != ':' {
+			return nil, fmt.Errorf("invalid signature %s", label)
+		}
+		return base64.StdEncoding.DecodeString(m.value[1 : len(m.value)-1])
+	}
+
+	return nil, fmt.Errorf("the response has no signature %s", label)
+}
+
+// parseSignatureParams reads the components and parameters of a Signature-Input value such as
+// ("@status" "content-digest");created=1618884473;keyid="key"
+func parseSignatureParams(v string) ([]string, map[string]string, error) {
+	end := strings.IndexByte(v, ')')
+	if !strings.HasPrefix(v, "(") || end < 0 {
+		return nil, nil, fmt.Errorf("invalid Signature-Input %q", v)
+	}
+
+	var components []string
+	for _, item := range strings.Fields(v[1:end]) {
+		c, err := strconv.Unquote(item)
+		if err != nil || strings.Contains(c, ";") {
+			return nil, nil, fmt.Errorf("unsupported component %s", item)
+		}
+		components = append(components, c)
+	}
+
+	params := map[string]string{}
+	for _, p := range strings.Split(v[end+1:], ";") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		value := "true"
+		if len(kv) == 2 {
+			value = kv[1]
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+		}
+		params[kv[0]] = value
+	}
+
+	return components, params, nil
+}
+
+// digestVerifyingBody checks a response body against its Content-Digest once it is read to the end
+type digestVerifyingBody struct {
+	io.ReadCloser
+	checks []digestCheck
+}
+
+func (b *digestVerifyingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	for _, c := range b.checks {
+		_, _ = c.h.Write(p[:n])
+	}
+	if err == io.EOF {
+		for _, c := range b.checks {
+			if got := c.h.Sum(nil); !bytes.Equal(got, c.want) {
+				return n, fmt.Errorf("%w: the body does not match its %s", ErrInvalidSignature, c.name)
+			}
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,383 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+func TestUnit_signatureBase(t *testing.T) {
+	u, err := url.Parse("https://Example.com:443/foo/bar?a=1&b=2")
+	require.NoError(t, err)
+	m := signedMessage{method: "post", url: u, host: u.Host, header: http.Header{
+		"Content-Type": []string{"application/json"},
+		"X-Multi":      []string{" one ", "two"},
+	}}
+
+	base, err := signatureBase(m, []string{"@method", "@target-uri", "@authority", "@scheme", "@request-target", "@path", "@query", "content-type", "x-multi"}, `("@method");created=1`)
+	require.NoError(t, err)
+	require.Equal(t, strings.Join([]string{
+		`"@method": POST`,
+		`"@target-uri": https://Example.com:443/foo/bar?a=1&b=2`,
+		`"@authority": example.com`,
+		`"@scheme": https`,
+		`"@request-target": /foo/bar?a=1&b=2`,
+		`"@path": /foo/bar`,
+		`"@query": ?a=1&b=2`,
+		`"content-type": application/json`,
+		`"x-multi": one, two`,
+		`"@signature-params": ("@method");created=1`,
+	}, "\n"), base)
+	require.Equal(t, []string{" one ", "two"}, m.header["X-Multi"])
+
+	_, err = signatureBase(m, []string{"date"}, "()")
+	require.Error(t, err)
+	_, err = signatureBase(m, []string{"@status"}, "()")
+	require.Error(t, err)
+}
+
+func TestUnit_parseSignatureDictionary(t *testing.T) {
+	members := parseSignatureDictionary(`sig1=("@status" "content-digest");created=1;keyid="a,b", sig2=("@status");tag="x"`)
+	require.Equal(t, []dictionaryMember{
+		{label: "sig1", value: `("@status" "content-digest");created=1;keyid="a,b"`},
+		{label: "sig2", value: `("@status");tag="x"`},
+	}, members)
+
+	components, params, err := parseSignatureParams(members[0].value)
+	require.NoError(t, err)
+	require.Equal(t, []string{"@status", "content-digest"}, components)
+	require.Equal(t, map[string]string{"created": "1", "keyid": "a,b"}, params)
+}
+
+func TestUnit_WithSigning(t *testing.T) {
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	hmacKey := NewHMACSHA256Key("shared", []byte("secret"))
+
+	tests := map[string]struct {
+		cfg      SigningConfig
+		method   string
+		body     string
+		verifier VerifyingKey
+		validate func(t *testing.T, r *http.Request, components []string, params map[string]string)
+	}{
+		"base path- HMAC signed body": {
+			cfg:      SigningConfig{Key: hmacKey},
+			method:   http.MethodPost,
+			body:     `{"name":"value"}`,
+			verifier: hmacKey,
+			validate: func(t *testing.T, r *http.Request, components []string, params map[string]string) {
+				sum := sha256.Sum256([]byte(`{"name":"value"}`))
+				require.Equal(t, "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":", r.Header.Get("Content-Digest"))
+				require.Equal(t, []string{"@method", "@target-uri", "content-digest"}, components)
+				require.Equal(t, "shared", params["keyid"])
+				require.Equal(t, SignatureHMACSHA256, params["alg"])
+			},
+		},
+		"base path- Ed25519 request without a body": {
+			cfg:      SigningConfig{Key: NewEd25519SigningKey("ed", edPrivate), Label: "partner", Tag: "app", Expires: time.Minute},
+			method:   http.MethodGet,
+			verifier: NewEd25519VerifyingKey("ed", edPublic),
+			validate: func(t *testing.T, r *http.Request, components []string, params map[string]string) {
+				require.Empty(t, r.Header.Get("Content-Digest"))
+				require.True(t, strings.HasPrefix(r.Header.Get("Signature"), "partner=:"))
+				require.Equal(t, []string{"@method", "@target-uri"}, components)
+				require.Equal(t, "app", params["tag"])
+				require.NotEmpty(t, params["expires"])
+			},
+		},
+		"base path- ECDSA over selected headers": {
+			cfg: SigningConfig{
+				Key:             NewECDSAP256SigningKey("ec", ecPrivate),
+				Components:      []string{"@method", "@authority", "@path", "content-type", "content-digest"},
+				DigestAlgorithm: "sha-512",
+				Nonce:           func() string { return "n1" },
+			},
+			method:   http.MethodPut,
+			body:     `{"name":"value"}`,
+			verifier: NewECDSAP256VerifyingKey("ec", &ecPrivate.PublicKey),
+			validate: func(t *testing.T, r *http.Request, components []string, params map[string]string) {
+				require.True(t, strings.HasPrefix(r.Header.Get("Content-Digest"), "sha-512=:"))
+				require.Equal(t, "n1", params["nonce"])
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			received := make(chan *http.Request, 1)
+			bodies := make(chan string, 1)
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				by, _ := ioutil.ReadAll(r.Body)
+				bodies <- string(by)
+				received <- r.Clone(context.Background())
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithSigning(tc.cfg))
+
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			headers := http.Header{"Content-Type": []string{"application/json"}}
+			status, _, gErr := bc.MakeRequest(context.Background(), tc.method, "/1", url.Values{"q": []string{"x"}}, headers, body)
+			require.Nil(t, gErr)
+			require.Equal(t, http.StatusOK, status)
+
+			r := <-received
+			require.Equal(t, tc.body, <-bodies)
+
+			label := tc.cfg.Label
+			if label == "" {
+				label = defaultSignatureLabel
+			}
+			inputs := parseSignatureDictionary(r.Header.Get("Signature-Input"))
+			require.Len(t, inputs, 1)
+			require.Equal(t, label, inputs[0].label)
+			components, params, err := parseSignatureParams(inputs[0].value)
+			require.NoError(t, err)
+
+			u, err := url.Parse(testServer.URL + r.URL.RequestURI())
+			require.NoError(t, err)
+			base, err := signatureBase(signedMessage{method: r.Method, url: u, host: r.Host, header: r.Header}, components, inputs[0].value)
+			require.NoError(t, err)
+			sig, err := signatureValue(r.Header.Values("Signature"), label)
+			require.NoError(t, err)
+			require.NoError(t, tc.verifier.Verify([]byte(base), sig))
+
+			tc.validate(t, r, components, params)
+		})
+	}
+}
+
+func TestUnit_WithSigningStreamingBody(t *testing.T) {
+	received := make(chan http.Header, 1)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		by, _ := ioutil.ReadAll(r.Body)
+		require.Equal(t, "{\"n\":1}\n", string(by))
+		received <- r.Header.Clone()
+	}))
+	defer testServer.Close()
+
+	finder := func(serviceName string, useTLS bool) (url.URL, error) {
+		u, err := url.Parse(testServer.URL)
+		return *u, err
+	}
+	bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithSigning(SigningConfig{Key: NewHMACSHA256Key("k", []byte("s"))}))
+
+	records := make(chan interface{}, 1)
+	records <- map[string]int{"n": 1}
+	close(records)
+	_, _, gErr := bc.MakeRequest(context.Background(), http.MethodPost, "/1", nil, nil, NewNDJSONChannelBody(records))
+	require.Nil(t, gErr)
+
+	sum := sha256.Sum256([]byte("{\"n\":1}\n"))
+	require.Equal(t, "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":", (<-received).Get("Content-Digest"))
+}
+
+func TestUnit_WithSigningRedirect(t *testing.T) {
+	tests := map[string]struct {
+		sameHost bool
+		validate func(t *testing.T, h http.Header)
+	}{
+		"base path- redirect on the same host signed": {
+			sameHost: true,
+			validate: func(t *testing.T, h http.Header) {
+				require.NotEmpty(t, h.Get("Signature"))
+				require.NotEmpty(t, h.Get("Signature-Input"))
+			},
+		},
+		"exceptional path- redirect to another host not signed": {
+			validate: func(t *testing.T, h http.Header) {
+				require.Empty(t, h.Get("Signature"))
+				require.Empty(t, h.Get("Signature-Input"))
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			received := make(chan http.Header, 1)
+			otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Header.Clone()
+			}))
+			defer otherServer.Close()
+
+			var testServer *httptest.Server
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/moved" {
+					received <- r.Header.Clone()
+					return
+				}
+				target := otherServer.URL
+				if tc.sameHost {
+					target = testServer.URL
+				}
+				http.Redirect(w, r, target+"/moved", http.StatusFound)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithSigning(SigningConfig{Key: NewHMACSHA256Key("k", []byte("s"))}))
+
+			_, _, gErr := bc.MakeRequest(context.Background(), http.MethodGet, "/1", nil, nil, nil)
+			require.Nil(t, gErr)
+			tc.validate(t, <-received)
+		})
+	}
+}
+
+// signResponse signs the response headers h as a service would
+func signResponse(t *testing.T, h http.Header, status int, body string, key SigningKey, components []string, now time.Time) {
+	sum := sha256.Sum256([]byte(body))
+	h.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+	params := signatureParams(components, SigningConfig{Key: key}, now)
+	base, err := signatureBase(signedMessage{status: status, header: h}, components, params)
+	require.NoError(t, err)
+	sig, err := key.Sign([]byte(base))
+	require.NoError(t, err)
+	h.Set("Signature-Input", "sig1="+params)
+	h.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+}
+
+func TestUnit_WithSignatureVerification(t *testing.T) {
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer := NewEd25519SigningKey("service", edPrivate)
+	verifier := NewEd25519VerifyingKey("service", edPublic)
+	body := `{"result":"ok"}`
+
+	tests := map[string]struct {
+		cfg      VerificationConfig
+		handler  func(t *testing.T, w http.ResponseWriter)
+		validate func(t *testing.T, response map[string]string, err glitch.DataError)
+	}{
+		"base path- signed response verified": {
+			handler: func(t *testing.T, w http.ResponseWriter) {
+				signResponse(t, w.Header(), http.StatusOK, body, signer, []string{"@status", "content-digest"}, time.Now())
+				_, _ = w.Write([]byte(body))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "ok", response["result"])
+			},
+		},
+		"exceptional path- unsigned response": {
+			handler: func(t *testing.T, w http.ResponseWriter) {
+				_, _ = w.Write([]byte(body))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorInvalidSignature, err.Code())
+			},
+		},
+		"exceptional path- body does not match its digest": {
+			handler: func(t *testing.T, w http.ResponseWriter) {
+				signResponse(t, w.Header(), http.StatusOK, body, signer, []string{"@status", "content-digest"}, time.Now())
+				_, _ = w.Write([]byte(`{"result":"forged"}`))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorInvalidSignature, err.Code())
+			},
+		},
+		"exceptional path- signed with an unknown key": {
+			handler: func(t *testing.T, w http.ResponseWriter) {
+				signResponse(t, w.Header(), http.StatusOK, body, NewHMACSHA256Key("other", []byte("s")), []string{"@status", "content-digest"}, time.Now())
+				_, _ = w.Write([]byte(body))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorInvalidSignature, err.Code())
+			},
+		},
+		"exceptional path- required component not covered": {
+			handler: func(t *testing.T, w http.ResponseWriter) {
+				signResponse(t, w.Header(), http.StatusOK, body, signer, []string{"@status"}, time.Now())
+				_, _ = w.Write([]byte(body))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorInvalidSignature, err.Code())
+			},
+		},
+		"exceptional path- signature too old": {
+			cfg: VerificationConfig{MaxAge: time.Minute},
+			handler: func(t *testing.T, w http.ResponseWriter) {
+				signResponse(t, w.Header(), http.StatusOK, body, signer, []string{"@status", "content-digest"}, time.Now().Add(-time.Hour))
+				_, _ = w.Write([]byte(body))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorInvalidSignature, err.Code())
+			},
+		},
+		"base path- signature created slightly in the future": {
+			handler: func(t *testing.T, w http.ResponseWriter) {
+				signResponse(t, w.Header(), http.StatusOK, body, signer, []string{"@status", "content-digest"}, time.Now().Add(10*time.Second))
+				_, _ = w.Write([]byte(body))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "ok", response["result"])
+			},
+		},
+		"exceptional path- signature created in the future": {
+			handler: func(t *testing.T, w http.ResponseWriter) {
+				signResponse(t, w.Header(), http.StatusOK, body, signer, []string{"@status", "content-digest"}, time.Now().Add(time.Hour))
+				_, _ = w.Write([]byte(body))
+			},
+			validate: func(t *testing.T, response map[string]string, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorInvalidSignature, err.Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tc.handler(t, w)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			cfg := tc.cfg
+			cfg.Keys = []VerifyingKey{verifier}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil, WithSignatureVerification(cfg))
+
+			response := map[string]string{}
+			err := bc.Do(context.Background(), http.MethodGet, "/1", nil, nil, nil, &response)
+			tc.validate(t, response, err)
+		})
+	}
+}
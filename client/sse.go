@@ -0,0 +1,277 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+// ErrorNotEventStream is the code of the error returned when a Server-Sent Events stream is answered with another type of
+// content
+const ErrorNotEventStream = "NOT_EVENT_STREAM"
+
+const (
+	defaultEventRetry   = 3 * time.Second
+	maxEventLineSize    = 1 << 20
+	eventStreamMimeType = "text/event-stream"
+)
+
+// Event is an event received from a Server-Sent Events stream
+type Event struct {
+	// ID is the last event ID seen on the stream when the event was dispatched
+	ID string
+	// Event is the type of the event; "message" unless the server named one
+	Event string
+	// Data is the payload of the event, with the lines of multi-line data joined by newlines
+	Data string
+	// Retry is the reconnection delay the server set while sending the event, if any
+	Retry time.Duration
+}
+
+// EventSourceConfig describes a Server-Sent Events stream
+type EventSourceConfig struct {
+	// Slug, Query, and Headers describe the GET request opening the stream
+	Slug    string
+	Query   url.Values
+	Headers http.Header
+	// LastEventID resumes the stream after the event with this ID
+	LastEventID string
+	// RetryInterval is the delay before reconnecting until the server sets one; defaults to 3s
+	RetryInterval time.Duration
+}
+
+// EventSource subscribes to a Server-Sent Events stream, reconnecting whenever the stream drops. Reconnections resume
+// from the last event received through Last-Event-ID and wait the retry interval the server asked for.
+type EventSource struct {
+	streamer Streamer
+	cfg      EventSourceConfig
+
+	mu  sync.Mutex
+	err glitch.DataError
+}
+
+// NewEventSource creates an EventSource reading the stream described by cfg through s
+func NewEventSource(s Streamer, cfg EventSourceConfig) *EventSource {
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = defaultEventRetry
+	}
+
+	return &EventSource{streamer: s, cfg: cfg}
+}
+
+// Listen calls fn with each event until ctx is done, the server ends the stream with 204 No Content, or the stream
+// cannot be opened. Failing to open the first connection, any response outside the 2xx range, or one whose Content-Type
+// is not text/event-stream, is returned as an error; connections dropped after that are retried. Listen returns nil once ctx is done.
+func (es *EventSource) Listen(ctx context.Context, fn func(Event)) glitch.DataError {
+	lastID := es.cfg.LastEventID
+	retry := es.cfg.RetryInterval
+	connected := false
+
+	for {
+		resp, responded, err := es.open(ctx, lastID)
+		switch {
+		case ctx.Err() != nil:
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil
+		case err != nil && (!connected || responded):
+			return err
+		case err == nil && resp.StatusCode == http.StatusNoContent:
+			resp.Body.Close()
+			return nil
+		case err == nil:
+			connected = true
+			p := &eventParser{lastID: lastID}
+			_ = p.parse(resp.Body, fn)
+			resp.Body.Close()
+
+			lastID = p.lastID
+			if p.retry > 0 {
+				retry = p.retry
+			}
+		}
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// Events delivers the events of the stream over a channel, which is closed once Listen would return. Err reports why
+// the stream ended.
+func (es *EventSource) Events(ctx context.Context) <-chan Event {
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+
+		err := es.Listen(ctx, func(e Event) {
+			select {
+			case events <- e:
+			case <-ctx.Done():
+			}
+		})
+
+		es.mu.Lock()
+		es.err = err
+		es.mu.Unlock()
+	}()
+
+	return events
+}
+
+// Err returns the error that ended the stream delivered by Events, if any
+func (es *EventSource) Err() glitch.DataError {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	return es.err
+}
+
+// open connects to the stream, reporting whether the service responded at all
+func (es *EventSource) open(ctx context.Context, lastID string) (*http.Response, bool, glitch.DataError) {
+	headers := cloneHeader(es.cfg.Headers)
+	headers.Set("Accept", eventStreamMimeType)
+	headers.Set("Cache-Control", "no-cache")
+	if lastID != "" {
+		headers.Set("Last-Event-ID", lastID)
+	}
+
+	ctx, md := ContextWithResponseMetadata(ctx)
+	resp, err := es.streamer.Stream(ctx, http.MethodGet, es.cfg.Slug, es.cfg.Query, headers, nil)
+	if err != nil {
+		return nil, md.StatusCode != 0, err
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		if mt, _, mErr := mime.ParseMediaType(resp.Header.Get("Content-Type")); mErr != nil || mt != eventStreamMimeType {
+			resp.Body.Close()
+			return nil, true, glitch.NewDataError(fmt.Errorf("content type %q is not %s", resp.Header.Get("Content-Type"), eventStreamMimeType), ErrorNotEventStream, "The service did not answer with an event stream")
+		}
+	}
+
+	return resp, true, nil
+}
+
+// eventParser reads events following the Server-Sent Events specification
+type eventParser struct {
+	lastID string
+	retry  time.Duration
+
+	event      string
+	data       strings.Builder
+	eventRetry time.Duration
+}
+
+// parse reads events from r, calling fn with each one, until r ends
+func (p *eventParser) parse(r io.Reader, fn func(Event)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxEventLineSize)
+	scanner.Split(scanEventLines)
+
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+			first = false
+		}
+		p.line(line, fn)
+	}
+
+	return scanner.Err()
+}
+
+func (p *eventParser) line(line string, fn func(Event)) {
+	if line == "" {
+		p.dispatch(fn)
+		return
+	}
+	if strings.HasPrefix(line, ":") {
+		return
+	}
+
+	field, value := line, ""
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+	}
+
+	switch field {
+	case "event":
+		p.event = value
+	case "data":
+		p.data.WriteString(value)
+		p.data.WriteByte('\n')
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			p.lastID = value
+		}
+	case "retry":
+		if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+			p.retry = time.Duration(ms) * time.Millisecond
+			p.eventRetry = p.retry
+		}
+	}
+}
+
+func (p *eventParser) dispatch(fn func(Event)) {
+	data := p.data.String()
+	event := p.event
+	retry := p.eventRetry
+	p.data.Reset()
+	p.event = ""
+	p.eventRetry = 0
+
+	if data == "" {
+		return
+	}
+	if event == "" {
+		event = "message"
+	}
+
+	fn(Event{ID: p.lastID, Event: event, Data: strings.TrimSuffix(data, "\n"), Retry: retry})
+}
+
+// scanEventLines splits a stream into lines ending in CRLF, LF, or CR
+func scanEventLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		// A CR may be followed by an LF that has not arrived yet
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
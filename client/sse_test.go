@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_eventParser(t *testing.T) {
+	tests := map[string]struct {
+		stream         string
+		expected       []Event
+		expectedLastID string
+		expectedRetry  time.Duration
+	}{
+		"base path- events with every field": {
+			stream: "\ufeff: comment\nid: 1\nevent: update\nretry: 250\ndata: first\ndata:second\n\ndata: plain\n\n",
+			expected: []Event{
+				{ID: "1", Event: "update", Data: "first\nsecond", Retry: 250 * time.Millisecond},
+				{ID: "1", Event: "message", Data: "plain"},
+			},
+			expectedLastID: "1",
+			expectedRetry:  250 * time.Millisecond,
+		},
+		"base path- CR and CRLF line endings": {
+			stream:         "id: 7\rdata: a\r\rdata: b\r\n\r\n",
+			expected:       []Event{{ID: "7", Event: "message", Data: "a"}, {ID: "7", Event: "message", Data: "b"}},
+			expectedLastID: "7",
+		},
+		"base path- events without data not dispatched": {
+			stream:         "event: ping\n\nid: 3\n\n",
+			expectedLastID: "3",
+		},
+		"base path- empty data dispatched": {
+			stream:   "data\n\n",
+			expected: []Event{{Event: "message", Data: ""}},
+		},
+		"exceptional path- incomplete event discarded": {
+			stream: "data: complete\n\ndata: cut off",
+			expected: []Event{
+				{Event: "message", Data: "complete"},
+			},
+		},
+		"exceptional path- invalid retry ignored": {
+			stream:   "retry: soon\ndata: x\n\n",
+			expected: []Event{{Event: "message", Data: "x"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var events []Event
+			p := &eventParser{}
+			require.NoError(t, p.parse(strings.NewReader(tc.stream), func(e Event) {
+				events = append(events, e)
+			}))
+			require.Equal(t, tc.expected, events)
+			require.Equal(t, tc.expectedLastID, p.lastID)
+			require.Equal(t, tc.expectedRetry, p.retry)
+		})
+	}
+}
+
+func TestUnit_EventSource(t *testing.T) {
+	tests := map[string]struct {
+		slug     string
+		validate func(t *testing.T, es *EventSource, connections *int32)
+	}{
+		"base path- reconnects from the last event": {
+			slug: "/events",
+			validate: func(t *testing.T, es *EventSource, connections *int32) {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				var events []Event
+				for e := range es.Events(ctx) {
+					events = append(events, e)
+					if len(events) == 3 {
+						cancel()
+					}
+				}
+				require.Nil(t, es.Err())
+				require.Equal(t, []string{"one", "two", "three"}, []string{events[0].Data, events[1].Data, events[2].Data})
+				require.Equal(t, "3", events[2].ID)
+				require.Equal(t, int32(2), atomic.LoadInt32(connections))
+			},
+		},
+		"base path- 204 ends the stream": {
+			slug: "/done",
+			validate: func(t *testing.T, es *EventSource, connections *int32) {
+				require.Nil(t, es.Listen(context.Background(), func(Event) {}))
+			},
+		},
+		"exceptional path- error response decoded": {
+			slug: "/missing",
+			validate: func(t *testing.T, es *EventSource, connections *int32) {
+				err := es.Listen(context.Background(), func(Event) {})
+				require.NotNil(t, err)
+				require.Equal(t, "NOT_FOUND", err.Code())
+			},
+		},
+		"exceptional path- response that is not an event stream": {
+			slug: "/json",
+			validate: func(t *testing.T, es *EventSource, connections *int32) {
+				err := es.Listen(context.Background(), func(Event) {})
+				require.NotNil(t, err)
+				require.Equal(t, ErrorNotEventStream, err.Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var connections int32
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/done":
+					w.WriteHeader(http.StatusNoContent)
+					return
+				case "/missing":
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = fmt.Fprintf(w, `{"code":"NOT_FOUND"}`)
+					return
+				case "/json":
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = fmt.Fprint(w, "data: not an event\n\n")
+					return
+				}
+
+				require.Equal(t, "text/event-stream", r.Header.Get("Accept"))
+				w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+				if atomic.AddInt32(&connections, 1) == 1 {
+					require.Empty(t, r.Header.Get("Last-Event-ID"))
+					_, _ = fmt.Fprint(w, "retry: 10\nid: 1\ndata: one\n\nid: 2\ndata: two\n\n")
+					return
+				}
+
+				require.Equal(t, "2", r.Header.Get("Last-Event-ID"))
+				_, _ = fmt.Fprint(w, "id: 3\ndata: three\n\n")
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 100*time.Millisecond, nil)
+
+			es := NewEventSource(bc.(Streamer), EventSourceConfig{Slug: tc.slug, RetryInterval: time.Minute})
+			tc.validate(t, es, &connections)
+		})
+	}
+}
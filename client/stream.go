@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+type streamKey struct{}
+
+// Streamer can make requests whose response bodies are read as they arrive instead of being buffered. Clients created
+// with NewBaseClient implement it.
+type Streamer interface {
+	// Stream makes a request and returns the response once its headers arrive. The caller must close the body of the
+	// response. Responses outside the 2xx range are returned as a glitch.DataError, decoded like those of Do.
+	Stream(ctx context.Context, method string, slug string, query url.Values, headers http.Header, body io.Reader) (*http.Response, glitch.DataError)
+}
+
+// Stream makes a request whose response body is left for the caller to read. The total timeout does not apply to
+// streams; cancel ctx to end one. Streamed responses bypass the cache and are never hedged.
+func (c *client) Stream(ctx context.Context, method string, slug string, query url.Values, headers http.Header, body io.Reader) (*http.Response, glitch.DataError) {
+	ctx = c.withRequestMetadata(ctx)
+	headers, gErr := c.requestIDHeader(ctx, c.requestHeaders(ctx, callOptionsFrom(ctx).applyHeaders(headers)))
+	if gErr != nil {
+		return nil, gErr
+	}
+
+	resp, gErr := c.stream(ctx, method, slug, query, headers, body)
+	if gErr != nil {
+		closeSeekerBody(body)
+		return nil, c.requestIDError(ctx, gErr)
+	}
+	if _, ok := body.(io.ReadSeeker); ok {
+		// The request body may be sent for as long as the response is read
+		resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { closeSeekerBody(body) }}
+	}
+
+	return resp, nil
+}
+
+// stream makes the request for Stream
+func (c *client) stream(ctx context.Context, method string, slug string, query url.Values, headers http.Header, body io.Reader) (*http.Response, glitch.DataError) {
+	t := c.timeoutsFor(ctx, slug)
+	t.Total = 0
+	sctx := context.WithValue(context.WithValue(ctx, timeoutsKey{}, t), streamKey{}, true)
+
+	req, gErr := c.newRequest(sctx, method, slug, query, headers, body)
+	if gErr != nil {
+		return nil, gErr
+	}
+
+	resp, err := c.client.Do(req)
+	c.recordRequestID(ctx, resp)
+	if err != nil {
+		return nil, requestError(err)
+	}
+
+	recordResponse(ctx, resp)
+	if o := callOptionsFrom(ctx); o != nil {
+		trackDownload(resp, o.progress)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+
+		ret, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, glitch.NewDataError(err, ErrorDecodingResponse, "Could not read response body")
+		}
+
+		msg := fmt.Sprintf("Error from %s to %s - %s", method, c.serviceName, slug)
+		if gErr := statusError(resp.StatusCode, ret, msg); gErr != nil {
+			return nil, gErr
+		}
+		return nil, glitch.NewDataError(fmt.Errorf("unexpected status %d", resp.StatusCode), ErrorUnexpectedStatus, msg)
+	}
+
+	return resp, nil
+}
+
+// isStream reports whether ctx belongs to a streamed request
+func isStream(ctx context.Context) bool {
+	stream, _ := ctx.Value(streamKey{}).(bool)
+	return stream
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// NDJSONMimeType is the content type of newline delimited JSON bodies
+const NDJSONMimeType = "application/x-ndjson"
+
+// ContentTyper is implemented by request bodies that know their content type. The client sends it as the
+// Content-Type header of requests whose headers do not already set one.
+type ContentTyper interface {
+	ContentType() string
+}
+
+// StreamingBody is a request body written by a function while it is being sent, through an io.Pipe, so it never has
+// to be held in memory. Writing starts when the body is first read. A StreamingBody can only be sent once: it is
+// never buffered, so a request sending one is never retried or hedged, and a 307 or 308 redirect is returned as is.
+type StreamingBody struct {
+	contentType string
+	write       func(w io.Writer) error
+
+	once sync.Once
+	pr   *io.PipeReader
+	pw   *io.PipeWriter
+}
+
+// NewStreamingBody creates a StreamingBody of the given content type whose content is written by write. An error
+// returned by write aborts the request.
+func NewStreamingBody(contentType string, write func(w io.Writer) error) *StreamingBody {
+	pr, pw := io.Pipe()
+	return &StreamingBody{contentType: contentType, write: write, pr: pr, pw: pw}
+}
+
+// Read reads the body, starting the function writing it on the first call
+func (b *StreamingBody) Read(p []byte) (int, error) {
+	b.once.Do(b.start)
+	return b.pr.Read(p)
+}
+
+// Close stops the function writing the body
+func (b *StreamingBody) Close() error {
+	err := b.pr.Close()
+	// Still run the writer so it can release what it holds; its writes fail at once
+	b.once.Do(b.start)
+	return err
+}
+
+// ContentType returns the content type of the body
+func (b *StreamingBody) ContentType() string {
+	return b.contentType
+}
+
+func (b *StreamingBody) start() {
+	go func() {
+		_ = b.pw.CloseWithError(b.write(b.pw))
+	}()
+}
+
+// NewNDJSONBody creates a newline delimited JSON body holding the records returned by next. next returns false once
+// there are no more records; an error aborts the request.
+func NewNDJSONBody(next func() (record interface{}, ok bool, err error)) *StreamingBody {
+	return NewStreamingBody(NDJSONMimeType, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		for {
+			record, ok, err := next()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return bw.Flush()
+			}
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// NewNDJSONChannelBody creates a newline delimited JSON body holding the records received from records until it is
+// closed
+func NewNDJSONChannelBody(records <-chan interface{}) *StreamingBody {
+	return NewNDJSONBody(func() (interface{}, bool, error) {
+		record, ok := <-records
+		return record, ok, nil
+	})
+}
+
+// MultipartPart is one part of a multipart/form-data body
+type MultipartPart struct {
+	// Name is the name of the form field
+	Name string
+	// FileName is the name of the uploaded file; leave it empty for plain fields
+	FileName string
+	// ContentType is the content type of the part. It defaults to application/octet-stream for files and is left out
+	// for plain fields.
+	ContentType string
+	// Content is the content of the part. It is closed once written if it is an io.Closer.
+	Content io.Reader
+}
+
+// MultipartField creates a plain form field
+func MultipartField(name string, value string) MultipartPart {
+	return MultipartPart{Name: name, Content: strings.NewReader(value)}
+}
+
+// MultipartFile creates a file upload read from content
+func MultipartFile(name string, fileName string, content io.Reader) MultipartPart {
+	return MultipartPart{Name: name, FileName: fileName, Content: content}
+}
+
+// NewMultipartBody creates a multipart/form-data body holding parts, in order. Its content type carries the boundary
+// separating the parts.
+func NewMultipartBody(parts ...MultipartPart) *StreamingBody {
+	parts = append([]MultipartPart(nil), parts...)
+	form := multipart.NewWriter(ioutil.Discard)
+
+	return NewStreamingBody(form.FormDataContentType(), func(w io.Writer) error {
+		defer closeParts(parts)
+
+		bw := bufio.NewWriter(w)
+		mw := multipart.NewWriter(bw)
+		if err := mw.SetBoundary(form.Boundary()); err != nil {
+			return err
+		}
+
+		for i, part := range parts {
+			pw, err := mw.CreatePart(part.header())
+			if err != nil {
+				return err
+			}
+			if part.Content != nil {
+				if _, err := io.Copy(pw, part.Content); err != nil {
+					return err
+				}
+			}
+			closePart(part)
+			parts[i].Content = nil
+		}
+
+		if err := mw.Close(); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+}
+
+func (p MultipartPart) header() textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(p.Name))
+	if p.FileName != "" {
+		disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(p.FileName))
+	}
+	h.Set("Content-Disposition", disposition)
+
+	switch {
+	case p.ContentType != "":
+		h.Set("Content-Type", p.ContentType)
+	case p.FileName != "":
+		h.Set("Content-Type", "application/octet-stream")
+	}
+
+	return h
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func closeParts(parts []MultipartPart) {
+	for _, part := range parts {
+		closePart(part)
+	}
+}
+
+func closePart(part MultipartPart) {
+	if c, ok := part.Content.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sprak3000/go-glitch/glitch"
+)
+
+type trackedReader struct {
+	io.Reader
+	closed int32
+}
+
+func (r *trackedReader) Close() error {
+	atomic.StoreInt32(&r.closed, 1)
+	return nil
+}
+
+func (r *trackedReader) isClosed() bool {
+	return atomic.LoadInt32(&r.closed) == 1
+}
+
+func TestUnit_StreamingBody(t *testing.T) {
+	tests := map[string]struct {
+		body     func() io.Reader
+		headers  http.Header
+		validate func(t *testing.T, r *http.Request, err glitch.DataError)
+	}{
+		"base path- NDJSON from an iterator": {
+			body: func() io.Reader {
+				i := 0
+				return NewNDJSONBody(func() (interface{}, bool, error) {
+					i++
+					return map[string]int{"id": i}, i <= 3, nil
+				})
+			},
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, NDJSONMimeType, r.Header.Get("Content-Type"))
+				require.Equal(t, int64(-1), r.ContentLength)
+
+				var lines []string
+				scanner := bufio.NewScanner(r.Body)
+				for scanner.Scan() {
+					lines = append(lines, scanner.Text())
+				}
+				require.Equal(t, []string{`{"id":1}`, `{"id":2}`, `{"id":3}`}, lines)
+			},
+		},
+		"base path- NDJSON from a channel": {
+			body: func() io.Reader {
+				records := make(chan interface{})
+				go func() {
+					defer close(records)
+					records <- "a"
+					records <- "b"
+				}()
+				return NewNDJSONChannelBody(records)
+			},
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				by, _ := ioutil.ReadAll(r.Body)
+				require.Equal(t, "\"a\"\n\"b\"\n", string(by))
+			},
+		},
+		"base path- explicit content type kept": {
+			body: func() io.Reader {
+				return NewNDJSONBody(func() (interface{}, bool, error) { return nil, false, nil })
+			},
+			headers: http.Header{"Content-Type": []string{"application/jsonl"}},
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, "application/jsonl", r.Header.Get("Content-Type"))
+			},
+		},
+		"base path- multipart fields and files": {
+			body: func() io.Reader {
+				return NewMultipartBody(
+					MultipartField("title", "report"),
+					MultipartFile("upload", `q"1".csv`, strings.NewReader("a,b\n1,2\n")),
+					MultipartPart{Name: "meta", FileName: "meta.json", ContentType: "application/json", Content: strings.NewReader(`{}`)},
+				)
+			},
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, int64(-1), r.ContentLength)
+				require.NoError(t, r.ParseMultipartForm(1<<20))
+				require.Equal(t, "report", r.FormValue("title"))
+
+				fh := r.MultipartForm.File["upload"][0]
+				require.Equal(t, `q"1".csv`, fh.Filename)
+				require.Equal(t, "application/octet-stream", fh.Header.Get("Content-Type"))
+				f, _ := fh.Open()
+				by, _ := ioutil.ReadAll(f)
+				require.Equal(t, "a,b\n1,2\n", string(by))
+
+				require.Equal(t, "application/json", r.MultipartForm.File["meta"][0].Header.Get("Content-Type"))
+			},
+		},
+		"exceptional path- iterator error aborts the request": {
+			body: func() io.Reader {
+				i := 0
+				return NewNDJSONBody(func() (interface{}, bool, error) {
+					i++
+					if i > 2 {
+						return nil, false, errors.New("source failed")
+					}
+					return i, true, nil
+				})
+			},
+			validate: func(t *testing.T, r *http.Request, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorRequestError, err.Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			received := make(chan *http.Request, 1)
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				by, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				copied := httptest.NewRequest(r.Method, r.URL.String(), strings.NewReader(string(by)))
+				copied.Header = r.Header.Clone()
+				copied.ContentLength = r.ContentLength
+				received <- copied
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil)
+
+			_, _, err := bc.MakeRequest(context.Background(), http.MethodPost, "/bulk", nil, tc.headers, tc.body())
+			var r *http.Request
+			select {
+			case r = <-received:
+			default:
+			}
+			tc.validate(t, r, err)
+		})
+	}
+}
+
+func TestUnit_StreamingBodyNotReplayed(t *testing.T) {
+	var attempts int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		_, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testServer.Close()
+
+	finder := func(serviceName string, useTLS bool) (url.URL, error) {
+		u, err := url.Parse(testServer.URL)
+		return *u, err
+	}
+	bc := NewBaseClient(finder, "foo", false, 10*time.Second, nil,
+		WithRetries(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+
+	file := &trackedReader{Reader: strings.NewReader("content")}
+	status, _, err := bc.MakeRequest(context.Background(), http.MethodPut, "/upload", nil, nil, NewMultipartBody(MultipartFile("f", "f.txt", file)))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	require.Eventually(t, file.isClosed, time.Second, time.Millisecond)
+}
+
+func TestUnit_StreamingBodyClose(t *testing.T) {
+	file := &trackedReader{Reader: strings.NewReader("content")}
+	b := NewMultipartBody(MultipartFile("f", "f.txt", file))
+	require.NoError(t, b.Close())
+
+	_, err := b.Read(make([]byte, 1))
+	require.Equal(t, io.ErrClosedPipe, err)
+	require.Eventually(t, file.isClosed, time.Second, time.Millisecond)
+}
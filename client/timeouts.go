@@ -0,0 +1,235 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Errors returned when a phase of a request takes longer than its timeout
+var (
+	ErrConnectTimeout        = errors.New("connect timeout exceeded")
+	ErrTLSHandshakeTimeout   = errors.New("TLS handshake timeout exceeded")
+	ErrResponseHeaderTimeout = errors.New("response header timeout exceeded")
+	ErrTotalTimeout          = errors.New("total timeout exceeded")
+)
+
+// Timeouts limits how long each phase of a request may take. A zero value inherits the timeout configured for the
+// route or the client; a negative value removes it.
+type Timeouts struct {
+	// Connect limits obtaining a connection, including the DNS lookup and TCP dial
+	Connect time.Duration
+	// TLSHandshake limits the TLS handshake of a new connection
+	TLSHandshake time.Duration
+	// ResponseHeader limits the wait for the response headers once the request has been written
+	ResponseHeader time.Duration
+	// Total limits the whole call, including reading the response body
+	Total time.Duration
+}
+
+type timeoutsKey struct{}
+
+type routeTimeouts struct {
+	template string
+	timeouts Timeouts
+}
+
+// WithTimeouts sets the timeouts of every call. The total timeout defaults to the timeout given to NewBaseClient.
+func WithTimeouts(t Timeouts) Option {
+	return func(c *client) {
+		c.timeouts = c.timeouts.merge(t)
+	}
+}
+
+// WithRouteTimeouts overrides the client's timeouts for calls to slugs matching template, such as /v1/reports/{id}.
+// When several templates match a slug the first one registered is used.
+func WithRouteTimeouts(template string, t Timeouts) Option {
+	return func(c *client) {
+		c.routeTimeouts = append(c.routeTimeouts, routeTimeouts{template: template, timeouts: t})
+	}
+}
+
+// ContextWithTimeouts returns a context whose calls use t, overriding the timeouts of the client and route. It is
+// shorthand for WithCallOptions(ctx, CallTimeouts(t)).
+func ContextWithTimeouts(ctx context.Context, t Timeouts) context.Context {
+	return WithCallOptions(ctx, CallTimeouts(t))
+}
+
+// merge returns t with the timeouts set in o taking precedence
+func (t Timeouts) merge(o Timeouts) Timeouts {
+	if o.Connect != 0 {
+		t.Connect = o.Connect
+	}
+	if o.TLSHandshake != 0 {
+		t.TLSHandshake = o.TLSHandshake
+	}
+	if o.ResponseHeader != 0 {
+		t.ResponseHeader = o.ResponseHeader
+	}
+	if o.Total != 0 {
+		t.Total = o.Total
+	}
+
+	return t
+}
+
+// timeoutsFor resolves the timeouts of a call to slug made with ctx
+func (c *client) timeoutsFor(ctx context.Context, slug string) Timeouts {
+	t := c.timeouts
+	for _, rt := range c.routeTimeouts {
+		if matchRoute(rt.template, slug) {
+			t = t.merge(rt.timeouts)
+			break
+		}
+	}
+	if o := callOptionsFrom(ctx); o != nil {
+		t = t.merge(o.timeouts)
+	}
+
+	return t
+}
+
+// callTimeout enforces the total timeout of a call
+type callTimeout struct {
+	parent context.Context
+	ctx    context.Context
+	total  time.Duration
+}
+
+// withTimeouts returns a context carrying the timeouts of a call to slug and bounded by its total timeout. The
+// returned cancel function must be called once the call is done.
+func (c *client) withTimeouts(ctx context.Context, slug string) (callTimeout, context.CancelFunc) {
+	t := c.timeoutsFor(ctx, slug)
+	tctx := context.WithValue(ctx, timeoutsKey{}, t)
+	if t.Total <= 0 {
+		return callTimeout{parent: ctx, ctx: tctx}, func() {}
+	}
+
+	tctx, cancel := context.WithTimeout(tctx, t.Total)
+	return callTimeout{parent: ctx, ctx: tctx, total: t.Total}, cancel
+}
+
+// err reports err as ErrTotalTimeout if the call was ended by its total timeout rather than by the caller
+func (ct callTimeout) err(err error) error {
+	if ct.total > 0 && ct.parent.Err() == nil && errors.Is(ct.ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %v: %v", ErrTotalTimeout, ct.total, err)
+	}
+
+	return err
+}
+
+// phaseTimeoutTransport enforces the connect, TLS handshake, and response header timeouts of each attempt
+type phaseTimeoutTransport struct {
+	next http.RoundTripper
+}
+
+func (t *phaseTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	to, _ := req.Context().Value(timeoutsKey{}).(Timeouts)
+	if to.Connect <= 0 && to.TLSHandshake <= 0 && to.ResponseHeader <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	pt := &phaseTimer{cancel: cancel}
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			pt.start(to.Connect, ErrConnectTimeout)
+		},
+		TLSHandshakeStart: func() {
+			pt.start(to.TLSHandshake, ErrTLSHandshakeTimeout)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			pt.stop()
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			pt.stop()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			pt.start(to.ResponseHeader, ErrResponseHeaderTimeout)
+		},
+		GotFirstResponseByte: func() {
+			pt.stop()
+		},
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	pt.stop()
+	if expired := pt.expired(); expired != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, expired
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: cancel}
+	return resp, nil
+}
+
+// phaseTimer runs the timeout of the current phase of a request, cancelling the request when it expires
+type phaseTimer struct {
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	timer *time.Timer
+	phase int
+	err   error
+}
+
+// start begins timing a phase, replacing any phase still being timed
+func (p *phaseTimer) start(d time.Duration, phaseErr error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopLocked()
+	if d <= 0 || p.err != nil {
+		return
+	}
+
+	phase := p.phase
+	p.timer = time.AfterFunc(d, func() {
+		p.mu.Lock()
+		expired := p.phase == phase && p.err == nil
+		if expired {
+			p.err = fmt.Errorf("%w after %v", phaseErr, d)
+		}
+		p.mu.Unlock()
+
+		if expired {
+			p.cancel()
+		}
+	})
+}
+
+// stop ends timing the current phase
+func (p *phaseTimer) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopLocked()
+}
+
+func (p *phaseTimer) stopLocked() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.phase++
+}
+
+// expired returns the error of the phase that ran out of time, if any
+func (p *phaseTimer) expired() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
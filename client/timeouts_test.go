@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sprak3000/go-glitch/glitch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_timeoutsFor(t *testing.T) {
+	tests := map[string]struct {
+		opts     []Option
+		ctx      context.Context
+		slug     string
+		expected Timeouts
+	}{
+		"base path- client timeout": {
+			slug:     "/v1/users/1",
+			expected: Timeouts{Total: 10 * time.Second},
+		},
+		"base path- client wide phase timeouts": {
+			opts:     []Option{WithTimeouts(Timeouts{Connect: time.Second})},
+			slug:     "/v1/users/1",
+			expected: Timeouts{Connect: time.Second, Total: 10 * time.Second},
+		},
+		"base path- route overrides client": {
+			opts: []Option{
+				WithTimeouts(Timeouts{Connect: time.Second}),
+				WithRouteTimeouts("/v1/reports/{id}", Timeouts{Total: time.Minute}),
+				WithRouteTimeouts("/v1/reports/{id}", Timeouts{Total: time.Hour}),
+			},
+			slug:     "/v1/reports/1",
+			expected: Timeouts{Connect: time.Second, Total: time.Minute},
+		},
+		"base path- call overrides route": {
+			opts: []Option{WithRouteTimeouts("/v1/reports/{id}", Timeouts{Total: time.Minute, ResponseHeader: time.Second})},
+			ctx: ContextWithTimeouts(
+				ContextWithTimeouts(context.Background(), Timeouts{Total: 2 * time.Minute}),
+				Timeouts{TLSHandshake: time.Second},
+			),
+			slug:     "/v1/reports/1",
+			expected: Timeouts{TLSHandshake: time.Second, ResponseHeader: time.Second, Total: 2 * time.Minute},
+		},
+		"base path- negative timeout removes an inherited one": {
+			ctx:      ContextWithTimeouts(context.Background(), Timeouts{Total: -1}),
+			slug:     "/v1/users/1",
+			expected: Timeouts{Total: -1},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			bc := NewBaseClient(nil, "foo", false, 10*time.Second, nil, tc.opts...).(*client)
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			require.Equal(t, tc.expected, bc.timeoutsFor(ctx, tc.slug))
+		})
+	}
+}
+
+func TestUnit_WithRouteTimeouts(t *testing.T) {
+	tests := map[string]struct {
+		opts          []Option
+		ctx           context.Context
+		callerTimeout time.Duration
+		slug          string
+		validate      func(t *testing.T, status int, err glitch.DataError)
+	}{
+		"base path- slow route allowed a longer timeout": {
+			opts: []Option{WithRouteTimeouts("/reports/{id}", Timeouts{Total: 5 * time.Second})},
+			slug: "/reports/1",
+			validate: func(t *testing.T, status int, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, http.StatusOK, status)
+			},
+		},
+		"base path- call allowed a longer timeout": {
+			ctx:  ContextWithTimeouts(context.Background(), Timeouts{Total: 5 * time.Second}),
+			slug: "/reports/1",
+			validate: func(t *testing.T, status int, err glitch.DataError) {
+				require.Nil(t, err)
+				require.Equal(t, http.StatusOK, status)
+			},
+		},
+		"exceptional path- client timeout exceeded": {
+			slug: "/reports/1",
+			validate: func(t *testing.T, status int, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorTotalTimeout, err.Code())
+			},
+		},
+		"exceptional path- total timeout exceeded while reading the body": {
+			slug: "/slow-body",
+			validate: func(t *testing.T, status int, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorTotalTimeout, err.Code())
+			},
+		},
+		"exceptional path- response header timeout exceeded": {
+			ctx:  ContextWithTimeouts(context.Background(), Timeouts{ResponseHeader: 50 * time.Millisecond, Total: 5 * time.Second}),
+			slug: "/reports/1",
+			validate: func(t *testing.T, status int, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorHeaderTimeout, err.Code())
+			},
+		},
+		"exceptional path- caller cancelling is not a timeout": {
+			callerTimeout: 50 * time.Millisecond,
+			opts:          []Option{WithRouteTimeouts("/reports/{id}", Timeouts{Total: 5 * time.Second})},
+			slug:          "/reports/1",
+			validate: func(t *testing.T, status int, err glitch.DataError) {
+				require.NotNil(t, err)
+				require.Equal(t, ErrorRequestError, err.Code())
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow-body" {
+					w.WriteHeader(http.StatusOK)
+					w.(http.Flusher).Flush()
+				}
+				select {
+				case <-r.Context().Done():
+				case <-time.After(300 * time.Millisecond):
+				}
+				_, _ = fmt.Fprintf(w, `{}`)
+			}))
+			defer testServer.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", false, 100*time.Millisecond, nil, tc.opts...)
+
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if tc.callerTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.callerTimeout)
+				defer cancel()
+			}
+			status, _, err := bc.MakeRequest(ctx, "GET", tc.slug, nil, nil, nil)
+			tc.validate(t, status, err)
+		})
+	}
+}
+
+func TestUnit_phaseTimeoutTransport(t *testing.T) {
+	// Accepts connections but never answers, stalling the TLS handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	tests := map[string]struct {
+		rt       http.RoundTripper
+		useTLS   bool
+		timeouts Timeouts
+		expected string
+	}{
+		"exceptional path- connect timeout exceeded": {
+			rt: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				},
+			},
+			timeouts: Timeouts{Connect: 50 * time.Millisecond},
+			expected: ErrorConnectTimeout,
+		},
+		"exceptional path- TLS handshake timeout exceeded": {
+			rt:       &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+			useTLS:   true,
+			timeouts: Timeouts{Connect: time.Second, TLSHandshake: 50 * time.Millisecond},
+			expected: ErrorTLSTimeout,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				scheme := "http"
+				if useTLS {
+					scheme = "https"
+				}
+				return url.URL{Scheme: scheme, Host: ln.Addr().String()}, nil
+			}
+			bc := NewBaseClient(finder, "foo", tc.useTLS, 5*time.Second, tc.rt, WithTimeouts(tc.timeouts))
+
+			start := time.Now()
+			_, _, err := bc.MakeRequest(context.Background(), "GET", "/1", nil, nil, nil)
+			require.NotNil(t, err)
+			require.Equal(t, tc.expected, err.Code())
+			require.Less(t, int64(time.Since(start)), int64(time.Second))
+		})
+	}
+}
@@ -0,0 +1,465 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultTLSReloadInterval = time.Minute
+
+var (
+	// ErrCertificatePin is returned when a service presents a certificate chain matching none of its pins
+	ErrCertificatePin = errors.New("certificate matches no pin")
+	// ErrTLSTransport is returned by every call of a client configured WithTLS over a round tripper that is not an
+	// *http.Transport, since the certificates cannot be applied to it
+	ErrTLSTransport = errors.New("WithTLS requires an *http.Transport")
+)
+
+// TLSConfig describes the certificates used for mutual TLS
+type TLSConfig struct {
+	// CertFile and KeyFile hold the PEM client certificate, with any intermediates, and its key. Leave them empty to
+	// connect without a client certificate.
+	CertFile string
+	KeyFile  string
+	// CAFile holds the PEM certificates services are verified against; defaults to the system roots
+	CAFile string
+	// ReloadInterval is how often the files are checked for changes; defaults to 1 minute, and a negative interval
+	// never checks
+	ReloadInterval time.Duration
+	// MinVersion is the lowest TLS version accepted; defaults to TLS 1.2
+	MinVersion uint16
+	// Services overrides verification for individual services, by service name
+	Services map[string]TLSServiceConfig
+	// OnReload, when set, is called after each reload with its error, or nil if it succeeded
+	OnReload func(err error)
+}
+
+// TLSServiceConfig overrides how one service is verified
+type TLSServiceConfig struct {
+	// ServerName is the name the service's certificate is verified against and sent for SNI, instead of its host
+	ServerName string
+	// Pins are base64 SHA-256 digests of SubjectPublicKeyInfo, written as "sha256/<base64>". When set, the
+	// certificate chain of the service must contain a public key matching one of them.
+	Pins []string
+}
+
+// TLSStatus describes the certificates in use, for monitoring
+type TLSStatus struct {
+	// CertificateExpiry is when the client certificate expires; zero without one
+	CertificateExpiry time.Time
+	// CAExpiry is when the first of the CA certificates expires; zero when the system roots are used
+	CAExpiry time.Time
+	// LoadedAt is when the certificates in use were loaded
+	LoadedAt time.Time
+	// LastError is the error of the last reload, if it failed; the previous certificates stay in use
+	LastError error
+}
+
+// TLSManager holds the certificates for mutual TLS and reloads them when their files change, so rotated certificates
+// are used for new connections without restarting. Share one manager between the clients of a process.
+type TLSManager struct {
+	cfg  TLSConfig
+	pins map[string][][]byte
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	roots  *x509.CertPool
+	status TLSStatus
+	stamps []fileStamp
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewTLSManager loads the certificates of cfg and, unless cfg.ReloadInterval is negative, starts watching their files
+func NewTLSManager(cfg TLSConfig) (*TLSManager, error) {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("CertFile and KeyFile must be set together")
+	}
+	if cfg.ReloadInterval == 0 {
+		cfg.ReloadInterval = defaultTLSReloadInterval
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	m := &TLSManager{cfg: cfg, pins: map[string][][]byte{}, stop: make(chan struct{})}
+	for service, sc := range cfg.Services {
+		for _, pin := range sc.Pins {
+			if !strings.HasPrefix(pin, "sha256/") {
+				return nil, fmt.Errorf("pin %q of service %s is not a sha256/ pin", pin, service)
+			}
+			digest, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+			if err != nil || len(digest) != sha256.Size {
+				return nil, fmt.Errorf("pin %q of service %s is not a base64 SHA-256 digest", pin, service)
+			}
+			m.pins[service] = append(m.pins[service], digest)
+		}
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadInterval > 0 {
+		go m.watch()
+	}
+
+	return m, nil
+}
+
+// WithTLS makes the client connect with the certificates of m, verifying the service as configured for its service
+// name. The round tripper given to NewBaseClient must be nil or an *http.Transport, which is copied; with any other,
+// every call fails with ErrTLSTransport rather than going out without the certificates.
+func WithTLS(m *TLSManager) Option {
+	return func(c *client) {
+		c.tls = m
+	}
+}
+
+// Reload loads the certificates from their files again. If they cannot be loaded the previous ones stay in use.
+func (m *TLSManager) Reload() error {
+	stamps := m.fileStamps()
+	err := m.load(stamps)
+	if m.cfg.OnReload != nil {
+		m.cfg.OnReload(err)
+	}
+
+	return err
+}
+
+// Status reports the certificates in use
+func (m *TLSManager) Status() TLSStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.status
+}
+
+// Close stops watching the files
+func (m *TLSManager) Close() {
+	m.once.Do(func() {
+		close(m.stop)
+	})
+}
+
+// ClientConfig returns the TLS configuration for connecting to service at host. Certificates reloaded later are used
+// by the connections made after the reload.
+func (m *TLSManager) ClientConfig(service string, host string) *tls.Config {
+	name := host
+	if sc := m.cfg.Services[service]; sc.ServerName != "" {
+		name = sc.ServerName
+	}
+	pins := m.pins[service]
+
+	return &tls.Config{
+		MinVersion:           m.cfg.MinVersion,
+		ServerName:           name,
+		GetClientCertificate: m.clientCertificate,
+		// The CA pool can change after the config is handed out, so the chain is verified against the current pool
+		// in VerifyConnection rather than by crypto/tls
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return m.verify(cs, name, pins)
+		},
+	}
+}
+
+// tunnelConfig returns the TLS configuration for connections to service tunnelled through a proxy, which net/http
+// makes without DialTLSContext. The host is then only known from the name sent for SNI, so services reached through a
+// proxy by IP address need a ServerName.
+func (m *TLSManager) tunnelConfig(service string) *tls.Config {
+	name := m.cfg.Services[service].ServerName
+	pins := m.pins[service]
+
+	return &tls.Config{
+		MinVersion:           m.cfg.MinVersion,
+		ServerName:           name,
+		GetClientCertificate: m.clientCertificate,
+		InsecureSkipVerify:   true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if cs.ServerName == "" {
+				return errors.New("the service name is unknown; set a ServerName to reach it by IP address through a proxy")
+			}
+			return m.verify(cs, cs.ServerName, pins)
+		},
+	}
+}
+
+// clientCertificate returns the client certificate in use, or an empty one to connect without
+func (m *TLSManager) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+
+	return m.cert, nil
+}
+
+// transport returns rt configured to connect to service with the certificates of m
+func (m *TLSManager) transport(rt http.RoundTripper, service string) http.RoundTripper {
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return &tlsUnsupportedTransport{rt: rt}
+	}
+
+	t = t.Clone()
+	// Direct connections go through the dialer, which verifies the host they are made to; connections through a proxy
+	// use TLSClientConfig
+	t.DialTLSContext = m.dialTLS(t, service)
+	t.TLSClientConfig = m.tunnelConfig(service)
+
+	return t
+}
+
+// tlsUnsupportedTransport fails every request, in place of a round tripper the certificates cannot be applied to
+type tlsUnsupportedTransport struct {
+	rt http.RoundTripper
+}
+
+func (t *tlsUnsupportedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	closeRequestBody(req)
+	return nil, fmt.Errorf("%w, not a %T", ErrTLSTransport, t.rt)
+}
+
+// dialTLS returns a dialer making TLS connections to service on behalf of t. Each connection gets a configuration for
+// the host it is made to, so the certificate is verified against it even when the host is an IP address.
+func (m *TLSManager) dialTLS(t *http.Transport, service string) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	protos := []string{"http/1.1"}
+	if t.ForceAttemptHTTP2 {
+		protos = []string{"h2", "http/1.1"}
+	}
+	timeout := t.TLSHandshakeTimeout
+
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		cfg := m.ClientConfig(service, host)
+		cfg.NextProtos = protos
+		tc := tls.Client(conn, cfg)
+
+		// The transport leaves tracing the handshake to custom dialers, and the phase timeouts rely on it
+		hctx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			hctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.TLSHandshakeStart != nil {
+			trace.TLSHandshakeStart()
+		}
+		err = tc.HandshakeContext(hctx)
+		if trace != nil && trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(tc.ConnectionState(), err)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return tc, nil
+	}
+}
+
+// verify checks the chain the service presented against the current CA pool, the server name name, and pins
+func (m *TLSManager) verify(cs tls.ConnectionState, name string, pins [][]byte) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("the service presented no certificate")
+	}
+
+	m.mu.RLock()
+	roots := m.roots
+	m.mu.RUnlock()
+
+	intermediates := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	chains, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		return err
+	}
+	if len(pins) == 0 {
+		return nil
+	}
+
+	for _, chain := range chains {
+		for _, c := range chain {
+			digest := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if string(digest[:]) == string(pin) {
+					return nil
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrCertificatePin, name)
+}
+
+// watch reloads the certificates whenever their files change
+func (m *TLSManager) watch() {
+	ticker := time.NewTicker(m.cfg.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		stamps := m.fileStamps()
+		m.mu.RLock()
+		changed := !stampsEqual(stamps, m.stamps)
+		m.mu.RUnlock()
+		if changed {
+			_ = m.Reload()
+		}
+	}
+}
+
+// load reads the certificate files, which were at the versions stamps, replacing the certificates in use
+func (m *TLSManager) load(stamps []fileStamp) error {
+	var cert *tls.Certificate
+	var expiry time.Time
+	if m.cfg.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(m.cfg.CertFile, m.cfg.KeyFile)
+		if err != nil {
+			return m.failed(fmt.Errorf("loading the client certificate: %w", err))
+		}
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return m.failed(fmt.Errorf("parsing the client certificate: %w", err))
+		}
+		c.Leaf = leaf
+		cert = &c
+		expiry = leaf.NotAfter
+	}
+
+	var roots *x509.CertPool
+	var caExpiry time.Time
+	if m.cfg.CAFile != "" {
+		bundle, err := ioutil.ReadFile(m.cfg.CAFile)
+		if err != nil {
+			return m.failed(fmt.Errorf("loading the CA bundle: %w", err))
+		}
+		cas, err := parsePEMCertificates(bundle)
+		if err != nil {
+			return m.failed(fmt.Errorf("parsing the CA bundle: %w", err))
+		}
+		roots = x509.NewCertPool()
+		for _, ca := range cas {
+			roots.AddCert(ca)
+			if caExpiry.IsZero() || ca.NotAfter.Before(caExpiry) {
+				caExpiry = ca.NotAfter
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cert = cert
+	m.roots = roots
+	m.stamps = stamps
+	m.status = TLSStatus{CertificateExpiry: expiry, CAExpiry: caExpiry, LoadedAt: time.Now()}
+
+	return nil
+}
+
+// failed records err as the outcome of the last reload
+func (m *TLSManager) failed(err error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status.LastError = err
+
+	return err
+}
+
+func (m *TLSManager) fileStamps() []fileStamp {
+	var stamps []fileStamp
+	for _, name := range []string{m.cfg.CertFile, m.cfg.KeyFile, m.cfg.CAFile} {
+		var s fileStamp
+		if name != "" {
+			if fi, err := os.Stat(name); err == nil {
+				s = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+			}
+		}
+		stamps = append(stamps, s)
+	}
+
+	return stamps
+}
+
+func stampsEqual(a []fileStamp, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parsePEMCertificates parses every certificate in a PEM bundle
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,354 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate is a certificate issued by a throwaway CA
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var testSerial int64
+
+func issueCertificate(t *testing.T, issuer *testCertificate, template x509.Certificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(atomic.AddInt64(&testSerial, 1))
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+	parent, signer := &template, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, parent, &key.PublicKey, signer)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCertificate{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T) *testCertificate {
+	return issueCertificate(t, nil, x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+}
+
+// writeFiles writes the certificate and key as PEM files in dir, returning their paths
+func (c *testCertificate) writeFiles(t *testing.T, dir string, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+
+	return certFile, keyFile
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func (c *testCertificate) pin() string {
+	digest := sha256.Sum256(c.cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(digest[:])
+}
+
+func TestUnit_WithTLS(t *testing.T) {
+	ca := newTestCA(t)
+	clientCert := issueCertificate(t, ca, x509.Certificate{Subject: pkix.Name{CommonName: "client"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	byIP := issueCertificate(t, ca, x509.Certificate{Subject: pkix.Name{CommonName: "server"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	byName := issueCertificate(t, ca, x509.Certificate{Subject: pkix.Name{CommonName: "server"}, DNSNames: []string{"orders.internal"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+
+	tests := map[string]struct {
+		server     *testCertificate
+		noCert     bool
+		service    TLSServiceConfig
+		expectFail bool
+		errorText  string
+	}{
+		"base path- mutual TLS": {
+			server: byIP,
+		},
+		"base path- server name override": {
+			server:  byName,
+			service: TLSServiceConfig{ServerName: "orders.internal"},
+		},
+		"base path- pinned key matches": {
+			server:  byIP,
+			service: TLSServiceConfig{Pins: []string{byName.pin(), byIP.pin()}},
+		},
+		"exceptional path- pinned key differs": {
+			server:     byIP,
+			service:    TLSServiceConfig{Pins: []string{byName.pin()}},
+			expectFail: true,
+			errorText:  ErrCertificatePin.Error(),
+		},
+		"exceptional path- certificate for another name": {
+			server:     byName,
+			expectFail: true,
+			errorText:  "127.0.0.1",
+		},
+		"exceptional path- no client certificate": {
+			server:     byIP,
+			noCert:     true,
+			expectFail: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			pool := x509.NewCertPool()
+			pool.AddCert(ca.cert)
+			testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "client", r.TLS.PeerCertificates[0].Subject.CommonName)
+			}))
+			testServer.TLS = &tls.Config{
+				Certificates: []tls.Certificate{tc.server.tlsCertificate()},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    pool,
+			}
+			testServer.StartTLS()
+			defer testServer.Close()
+
+			dir := t.TempDir()
+			caFile, _ := ca.writeFiles(t, dir, "ca")
+			cfg := TLSConfig{CAFile: caFile, Services: map[string]TLSServiceConfig{"foo": tc.service}}
+			if !tc.noCert {
+				cfg.CertFile, cfg.KeyFile = clientCert.writeFiles(t, dir, "client")
+			}
+			m, err := NewTLSManager(cfg)
+			require.NoError(t, err)
+			defer m.Close()
+
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				u, err := url.Parse(testServer.URL)
+				return *u, err
+			}
+			bc := NewBaseClient(finder, "foo", true, 10*time.Second, nil, WithTLS(m))
+
+			status, _, gErr := bc.MakeRequest(context.Background(), http.MethodGet, "/1", nil, nil, nil)
+			if tc.expectFail {
+				require.NotNil(t, gErr)
+				require.Equal(t, ErrorRequestError, gErr.Code())
+				require.Contains(t, gErr.Error(), tc.errorText)
+				return
+			}
+			require.Nil(t, gErr)
+			require.Equal(t, http.StatusOK, status)
+		})
+	}
+}
+
+func TestUnit_WithTLSThroughProxy(t *testing.T) {
+	ca := newTestCA(t)
+	clientCert := issueCertificate(t, ca, x509.Certificate{Subject: pkix.Name{CommonName: "client"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	byName := issueCertificate(t, ca, x509.Certificate{Subject: pkix.Name{CommonName: "server"}, DNSNames: []string{"orders.internal"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+
+	tests := map[string]struct {
+		host       string
+		service    TLSServiceConfig
+		rt         func(proxy *url.URL) http.RoundTripper
+		expectFail bool
+		errorText  string
+	}{
+		"base path- mutual TLS through a proxy": {
+			host: "orders.internal",
+		},
+		"base path- server name override through a proxy": {
+			host:    "127.0.0.1",
+			service: TLSServiceConfig{ServerName: "orders.internal"},
+		},
+		"exceptional path- pinned key differs through a proxy": {
+			host:       "orders.internal",
+			service:    TLSServiceConfig{Pins: []string{clientCert.pin()}},
+			expectFail: true,
+			errorText:  ErrCertificatePin.Error(),
+		},
+		"exceptional path- IP address through a proxy without a server name": {
+			host:       "127.0.0.1",
+			expectFail: true,
+			errorText:  "ServerName",
+		},
+		"exceptional path- round tripper TLS cannot be applied to": {
+			host: "orders.internal",
+			rt: func(proxy *url.URL) http.RoundTripper {
+				return struct{ http.RoundTripper }{&http.Transport{Proxy: http.ProxyURL(proxy)}}
+			},
+			expectFail: true,
+			errorText:  ErrTLSTransport.Error(),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			pool := x509.NewCertPool()
+			pool.AddCert(ca.cert)
+			testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "client", r.TLS.PeerCertificates[0].Subject.CommonName)
+			}))
+			testServer.TLS = &tls.Config{
+				Certificates: []tls.Certificate{byName.tlsCertificate()},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    pool,
+			}
+			testServer.StartTLS()
+			defer testServer.Close()
+
+			// The proxy tunnels every CONNECT to the test server, whatever host was asked for
+			var tunnels int32
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodConnect, r.Method)
+				atomic.AddInt32(&tunnels, 1)
+				upstream, err := net.Dial("tcp", testServer.Listener.Addr().String())
+				require.NoError(t, err)
+				w.WriteHeader(http.StatusOK)
+				conn, buf, err := w.(http.Hijacker).Hijack()
+				require.NoError(t, err)
+				go func() {
+					_, _ = io.Copy(upstream, buf)
+					upstream.Close()
+				}()
+				_, _ = io.Copy(conn, upstream)
+				conn.Close()
+			}))
+			defer proxy.Close()
+			proxyURL, err := url.Parse(proxy.URL)
+			require.NoError(t, err)
+
+			dir := t.TempDir()
+			caFile, _ := ca.writeFiles(t, dir, "ca")
+			certFile, keyFile := clientCert.writeFiles(t, dir, "client")
+			m, err := NewTLSManager(TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, Services: map[string]TLSServiceConfig{"foo": tc.service}})
+			require.NoError(t, err)
+			defer m.Close()
+
+			_, port, err := net.SplitHostPort(testServer.Listener.Addr().String())
+			require.NoError(t, err)
+			finder := func(serviceName string, useTLS bool) (url.URL, error) {
+				return url.URL{Scheme: "https", Host: net.JoinHostPort(tc.host, port)}, nil
+			}
+			var rt http.RoundTripper = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+			if tc.rt != nil {
+				rt = tc.rt(proxyURL)
+			}
+			bc := NewBaseClient(finder, "foo", true, 10*time.Second, rt, WithTLS(m))
+
+			status, _, gErr := bc.MakeRequest(context.Background(), http.MethodGet, "/1", nil, nil, nil)
+			if tc.expectFail {
+				require.NotNil(t, gErr)
+				require.Equal(t, ErrorRequestError, gErr.Code())
+				require.Contains(t, gErr.Error(), tc.errorText)
+				return
+			}
+			require.Nil(t, gErr)
+			require.Equal(t, http.StatusOK, status)
+			require.Equal(t, int32(1), atomic.LoadInt32(&tunnels))
+		})
+	}
+}
+
+func TestUnit_TLSManagerReload(t *testing.T) {
+	ca := newTestCA(t)
+	first := issueCertificate(t, ca, x509.Certificate{Subject: pkix.Name{CommonName: "client"}, NotAfter: time.Now().Add(time.Hour).Truncate(time.Second)})
+	second := issueCertificate(t, ca, x509.Certificate{Subject: pkix.Name{CommonName: "client"}, NotAfter: time.Now().Add(48 * time.Hour).Truncate(time.Second)})
+
+	dir := t.TempDir()
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	certFile, keyFile := first.writeFiles(t, dir, "client")
+
+	reloads := make(chan error, 10)
+	m, err := NewTLSManager(TLSConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		CAFile:         caFile,
+		ReloadInterval: 10 * time.Millisecond,
+		OnReload: func(err error) {
+			select {
+			case reloads <- err:
+			default:
+			}
+		},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, <-reloads)
+
+	status := m.Status()
+	require.True(t, first.cert.NotAfter.Equal(status.CertificateExpiry))
+	require.True(t, ca.cert.NotAfter.Equal(status.CAExpiry))
+	require.NoError(t, status.LastError)
+
+	// A rotated certificate is picked up without being asked for
+	second.writeFiles(t, dir, "client")
+	require.Eventually(t, func() bool {
+		return second.cert.NotAfter.Equal(m.Status().CertificateExpiry)
+	}, 5*time.Second, 10*time.Millisecond)
+	cert, err := m.ClientConfig("foo", "localhost").GetClientCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, second.cert.Raw, cert.Certificate[0])
+
+	// A broken file is reported and the previous certificate kept
+	require.NoError(t, ioutil.WriteFile(certFile, []byte("not a certificate"), 0600))
+	require.Error(t, m.Reload())
+	status = m.Status()
+	require.Error(t, status.LastError)
+	require.True(t, second.cert.NotAfter.Equal(status.CertificateExpiry))
+}
+
+func TestUnit_NewTLSManager(t *testing.T) {
+	tests := map[string]struct {
+		cfg TLSConfig
+	}{
+		"exceptional path- key without certificate": {
+			cfg: TLSConfig{KeyFile: "client.key"},
+		},
+		"exceptional path- pin of another algorithm": {
+			cfg: TLSConfig{Services: map[string]TLSServiceConfig{"foo": {Pins: []string{"sha1/AAAA"}}}},
+		},
+		"exceptional path- missing CA bundle": {
+			cfg: TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewTLSManager(tc.cfg)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnit_newUUIDv4(t *testing.T) {
+	v4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	tests := map[string]struct {
+		validate func(t *testing.T)
+	}{
+		"base path- version and variant bits set": {
+			validate: func(t *testing.T) {
+				u, err := newUUIDv4()
+				require.NoError(t, err)
+				require.Regexp(t, v4, u)
+			},
+		},
+		"base path- values are unique": {
+			validate: func(t *testing.T) {
+				a, err := newUUIDv4()
+				require.NoError(t, err)
+				b, err := newUUIDv4()
+				require.NoError(t, err)
+				require.NotEqual(t, a, b)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.validate(t)
+		})
+	}
+}
+
+func TestUnit_newUUIDv7(t *testing.T) {
+	v7 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	tests := map[string]struct {
+		validate func(t *testing.T)
+	}{
+		"base path- version and variant bits set": {
+			validate: func(t *testing.T) {
+				u, err := newUUIDv7(time.Now())
+				require.NoError(t, err)
+				require.Regexp(t, v7, u)
+			},
+		},
+		"base path- starts with the time in milliseconds": {
+			validate: func(t *testing.T) {
+				u, err := newUUIDv7(time.Unix(0, 0).Add(0x0123456789ab * time.Millisecond))
+				require.NoError(t, err)
+				require.True(t, strings.HasPrefix(u, "01234567-89ab-7"))
+			},
+		},
+		"base path- later values sort after earlier ones": {
+			validate: func(t *testing.T) {
+				now := time.Now()
+				a, err := newUUIDv7(now)
+				require.NoError(t, err)
+				b, err := newUUIDv7(now.Add(time.Millisecond))
+				require.NoError(t, err)
+				require.Less(t, a, b)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.validate(t)
+		})
+	}
+}